@@ -0,0 +1,210 @@
+// Package auth 为流式接口提供两种认证方式
+//
+//   - Bearer Token：Authorization: Bearer <token>，token 与本地密钥文件中的条目比对
+//   - 签名URL：后端用共享密钥为某个路径签发带过期时间的URL，
+//     浏览器的 EventSource 不能设置请求头，可以直接使用这种URL；
+//     sub 参数记录签发者的身份，使用签名URL的请求以这个身份认证
+//
+// 签名覆盖路径、除 sig 以外的全部查询参数（包括 sub）和过期时间，改动其中任何一项签名都会失效：
+//
+//	s := auth.Signer{Secret: []byte("...")}
+//	u, _ := url.Parse("/stream/pipeline?prompt=hi")
+//	s.Sign(u, "backend", time.Now().Add(time.Hour)) // → /stream/pipeline?exp=...&prompt=hi&sig=...&sub=backend
+package auth
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrMissing 表示请求既没有 Bearer Token 也没有签名
+	ErrMissing = errors.New("auth: missing credentials")
+	// ErrInvalidToken 表示 Bearer Token 不在密钥文件中
+	ErrInvalidToken = errors.New("auth: invalid bearer token")
+	// ErrBadSignature 表示签名不匹配或格式错误
+	ErrBadSignature = errors.New("auth: bad signature")
+	// ErrExpired 表示签名URL已过期
+	ErrExpired = errors.New("auth: signed url expired")
+)
+
+// 签名URL使用的查询参数
+const (
+	ParamExpires   = "exp"
+	ParamSignature = "sig"
+	ParamSubject   = "sub"
+)
+
+// ============ Bearer Token ============
+
+// Keys 是从密钥文件加载的 token 集合
+// 只保存 token 的 SHA-256，查找时先哈希再查表，比较时间与 token 内容无关
+type Keys struct {
+	names map[[sha256.Size]byte]string
+}
+
+// ParseKeys 解析密钥文件：每行 "<token> [名称]"，空行和 # 开头的行被忽略
+// 没有名称时用行号作为名称，名称会出现在日志里，token 本身不会
+func ParseKeys(data []byte) (*Keys, error) {
+	k := &Keys{names: make(map[[sha256.Size]byte]string)}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		token, name, _ := strings.Cut(line, " ")
+		name = strings.TrimSpace(name)
+		if name == "" {
+			name = "key-" + strconv.Itoa(n)
+		}
+		sum := sha256.Sum256([]byte(token))
+		if _, dup := k.names[sum]; dup {
+			return nil, fmt.Errorf("auth: line %d: duplicate token", n)
+		}
+		k.names[sum] = name
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// LoadKeys 读取并解析密钥文件
+func LoadKeys(path string) (*Keys, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseKeys(data)
+}
+
+// Lookup 返回 token 对应的名称
+func (k *Keys) Lookup(token string) (string, bool) {
+	if k == nil || token == "" {
+		return "", false
+	}
+	name, ok := k.names[sha256.Sum256([]byte(token))]
+	return name, ok
+}
+
+// Len 返回 token 数量
+func (k *Keys) Len() int {
+	if k == nil {
+		return 0
+	}
+	return len(k.names)
+}
+
+// BearerToken 从 Authorization 头取出 token
+func BearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// ============ 签名URL ============
+
+// Signer 用 HMAC-SHA256 签发和校验URL
+type Signer struct {
+	Secret []byte
+}
+
+// Sign 为 u 加上签发者、过期时间和签名（直接修改 u.RawQuery）
+// subject 为空时不带 sub，Authenticator 以 "signed:<路径>" 作为身份
+func (s Signer) Sign(u *url.URL, subject string, expires time.Time) {
+	q := u.Query()
+	q.Del(ParamSignature)
+	q.Del(ParamSubject)
+	if subject != "" {
+		q.Set(ParamSubject, subject)
+	}
+	q.Set(ParamExpires, strconv.FormatInt(expires.Unix(), 10))
+	q.Set(ParamSignature, s.sign(u.Path, q))
+	u.RawQuery = q.Encode()
+}
+
+// Verify 校验 u 的签名和过期时间
+func (s Signer) Verify(u *url.URL, now time.Time) error {
+	q := u.Query()
+	sig := q.Get(ParamSignature)
+	if sig == "" {
+		return ErrMissing
+	}
+	exp, err := strconv.ParseInt(q.Get(ParamExpires), 10, 64)
+	if err != nil {
+		return ErrBadSignature
+	}
+	q.Del(ParamSignature)
+	if !hmac.Equal([]byte(sig), []byte(s.sign(u.Path, q))) {
+		return ErrBadSignature
+	}
+	// 先校验签名再检查过期，伪造的 exp 不会得到"已过期"这样的提示
+	if now.Unix() > exp {
+		return ErrExpired
+	}
+	return nil
+}
+
+// sign 计算 path 和查询参数（已按 key 排序编码）的签名
+func (s Signer) sign(path string, q url.Values) string {
+	mac := hmac.New(sha256.New, s.Secret)
+	mac.Write([]byte(path))
+	mac.Write([]byte{'\n'})
+	mac.Write([]byte(q.Encode())) // Encode 按 key 排序，结果是确定的
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ============ 组合 ============
+
+// Authenticator 依次尝试 Bearer Token 和签名URL
+// Keys 为 nil 时不接受 Bearer Token，Signer.Secret 为空时不接受签名URL
+type Authenticator struct {
+	Keys   *Keys
+	Signer Signer
+	Now    func() time.Time // 测试用，nil 时为 time.Now
+}
+
+// Enabled 报告是否配置了任意一种认证方式
+func (a *Authenticator) Enabled() bool {
+	return a.Keys.Len() > 0 || len(a.Signer.Secret) > 0
+}
+
+// Authenticate 返回请求的身份：Bearer Token 对应的名称，或签名URL的签发者（sub）；
+// 没有 sub 的签名URL为 "signed:<路径>"
+func (a *Authenticator) Authenticate(r *http.Request) (string, error) {
+	if token, ok := BearerToken(r); ok {
+		if name, ok := a.Keys.Lookup(token); ok {
+			return name, nil
+		}
+		return "", ErrInvalidToken
+	}
+	if len(a.Signer.Secret) > 0 && r.URL.Query().Has(ParamSignature) {
+		now := time.Now
+		if a.Now != nil {
+			now = a.Now
+		}
+		if err := a.Signer.Verify(r.URL, now()); err != nil {
+			return "", err
+		}
+		if sub := r.URL.Query().Get(ParamSubject); sub != "" {
+			return sub, nil
+		}
+		return "signed:" + r.URL.Path, nil
+	}
+	return "", ErrMissing
+}
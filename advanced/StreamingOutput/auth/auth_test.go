@@ -0,0 +1,88 @@
+package auth
+
+import (
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestSignedURL(t *testing.T) {
+	s := Signer{Secret: []byte("secret")}
+	now := time.Unix(1_700_000_000, 0)
+
+	u, _ := url.Parse("/stream/pipeline?prompt=hi")
+	s.Sign(u, "backend", now.Add(time.Minute))
+	if err := s.Verify(u, now); err != nil {
+		t.Fatalf("Verify fresh url: %v", err)
+	}
+	if err := s.Verify(u, now.Add(2*time.Minute)); !errors.Is(err, ErrExpired) {
+		t.Fatalf("Verify expired url = %v, want ErrExpired", err)
+	}
+
+	tampered := []func(q url.Values, u *url.URL){
+		func(q url.Values, u *url.URL) { q.Set("prompt", "other") },
+		func(q url.Values, u *url.URL) { q.Set(ParamExpires, "9999999999") },
+		func(q url.Values, u *url.URL) { q.Set("generator", "openai") },
+		func(q url.Values, u *url.URL) { q.Set(ParamSubject, "admin") },
+		func(q url.Values, u *url.URL) { q.Del(ParamSubject) },
+		func(q url.Values, u *url.URL) { u.Path = "/stream/sse" },
+	}
+	for i, tamper := range tampered {
+		c := *u
+		q := c.Query()
+		tamper(q, &c)
+		c.RawQuery = q.Encode()
+		if err := s.Verify(&c, now); !errors.Is(err, ErrBadSignature) {
+			t.Errorf("tamper %d: Verify = %v, want ErrBadSignature", i, err)
+		}
+	}
+
+	if err := (Signer{Secret: []byte("other")}).Verify(u, now); !errors.Is(err, ErrBadSignature) {
+		t.Fatalf("Verify with another secret = %v", err)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	keys, err := ParseKeys([]byte("# 注释\n\ntok-1 backend\ntok-2\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+	a := &Authenticator{Keys: keys, Signer: Signer{Secret: []byte("s")}, Now: func() time.Time { return now }}
+
+	signed, _ := url.Parse("/stream/sse")
+	a.Signer.Sign(signed, "backend", now.Add(time.Hour))
+	anonymous, _ := url.Parse("/stream/sse")
+	a.Signer.Sign(anonymous, "", now.Add(time.Hour))
+
+	tests := []struct {
+		name, auth, target string
+		want               string
+		err                error
+	}{
+		{"named token", "Bearer tok-1", "/stream/sse", "backend", nil},
+		{"unnamed token", "bearer tok-2", "/stream/sse", "key-4", nil},
+		{"unknown token", "Bearer nope", "/stream/sse", "", ErrInvalidToken},
+		{"signed url", "", signed.String(), "backend", nil},
+		{"signed url without subject", "", anonymous.String(), "signed:/stream/sse", nil},
+		{"nothing", "", "/stream/sse", "", ErrMissing},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", tt.target, nil)
+		if tt.auth != "" {
+			r.Header.Set("Authorization", tt.auth)
+		}
+		got, err := a.Authenticate(r)
+		if got != tt.want || !errors.Is(err, tt.err) {
+			t.Errorf("%s: Authenticate = %q, %v; want %q, %v", tt.name, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestParseKeysDuplicate(t *testing.T) {
+	if _, err := ParseKeys([]byte("a x\na y\n")); err == nil {
+		t.Fatal("duplicate token accepted")
+	}
+}
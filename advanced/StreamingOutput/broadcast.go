@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"go-learning/advanced/StreamingOutput/event"
+	"go-learning/advanced/StreamingOutput/generator"
+	"go-learning/advanced/StreamingOutput/hub"
+	"go-learning/advanced/StreamingOutput/sse"
+)
+
+// ============ 广播：多个SSE订阅者共享一个生产者 ============
+//
+// /stream/sse 和 /stream/pipeline 每个请求都会启动自己的生成循环；
+// /stream/broadcast 则只有一个生产者，所有订阅者看到同一份token流。
+// 第一个订阅者加入时启动生产者，最后一个离开后生产者在本轮结束时退出。
+
+// broadcaster 管理共享的 hub 和按需启动的生产者
+type broadcaster struct {
+	hub *hub.Hub[event.Envelope]
+	gen generator.Generator
+	st  *event.Stream // 只由正在运行的生产者使用，每轮是一个新的流，序号跨轮递增
+
+	ctx    context.Context // stop 时取消，生产者随之退出
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	mu      sync.Mutex
+	running bool
+}
+
+// newBroadcaster 创建 broadcaster，生产者在第一个订阅者加入时启动
+func newBroadcaster(gen generator.Generator, opts hub.Options) *broadcaster {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &broadcaster{
+		hub:    hub.New[event.Envelope](opts),
+		gen:    gen,
+		st:     event.NewStream(event.Options{}),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+var (
+	broadcastOnce sync.Once
+	broadcastInst *broadcaster
+)
+
+// sharedBroadcaster 返回全局的 broadcaster，首次调用时根据配置创建
+func sharedBroadcaster() *broadcaster {
+	broadcastOnce.Do(func() {
+		policy, _ := hub.ParsePolicy(cfg.BroadcastPolicy) // 已在 Validate 中校验
+		gen, _ := generators.Get("echo")
+		broadcastInst = newBroadcaster(gen, hub.Options{Buffer: cfg.BroadcastBuffer, Policy: policy})
+	})
+	return broadcastInst
+}
+
+// subscribe 加入订阅并确保生产者在运行
+func (b *broadcaster) subscribe() *hub.Subscriber[event.Envelope] {
+	sub := b.hub.Subscribe()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.running && b.ctx.Err() == nil {
+		b.running = true
+		b.wg.Add(1)
+		go b.produce()
+	}
+	return sub
+}
+
+// stop 停止生产者并关闭 hub，订阅者的通道随之关闭；返回前等待生产者退出
+func (b *broadcaster) stop() {
+	b.cancel()
+	b.wg.Wait()
+	b.hub.Close()
+}
+
+// produce 一轮接一轮地生成并广播，没有订阅者时退出
+func (b *broadcaster) produce() {
+	defer b.wg.Done()
+	logger := slog.With("component", "broadcast")
+	logger.Info("生产者启动")
+	for round := 1; ; round++ {
+		// 与 subscribe 使用同一把锁检查，避免新订阅者加入时生产者恰好退出
+		b.mu.Lock()
+		if b.hub.Len() == 0 || b.ctx.Err() != nil {
+			b.running = false
+			b.mu.Unlock()
+			logger.Info("没有订阅者，生产者退出")
+			return
+		}
+		b.mu.Unlock()
+
+		// 每轮以心跳开始，流ID标明轮次；以 done 和 usage 结束
+		b.st.Restart("broadcast-" + strconv.Itoa(round))
+		b.hub.Publish(b.st.Heartbeat())
+		pipe := generateWithPipeline(b.ctx, b.gen, "广播第"+strconv.Itoa(round)+"轮", generator.Options{})
+		for token := range pipe.Tokens() {
+			b.hub.Publish(b.st.Delta(token))
+		}
+		for _, env := range b.st.End(event.ReasonComplete, "") {
+			b.hub.Publish(env)
+		}
+
+		generator.Sleep(b.ctx, cfg.SSEDelay)
+	}
+}
+
+// broadcastHandler 处理 /stream/broadcast：订阅共享的token流
+func broadcastHandler(w http.ResponseWriter, r *http.Request) {
+	sharedBroadcaster().serve(w, r)
+}
+
+// serve 让一个SSE客户端订阅 b 的token流
+func (b *broadcaster) serve(w http.ResponseWriter, r *http.Request) {
+	sw, err := sse.NewWriter(w, r)
+	if err != nil {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	sub := b.subscribe()
+	defer b.hub.Unsubscribe(sub)
+
+	ctx := r.Context()
+	logger := loggerFrom(ctx)
+	logger.Info("订阅者加入", "subscribers", b.hub.Len())
+	sw.Comment("joined broadcast")
+
+	// st 跟随转发的信封编号和统计用量，停止或关闭时接着生成这个订阅者自己的结束信封
+	st := newEventStream(ctx, 0)
+
+	for {
+		select {
+		case <-ctx.Done():
+			if reason, ok := stopReason(ctx); ok {
+				sendStopped(ctx, sw, st, reason)
+				logger.Info("订阅被停止", "reason", reason)
+				return
+			}
+			logger.Info("订阅者离开", "dropped", sub.Dropped())
+			return
+
+		case <-draining():
+			sendShutdown(ctx, sw, st)
+			return
+
+		case env, ok := <-sub.C():
+			if !ok {
+				// 被慢消费者策略断开，或 hub 已关闭
+				sendRecords(ctx, sw, record(st.Error("disconnected", sub.Err().Error(), 0)))
+				sendRecords(ctx, sw, endRecords(st, event.ReasonError, sub.Err().Error())...)
+				logger.Warn("订阅者被断开", "err", sub.Err())
+				return
+			}
+			st.Forward(env)
+			if err := sendRecords(ctx, sw, record(env)); err != nil {
+				return
+			}
+			if env.Type == event.Delta {
+				sentToken(ctx, "/stream/broadcast")
+			}
+		}
+	}
+}
+
+// broadcastStatsHandler 处理 /stream/broadcast/stats：返回订阅者加入/离开统计
+func broadcastStatsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sharedBroadcaster().hub.Stats())
+}
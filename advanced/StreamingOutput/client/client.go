@@ -0,0 +1,141 @@
+// Package client 是消费 StreamingOutput 各个流式接口的 Go 客户端
+//
+//	c := client.New("http://localhost:8080")
+//
+//	// SSE：断线后自动带着 Last-Event-ID 重连
+//	err := c.Events(ctx, "/stream/sse", client.EventOptions{}, func(ev sse.Event) error {
+//		if ev.Event == "usage" { // 信封流以 done 和 usage 结束（见 event 包）
+//			return client.ErrDone
+//		}
+//		fmt.Println(ev.ID, ev.Data)
+//		return nil
+//	})
+//
+//	// 分块文本：每次返回已到达的文本，不会把一个多字节字符拆到两块里
+//	tr, _ := c.Text(ctx, "/stream/text")
+//	defer tr.Close()
+//	for chunk, err := tr.Next(); err == nil; chunk, err = tr.Next() {
+//		fmt.Print(chunk)
+//	}
+//
+//	// JSON 数组：每到达一个元素就解码一个
+//	ad, _ := c.JSON(ctx, "/stream/json")
+//	defer ad.Close()
+//	var env event.Envelope
+//	for ad.Next(&env) == nil {
+//		if env.Delta != nil {
+//			fmt.Println(env.Seq, env.Delta.Content)
+//		}
+//	}
+//	if err := ad.Verify(); err != nil { ... } // 根据 trailer 判断是否被服务器中途截断
+//
+// 底层使用 httpconfig.NewProductionTransport 的连接池和超时配置，
+// 但不设置 http.Client.Timeout：它包含读取响应体的时间，会截断长时间的流。
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	httpconfig "go-learning/advanced/httpConfig"
+)
+
+// ErrDone 由 Events 的回调返回，表示正常结束，Events 返回 nil
+var ErrDone = errors.New("client: stream done")
+
+// Client 是流式接口的客户端，字段在首次请求后不应再修改
+type Client struct {
+	BaseURL string       // 服务地址，例如 http://localhost:8080
+	HTTP    *http.Client // 为 nil 时使用 New 创建的默认客户端
+	Token   string       // 非空时以 Authorization: Bearer 发送
+	Header  http.Header  // 每个请求额外携带的请求头
+}
+
+// New 创建使用生产环境 Transport 的客户端
+func New(baseURL string) *Client {
+	return &Client{
+		BaseURL: strings.TrimRight(baseURL, "/"),
+		HTTP:    &http.Client{Transport: httpconfig.NewProductionTransport()},
+	}
+}
+
+// StatusError 表示服务器返回了非 2xx 状态码
+type StatusError struct {
+	StatusCode int
+	Body       string        // 响应体的前 512 字节
+	RetryAfter time.Duration // Retry-After 响应头，没有时为 0
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("client: %d %s: %s", e.StatusCode, http.StatusText(e.StatusCode), strings.TrimSpace(e.Body))
+}
+
+// Temporary 报告稍后重试是否可能成功（429 / 502 / 503 / 504）
+func (e *StatusError) Temporary() bool {
+	switch e.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// get 发送 GET 请求，非 2xx 时读取部分响应体并返回 *StatusError
+func (c *Client) get(ctx context.Context, path, accept string, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.BaseURL+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range c.Header {
+		req.Header[k] = append([]string(nil), vs...)
+	}
+	for k, vs := range header {
+		req.Header[k] = append([]string(nil), vs...)
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+	if c.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.Token)
+	}
+
+	hc := c.HTTP
+	if hc == nil {
+		hc = http.DefaultClient
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return nil, &StatusError{
+			StatusCode: resp.StatusCode,
+			Body:       string(body),
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+	return resp, nil
+}
+
+// parseRetryAfter 解析秒数或 HTTP 日期格式的 Retry-After
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
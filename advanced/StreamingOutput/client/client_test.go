@@ -0,0 +1,216 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"go-learning/advanced/StreamingOutput/sse"
+)
+
+// TestEventsReconnect 服务器每个连接只发两条事件就断开，客户端带着 Last-Event-ID 重连直到收到全部
+func TestEventsReconnect(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		lastIDs []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw, _ := sse.NewWriter(w, r)
+		mu.Lock()
+		lastIDs = append(lastIDs, sw.LastEventID())
+		mu.Unlock()
+
+		start, _ := strconv.Atoi(sw.LastEventID())
+		sw.Send(sse.Event{Retry: 10 * time.Millisecond, Data: "hello"})
+		for i := start + 1; i <= start+2 && i <= 5; i++ {
+			sw.Send(sse.Event{ID: strconv.Itoa(i), Data: "msg " + strconv.Itoa(i)})
+		}
+		if start+2 >= 5 {
+			sw.Data("[DONE]")
+		}
+	}))
+	defer srv.Close()
+
+	var got []string
+	err := New(srv.URL).Events(context.Background(), "/", EventOptions{}, func(ev sse.Event) error {
+		if ev.Data == "[DONE]" {
+			return ErrDone
+		}
+		if strings.HasPrefix(ev.Data, "msg ") {
+			got = append(got, ev.ID)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(got, ",") != "1,2,3,4,5" {
+		t.Fatalf("ids = %v", got)
+	}
+	if strings.Join(lastIDs, ",") != ",2,4" {
+		t.Fatalf("Last-Event-ID sent = %q", lastIDs)
+	}
+}
+
+// TestEventsKeepLastIDAcrossEmptyConnection 连接在收到 id 之前断开，下一次重连仍带着之前的 Last-Event-ID
+func TestEventsKeepLastIDAcrossEmptyConnection(t *testing.T) {
+	var (
+		mu      sync.Mutex
+		lastIDs []string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw, _ := sse.NewWriter(w, r)
+		mu.Lock()
+		lastIDs = append(lastIDs, sw.LastEventID())
+		n := len(lastIDs)
+		mu.Unlock()
+
+		switch n {
+		case 1:
+			sw.Send(sse.Event{ID: "1", Data: "first", Retry: time.Millisecond})
+		case 2:
+			sw.Send(sse.Event{Data: "no id yet"}) // 没有 id 就断开
+		default:
+			sw.Data("[DONE]")
+		}
+	}))
+	defer srv.Close()
+
+	var ids []string
+	err := New(srv.URL).Events(context.Background(), "/", EventOptions{}, func(ev sse.Event) error {
+		if ev.Data == "[DONE]" {
+			return ErrDone
+		}
+		ids = append(ids, ev.ID)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(lastIDs, ",") != ",1,1" {
+		t.Fatalf("Last-Event-ID sent = %q", lastIDs)
+	}
+	if strings.Join(ids, ",") != "1,1" {
+		t.Fatalf("event ids = %q, want the id carried over", ids)
+	}
+}
+
+func TestEventsStatus(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		switch calls {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}))
+	defer srv.Close()
+
+	opts := EventOptions{RetryDelay: time.Millisecond}
+	if err := New(srv.URL).Events(context.Background(), "/", opts, func(sse.Event) error { return nil }); err != nil {
+		t.Fatalf("429 then 204: %v", err)
+	}
+
+	forbidden := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no", http.StatusForbidden)
+	}))
+	defer forbidden.Close()
+	var se *StatusError
+	err := New(forbidden.URL).Events(context.Background(), "/", opts, func(sse.Event) error { return nil })
+	if !errors.As(err, &se) || se.StatusCode != http.StatusForbidden {
+		t.Fatalf("403: %v", err)
+	}
+}
+
+// chunkedHandler 依次写出并刷新每一块
+func chunkedHandler(contentType string, chunks ...string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		for _, c := range chunks {
+			io.WriteString(w, c)
+			w.(http.Flusher).Flush()
+			time.Sleep(5 * time.Millisecond)
+		}
+	})
+}
+
+func TestTextKeepsRunesWhole(t *testing.T) {
+	s := "你好，世界"
+	b := []byte(s)
+	// 在多字节字符中间切开
+	srv := httptest.NewServer(chunkedHandler("text/plain", string(b[:4]), string(b[4:8]), string(b[8:])))
+	defer srv.Close()
+
+	tr, err := New(srv.URL).Text(context.Background(), "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tr.Close()
+	var all strings.Builder
+	for {
+		chunk, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.ContainsRune(s, []rune(chunk)[0]) || strings.ContainsRune(chunk, '�') {
+			t.Fatalf("chunk %q is not valid UTF-8 prefix", chunk)
+		}
+		all.WriteString(chunk)
+	}
+	if all.String() != s {
+		t.Fatalf("text = %q", all.String())
+	}
+}
+
+func TestJSONArrayIncremental(t *testing.T) {
+	type msg struct {
+		ID      int    `json:"id"`
+		Content string `json:"content"`
+	}
+	srv := httptest.NewServer(chunkedHandler("application/json",
+		"[\n", `{"id":1,"content":"a"}`, ",\n", `{"id":2,`, `"content":"b"}`, "\n]"))
+	defer srv.Close()
+
+	ad, err := New(srv.URL).JSON(context.Background(), "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ad.Close()
+	var got []string
+	for {
+		var m msg
+		err := ad.Next(&m)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, fmt.Sprint(m.ID, m.Content))
+	}
+	if strings.Join(got, ",") != "1a,2b" {
+		t.Fatalf("elements = %v", got)
+	}
+
+	// 数组在 ] 之前中断
+	ad = NewArrayDecoder(strings.NewReader(`[{"id":1},`))
+	var m msg
+	ad.Next(&m)
+	if err := ad.Next(&m); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("truncated array: %v", err)
+	}
+}
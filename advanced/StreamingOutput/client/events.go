@@ -0,0 +1,146 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+	"time"
+
+	"go-learning/advanced/StreamingOutput/sse"
+)
+
+// EventOptions 配置 Events 的重连行为
+type EventOptions struct {
+	LastEventID string        // 首次连接时发送的 Last-Event-ID，用于从断点继续
+	RetryDelay  time.Duration // 服务器没有通过 retry 字段建议间隔时的重连间隔，<=0 时为 3s
+	MaxDelay    time.Duration // 连续失败时指数退避的上限，<=0 时为 30s
+	MaxRetries  int           // 连续重连失败（没有收到任何事件）的次数上限，0 表示不限制，<0 表示不重连
+}
+
+// Events 订阅 SSE 接口，对每条事件调用 fn
+//
+// 连接断开（包括服务器正常结束响应）后等待 retry 间隔，带着最后收到的 id 作为 Last-Event-ID 重连，
+// 与浏览器 EventSource 的行为一致。以下情况返回：
+//   - fn 返回 ErrDone（返回 nil）或其他错误（原样返回）
+//   - ctx 被取消
+//   - 服务器返回 204（规范：停止重连，返回 nil）、非 text/event-stream 响应或不可重试的状态码
+//   - 连续失败次数超过 MaxRetries
+//
+// 429 / 503 等可重试的状态码按 Retry-After 等待后重连。
+func (c *Client) Events(ctx context.Context, path string, opts EventOptions, fn func(sse.Event) error) error {
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = 3 * time.Second
+	}
+	if opts.MaxDelay <= 0 {
+		opts.MaxDelay = 30 * time.Second
+	}
+
+	lastID := opts.LastEventID
+	retry := opts.RetryDelay
+	failures := 0
+	for {
+		received, err := c.eventsOnce(ctx, path, &lastID, &retry, fn)
+		if errors.Is(err, ErrDone) || errors.Is(err, errNoContent) {
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		var p permanentError
+		if errors.As(err, &p) {
+			return p.err
+		}
+		wait := retry
+		var se *StatusError
+		if errors.As(err, &se) {
+			if !se.Temporary() {
+				return err
+			}
+			if se.RetryAfter > 0 {
+				wait = se.RetryAfter
+			}
+		}
+
+		if received {
+			failures = 0
+		} else {
+			failures++
+			if opts.MaxRetries < 0 || (opts.MaxRetries > 0 && failures > opts.MaxRetries) {
+				if err == nil {
+					err = errors.New("client: stream closed without events")
+				}
+				return fmt.Errorf("client: giving up after %d attempts: %w", failures, err)
+			}
+			// 连续失败时指数退避，但不短于服务器建议的间隔
+			for i := 1; i < failures && wait < opts.MaxDelay; i++ {
+				wait *= 2
+			}
+			wait = max(min(wait, opts.MaxDelay), retry)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// errNoContent 表示服务器返回 204，按规范停止重连
+var errNoContent = errors.New("client: 204 no content")
+
+// permanentError 包装不应重连的错误：回调返回的错误、非 SSE 响应
+type permanentError struct{ err error }
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// eventsOnce 建立一次连接并读取到断开为止，返回是否收到过事件
+// lastID 和 retry 随事件更新，供下一次重连使用
+func (c *Client) eventsOnce(ctx context.Context, path string, lastID *string, retry *time.Duration,
+	fn func(sse.Event) error) (bool, error) {
+	header := http.Header{"Cache-Control": {"no-cache"}}
+	if *lastID != "" {
+		header.Set("Last-Event-ID", *lastID)
+	}
+	resp, err := c.get(ctx, path, sse.ContentType, header)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNoContent {
+		return false, errNoContent
+	}
+	if mt, _, _ := mime.ParseMediaType(resp.Header.Get("Content-Type")); mt != sse.ContentType {
+		return false, permanentError{fmt.Errorf("client: %s is not %s: %q", path, sse.ContentType, resp.Header.Get("Content-Type"))}
+	}
+
+	// 从上一个连接的 id 开始，这个连接在收到 id 之前断开时不会把它清空
+	dec := sse.NewDecoder(resp.Body)
+	dec.SetLastEventID(*lastID)
+	received := false
+	for {
+		ev, err := dec.Next()
+		// retry 和 id 即使没有分发事件也会生效
+		if dec.Retry() > 0 {
+			*retry = dec.Retry()
+		}
+		*lastID = dec.LastEventID()
+		if err != nil {
+			return received, err
+		}
+		received = true
+		if err := fn(ev); err != nil {
+			if errors.Is(err, ErrDone) {
+				return true, err
+			}
+			return true, permanentError{err}
+		}
+	}
+}
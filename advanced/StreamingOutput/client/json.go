@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+
+	"go-learning/advanced/StreamingOutput/jsonstream"
+)
+
+// ErrIncomplete 表示服务器通过 trailer 报告响应不完整，或者根本没有发送 trailer
+var ErrIncomplete = errors.New("client: incomplete JSON stream")
+
+// ArrayDecoder 增量解码一个JSON数组：每到达一个完整元素就可以解码，不必等到 ] 出现
+type ArrayDecoder struct {
+	body    io.Closer
+	resp    *http.Response // 通过 Client.JSON 创建时用于读取 trailer
+	src     *eofReader
+	dec     *json.Decoder
+	started bool
+	done    bool
+	count   int
+}
+
+// JSON 请求返回JSON数组流的接口（/stream/json）
+func (c *Client) JSON(ctx context.Context, path string) (*ArrayDecoder, error) {
+	resp, err := c.get(ctx, path, "application/json", nil)
+	if err != nil {
+		return nil, err
+	}
+	d := NewArrayDecoder(resp.Body)
+	d.resp = resp
+	return d, nil
+}
+
+// NewArrayDecoder 从 r 解码JSON数组，Close 时如果 r 实现了 io.Closer 则关闭它
+func NewArrayDecoder(r io.Reader) *ArrayDecoder {
+	src := &eofReader{r: r, sum: sha256.New()}
+	d := &ArrayDecoder{src: src, dec: json.NewDecoder(src)}
+	if c, ok := r.(io.Closer); ok {
+		d.body = c
+	}
+	return d
+}
+
+// Next 把下一个元素解码到 v；数组结束时返回 io.EOF
+// 数组在 ] 之前中断时返回 io.ErrUnexpectedEOF
+func (d *ArrayDecoder) Next(v any) error {
+	if d.done {
+		return io.EOF
+	}
+	if !d.started {
+		tok, err := d.dec.Token()
+		if err != nil {
+			return err
+		}
+		if delim, ok := tok.(json.Delim); !ok || delim != '[' {
+			return fmt.Errorf("client: expected JSON array, got %v", tok)
+		}
+		d.started = true
+	}
+
+	if !d.dec.More() {
+		tok, err := d.dec.Token()
+		if err != nil {
+			return d.truncated(err)
+		}
+		if delim, ok := tok.(json.Delim); !ok || delim != ']' {
+			return fmt.Errorf("client: expected end of JSON array, got %v", tok)
+		}
+		d.done = true
+		return io.EOF
+	}
+	if err := d.dec.Decode(v); err != nil {
+		return d.truncated(err)
+	}
+	d.count++
+	return nil
+}
+
+// Verify 在 Next 返回 io.EOF 之后调用，读完响应体并根据 jsonstream 的 trailer 检查响应是否完整：
+// 状态为 complete、元素个数与校验和一致时返回 nil，否则返回包装了 ErrIncomplete 的错误
+func (d *ArrayDecoder) Verify() error {
+	if d.resp == nil {
+		return fmt.Errorf("%w: no HTTP response to read trailers from", ErrIncomplete)
+	}
+	// trailer 在响应体读到 EOF 之后才可用
+	if _, err := io.Copy(io.Discard, d.src); err != nil {
+		return err
+	}
+	t := d.resp.Trailer
+	switch status := t.Get(jsonstream.TrailerStatus); status {
+	case jsonstream.StatusComplete:
+	case "":
+		return fmt.Errorf("%w: missing %s trailer", ErrIncomplete, jsonstream.TrailerStatus)
+	default:
+		return fmt.Errorf("%w: status %s: %s", ErrIncomplete, status, t.Get(jsonstream.TrailerError))
+	}
+	if n, _ := strconv.Atoi(t.Get(jsonstream.TrailerCount)); n != d.count {
+		return fmt.Errorf("%w: server sent %d items, decoded %d", ErrIncomplete, n, d.count)
+	}
+	if want, got := t.Get(jsonstream.TrailerChecksum), "sha256:"+hex.EncodeToString(d.src.sum.Sum(nil)); want != got {
+		return fmt.Errorf("%w: checksum mismatch", ErrIncomplete)
+	}
+	return nil
+}
+
+// truncated 在输入已经读完时把解码错误统一为 io.ErrUnexpectedEOF
+func (d *ArrayDecoder) truncated(err error) error {
+	if d.src.eof {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// eofReader 记录底层 Reader 是否已经读到结尾，并计算读到的全部字节的校验和
+type eofReader struct {
+	r   io.Reader
+	sum hash.Hash
+	eof bool
+}
+
+func (e *eofReader) Read(p []byte) (int, error) {
+	n, err := e.r.Read(p)
+	e.sum.Write(p[:n])
+	if err == io.EOF {
+		e.eof = true
+	}
+	return n, err
+}
+
+// Close 关闭响应体
+func (d *ArrayDecoder) Close() error {
+	if d.body == nil {
+		return nil
+	}
+	return d.body.Close()
+}
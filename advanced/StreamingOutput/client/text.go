@@ -0,0 +1,71 @@
+package client
+
+import (
+	"context"
+	"io"
+	"unicode/utf8"
+)
+
+// TextReader 逐块读取分块传输的文本响应
+type TextReader struct {
+	body    io.ReadCloser
+	buf     []byte
+	pending []byte // 上一块末尾不完整的 UTF-8 字节
+}
+
+// Text 请求返回纯文本流的接口（/stream/text、/stream/pipeline）
+func (c *Client) Text(ctx context.Context, path string) (*TextReader, error) {
+	resp, err := c.get(ctx, path, "text/plain", nil)
+	if err != nil {
+		return nil, err
+	}
+	return NewTextReader(resp.Body), nil
+}
+
+// NewTextReader 从 r 读取文本块，Close 时关闭 r
+func NewTextReader(r io.ReadCloser) *TextReader {
+	return &TextReader{body: r, buf: make([]byte, 32<<10)}
+}
+
+// Next 返回已经到达的下一段文本，至少包含一个完整的字符；流结束时返回 io.EOF
+// 块的边界取决于网络，不一定与服务器每次 Flush 的内容对应，
+// 但多字节字符不会被拆到两块中
+func (t *TextReader) Next() (string, error) {
+	for {
+		n, err := t.body.Read(t.buf)
+		data := append(t.pending, t.buf[:n]...)
+		t.pending = nil
+
+		if err != nil {
+			// 流结束时原样返回剩余字节，即使其中有不完整的字符
+			if len(data) > 0 {
+				return string(data), nil
+			}
+			return "", err
+		}
+
+		complete, rest := splitUTF8(data)
+		t.pending = append(t.pending, rest...)
+		if len(complete) > 0 {
+			return string(complete), nil
+		}
+	}
+}
+
+// Close 关闭响应体，提前结束时调用会断开连接，服务器随之停止生成
+func (t *TextReader) Close() error {
+	return t.body.Close()
+}
+
+// splitUTF8 把 b 拆成以完整字符结尾的部分和末尾不完整的字节
+func splitUTF8(b []byte) (complete, rest []byte) {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i], b[i:]
+			}
+			break
+		}
+	}
+	return b, nil
+}
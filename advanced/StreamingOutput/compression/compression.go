@@ -0,0 +1,221 @@
+// Package compression 为流式响应提供协商的 gzip / deflate 压缩
+//
+// 与普通的压缩中间件不同，每次 http.Flusher.Flush() 都会先同步刷新压缩器
+// （gzip.Writer.Flush 会输出一个 sync flush 块），再刷新底层连接，
+// 所以客户端每收到一个块就能解压出截止到该点的全部内容，流式延迟不受影响。
+//
+// 最小长度阈值：未刷新时先缓冲，缓冲达到 MinSize 才开始压缩；
+// 响应在此之前就结束（或声明了更小的 Content-Length）则原样发送。
+// 处理器在达到阈值前调用 Flush 说明它是流式响应，此时直接开始压缩。
+package compression
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Options 配置压缩中间件
+type Options struct {
+	MinSize int // 小于该字节数的非流式响应不压缩，<=0 时为 256
+	Level   int // 压缩级别，0 时为 gzip.DefaultCompression
+}
+
+// Handler 为 next 添加压缩，不需要压缩的路由不要用它包装即可（例如 WebSocket）
+func Handler(next http.Handler, opts Options) http.Handler {
+	if opts.MinSize <= 0 {
+		opts.MinSize = 256
+	}
+	if opts.Level == 0 {
+		opts.Level = gzip.DefaultCompression
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := Negotiate(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &responseWriter{ResponseWriter: w, encoding: encoding, opts: opts}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// Negotiate 根据 Accept-Encoding 选择 gzip 或 deflate，都不接受时返回空字符串
+// 支持 q 值，q=0 表示明确拒绝；同等权重时优先 gzip
+func Negotiate(acceptEncoding string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+		if name == "*" {
+			name = "gzip"
+		}
+		if (name != "gzip" && name != "deflate") || q <= 0 {
+			continue
+		}
+		if q > bestQ || (q == bestQ && name == "gzip") {
+			best, bestQ = name, q
+		}
+	}
+	return best
+}
+
+// compressor 是 gzip.Writer 和 flate.Writer 的共同接口
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+}
+
+// responseWriter 缓冲到阈值后决定是否压缩
+type responseWriter struct {
+	http.ResponseWriter
+	encoding string
+	opts     Options
+
+	status      int
+	buf         []byte     // 决定之前缓冲的内容
+	decided     bool       // 是否已经决定（并发送了响应头）
+	passthrough bool       // 决定不压缩
+	zw          compressor // 决定压缩后使用
+}
+
+func (cw *responseWriter) WriteHeader(status int) {
+	if cw.status == 0 {
+		cw.status = status
+	}
+}
+
+func (cw *responseWriter) Write(p []byte) (int, error) {
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if !cw.decided {
+		cw.buf = append(cw.buf, p...)
+		if len(cw.buf) < cw.opts.MinSize && !cw.skip() {
+			return len(p), nil
+		}
+		if err := cw.decide(len(cw.buf) >= cw.opts.MinSize); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+	if cw.passthrough {
+		return cw.ResponseWriter.Write(p)
+	}
+	return cw.zw.Write(p)
+}
+
+// skip 判断响应是否不应压缩：处理器自己设置了 Content-Encoding，
+// 或者声明了小于阈值的 Content-Length，或者状态码不带响应体
+func (cw *responseWriter) skip() bool {
+	h := cw.Header()
+	if h.Get("Content-Encoding") != "" {
+		return true
+	}
+	if n, err := strconv.Atoi(h.Get("Content-Length")); err == nil && n < cw.opts.MinSize {
+		return true
+	}
+	return cw.status == http.StatusNoContent || cw.status == http.StatusNotModified
+}
+
+// decide 发送响应头并写出缓冲内容
+func (cw *responseWriter) decide(compress bool) error {
+	cw.decided = true
+	if cw.status == 0 {
+		cw.status = http.StatusOK
+	}
+	if !compress || cw.skip() {
+		cw.passthrough = true
+		cw.ResponseWriter.WriteHeader(cw.status)
+		if len(cw.buf) > 0 {
+			_, err := cw.ResponseWriter.Write(cw.buf)
+			cw.buf = nil
+			return err
+		}
+		return nil
+	}
+
+	h := cw.Header()
+	h.Set("Content-Encoding", cw.encoding)
+	h.Del("Content-Length") // 压缩后长度未知
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	var err error
+	if cw.encoding == "gzip" {
+		cw.zw, err = gzip.NewWriterLevel(cw.ResponseWriter, cw.opts.Level)
+	} else {
+		cw.zw, err = flate.NewWriter(cw.ResponseWriter, cw.opts.Level)
+	}
+	if err != nil {
+		return err
+	}
+	if len(cw.buf) > 0 {
+		_, err = cw.zw.Write(cw.buf)
+		cw.buf = nil
+	}
+	return err
+}
+
+// Flush 实现 http.Flusher：先同步刷新压缩器，再刷新底层连接
+func (cw *responseWriter) Flush() {
+	if !cw.decided {
+		// 达到阈值前就刷新，说明是流式响应，直接开始压缩
+		if err := cw.decide(true); err != nil {
+			return
+		}
+	}
+	if cw.zw != nil {
+		if err := cw.zw.Flush(); err != nil {
+			return
+		}
+	}
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close 写出压缩尾部；未达到阈值的响应原样发送
+func (cw *responseWriter) Close() error {
+	if !cw.decided {
+		if cw.status == 0 {
+			// 处理器什么都没写，交给 net/http 默认处理
+			return nil
+		}
+		return cw.decide(false)
+	}
+	if cw.zw != nil {
+		return cw.zw.Close()
+	}
+	return nil
+}
+
+// Hijack 在尚未写出任何内容时允许接管连接
+func (cw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hj, ok := cw.ResponseWriter.(http.Hijacker); ok && !cw.decided {
+		cw.decided, cw.passthrough = true, true
+		return hj.Hijack()
+	}
+	return nil, nil, http.ErrNotSupported
+}
+
+// Unwrap 让 http.ResponseController 可以访问底层的 ResponseWriter
+func (cw *responseWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,82 @@
+package compression
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := map[string]string{
+		"":                          "",
+		"gzip, deflate, br":         "gzip",
+		"deflate":                   "deflate",
+		"gzip;q=0.5, deflate;q=0.8": "deflate",
+		"gzip;q=0, identity":        "",
+		"*":                         "gzip",
+	}
+	for in, want := range tests {
+		if got := Negotiate(in); got != want {
+			t.Errorf("Negotiate(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestMinSize(t *testing.T) {
+	h := Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.Query().Get("body"))
+	}), Options{MinSize: 10})
+
+	for body, compressed := range map[string]bool{"short": false, "long enough body": true} {
+		r := httptest.NewRequest(http.MethodGet, "/?body="+strings.ReplaceAll(body, " ", "+"), nil)
+		r.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, r)
+
+		if got := rec.Header().Get("Content-Encoding") == "gzip"; got != compressed {
+			t.Errorf("body %q: compressed = %v, want %v", body, got, compressed)
+		}
+	}
+}
+
+// TestFlushPerChunk 每次 Flush 之后客户端都能解压出已写入的内容，而不是等到响应结束
+func TestFlushPerChunk(t *testing.T) {
+	next := make(chan struct{})
+	srv := httptest.NewServer(Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		for _, chunk := range []string{"第一块\n", "第二块\n", "第三块\n"} {
+			io.WriteString(w, chunk)
+			w.(http.Flusher).Flush()
+			<-next // 等客户端读到这一块再继续
+		}
+	}), Options{}))
+	defer srv.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	resp, err := (&http.Transport{DisableCompression: true}).RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Content-Encoding = %q", resp.Header.Get("Content-Encoding"))
+	}
+
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	br := bufio.NewReader(zr)
+	for _, want := range []string{"第一块\n", "第二块\n", "第三块\n"} {
+		line, err := br.ReadString('\n')
+		if err != nil || line != want {
+			t.Fatalf("ReadString() = %q, %v; want %q", line, err, want)
+		}
+		next <- struct{}{}
+	}
+}
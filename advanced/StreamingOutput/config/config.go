@@ -0,0 +1,259 @@
+// Package config 把命令行参数、环境变量和配置文件填充到带标签的结构体中
+//
+// 优先级从低到高：结构体中的默认值 < 配置文件 < 环境变量 < 命令行参数。
+//
+// 字段通过标签声明：
+//
+//	type Config struct {
+//		Addr  string        `config:"addr" usage:"监听地址"`
+//		Delay time.Duration `config:"sse-delay" usage:"SSE消息间隔"`
+//		Key   string        `config:"api-key" secret:"true"`
+//	}
+//
+// config 是键名，同时作为命令行参数名（-sse-delay）和配置文件中的键；
+// 环境变量名为前缀 + 大写键名，- 换成 _（如 STREAM_SSE_DELAY）。
+// 支持的字段类型：string、bool、int、int64、float64、time.Duration。
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 值的来源
+const (
+	SourceDefault = "default"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+	SourceFlag    = "flag"
+)
+
+// Loader 描述如何加载配置
+type Loader struct {
+	EnvPrefix string                            // 环境变量前缀，如 "STREAM_"
+	LookupEnv func(key string) (string, bool)   // 读取环境变量，通常为 os.LookupEnv；nil 时不读环境变量
+	ReadFile  func(path string) ([]byte, error) // 读取配置文件，通常为 os.ReadFile
+}
+
+// Field 是一个配置项的当前值及来源，用于调试输出
+type Field struct {
+	Key    string `json:"key"`
+	Value  any    `json:"value"`
+	Source string `json:"source"`
+	Env    string `json:"env"`
+	Usage  string `json:"usage,omitempty"`
+}
+
+type field struct {
+	key    string
+	env    string
+	usage  string
+	secret bool
+	value  reflect.Value
+	source string
+}
+
+// Load 把 args 解析到 fs 中并按优先级填充 cfg（指向结构体的指针）
+// 配置文件路径来自 -config 参数或 {EnvPrefix}CONFIG 环境变量，都没有时不读文件。
+// 返回的 []Field 按键名排序，记录每一项的来源。
+func (l Loader) Load(cfg any, fs *flag.FlagSet, args []string) ([]Field, error) {
+	fields, err := l.fields(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// 1. 注册并解析命令行参数：先只记录字符串，最后再按优先级应用
+	flagValues := make(map[string]string)
+	for _, f := range fields {
+		f := f
+		usage := fmt.Sprintf("%s (默认 %v, 环境变量 %s)", f.usage, f.value.Interface(), f.env)
+		set := func(s string) error {
+			if err := setValue(f.value.Addr().Interface(), s); err != nil {
+				return err
+			}
+			flagValues[f.key] = s
+			return nil
+		}
+		// bool 字段可以只写 -compress，与 flag.Bool 一致；-compress=false 仍然可用
+		if f.value.Kind() == reflect.Bool {
+			fs.BoolFunc(f.key, usage, set)
+		} else {
+			fs.Func(f.key, usage, set)
+		}
+	}
+	configPath := fs.String("config", "", "配置文件路径（.json 或 key: value 格式）")
+	// 解析时 fs.Func 已经写入了字段；先恢复默认值，再按优先级重新应用
+	defaults := snapshot(fields)
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+	restore(fields, defaults)
+
+	var errs []error
+	byKey := make(map[string]*field, len(fields))
+	for _, f := range fields {
+		byKey[f.key] = f
+	}
+
+	// 2. 配置文件
+	path := *configPath
+	if path == "" && l.LookupEnv != nil {
+		path, _ = l.LookupEnv(l.EnvPrefix + "CONFIG")
+	}
+	if path != "" {
+		values, err := l.readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		for _, kv := range values {
+			f, ok := byKey[kv.key]
+			if !ok {
+				errs = append(errs, fmt.Errorf("%s: unknown key %q", kv.pos(path), kv.key))
+				continue
+			}
+			if err := setValue(f.value.Addr().Interface(), kv.value); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %s: %w", kv.pos(path), kv.key, err))
+				continue
+			}
+			f.source = SourceFile
+		}
+	}
+
+	// 3. 环境变量
+	if l.LookupEnv != nil {
+		for _, f := range fields {
+			s, ok := l.LookupEnv(f.env)
+			if !ok {
+				continue
+			}
+			if err := setValue(f.value.Addr().Interface(), s); err != nil {
+				errs = append(errs, fmt.Errorf("env %s: %w", f.env, err))
+				continue
+			}
+			f.source = SourceEnv
+		}
+	}
+
+	// 4. 命令行参数（已在解析时校验过格式）
+	for key, s := range flagValues {
+		f := byKey[key]
+		setValue(f.value.Addr().Interface(), s)
+		f.source = SourceFlag
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return describe(fields), nil
+}
+
+// fields 通过反射收集带 config 标签的字段
+func (l Loader) fields(cfg any) ([]*field, error) {
+	v := reflect.ValueOf(cfg)
+	if v.Kind() != reflect.Pointer || v.Elem().Kind() != reflect.Struct {
+		return nil, errors.New("config: cfg must be a pointer to struct")
+	}
+	v = v.Elem()
+	t := v.Type()
+
+	var fields []*field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key := sf.Tag.Get("config")
+		if key == "" {
+			continue
+		}
+		if err := setValue(v.Field(i).Addr().Interface(), ""); errors.Is(err, errUnsupported) {
+			return nil, fmt.Errorf("config: field %s: %w", sf.Name, err)
+		}
+		fields = append(fields, &field{
+			key:    key,
+			env:    l.EnvPrefix + strings.ToUpper(strings.ReplaceAll(key, "-", "_")),
+			usage:  sf.Tag.Get("usage"),
+			secret: sf.Tag.Get("secret") == "true",
+			value:  v.Field(i),
+			source: SourceDefault,
+		})
+	}
+	return fields, nil
+}
+
+var errUnsupported = errors.New("unsupported field type")
+
+// setValue 把字符串解析为 p 指向的类型；s 为空字符串时只检查类型是否支持
+func setValue(p any, s string) error {
+	probe := s == ""
+	var err error
+	switch p := p.(type) {
+	case *string:
+		if !probe {
+			*p = s
+		}
+	case *bool:
+		if !probe {
+			*p, err = strconv.ParseBool(s)
+		}
+	case *int:
+		if !probe {
+			*p, err = strconv.Atoi(s)
+		}
+	case *int64:
+		if !probe {
+			*p, err = strconv.ParseInt(s, 10, 64)
+		}
+	case *float64:
+		if !probe {
+			*p, err = strconv.ParseFloat(s, 64)
+		}
+	case *time.Duration:
+		if !probe {
+			*p, err = time.ParseDuration(s)
+		}
+	default:
+		return errUnsupported
+	}
+	if err != nil {
+		// 去掉 strconv 冗长的前缀，只保留原因
+		var numErr *strconv.NumError
+		if errors.As(err, &numErr) {
+			err = fmt.Errorf("invalid value %q: %w", s, numErr.Err)
+		}
+	}
+	return err
+}
+
+func snapshot(fields []*field) []any {
+	out := make([]any, len(fields))
+	for i, f := range fields {
+		out[i] = f.value.Interface()
+	}
+	return out
+}
+
+func restore(fields []*field, values []any) {
+	for i, f := range fields {
+		f.value.Set(reflect.ValueOf(values[i]))
+	}
+}
+
+func describe(fields []*field) []Field {
+	out := make([]Field, 0, len(fields))
+	for _, f := range fields {
+		var value any = f.value.Interface()
+		if d, ok := value.(time.Duration); ok {
+			value = d.String()
+		}
+		if f.secret && !f.value.IsZero() {
+			value = "******"
+		}
+		out = append(out, Field{Key: f.key, Value: value, Source: f.source, Env: f.env, Usage: f.usage})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Key < out[j].Key })
+	return out
+}
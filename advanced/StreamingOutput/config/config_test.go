@@ -0,0 +1,109 @@
+package config
+
+import (
+	"flag"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testConfig struct {
+	Addr    string        `config:"addr"`
+	Count   int           `config:"count"`
+	Delay   time.Duration `config:"delay"`
+	Enabled bool          `config:"enabled"`
+	Key     string        `config:"key" secret:"true"`
+	Ignored string
+}
+
+func load(t *testing.T, args []string, env map[string]string, files map[string]string) (*testConfig, []Field, error) {
+	t.Helper()
+	cfg := &testConfig{Addr: ":8080", Count: 10, Delay: time.Second}
+	l := Loader{
+		EnvPrefix: "T_",
+		LookupEnv: func(k string) (string, bool) { v, ok := env[k]; return v, ok },
+		ReadFile: func(p string) ([]byte, error) {
+			data, ok := files[p]
+			if !ok {
+				return nil, io.ErrUnexpectedEOF
+			}
+			return []byte(data), nil
+		},
+	}
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fields, err := l.Load(cfg, fs, args)
+	return cfg, fields, err
+}
+
+func TestPrecedence(t *testing.T) {
+	files := map[string]string{
+		"c.yaml": "# 注释\naddr: \":7000\"\ncount: 20 # 行尾注释\ndelay: 5ms\nkey: secret\n",
+	}
+	env := map[string]string{"T_CONFIG": "c.yaml", "T_COUNT": "30", "T_DELAY": "7ms"}
+
+	cfg, fields, err := load(t, []string{"-delay", "9ms"}, env, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := testConfig{Addr: ":7000", Count: 30, Delay: 9 * time.Millisecond, Key: "secret"}
+	if *cfg != want {
+		t.Errorf("cfg = %+v, want %+v", *cfg, want)
+	}
+
+	sources := map[string]string{}
+	for _, f := range fields {
+		sources[f.Key] = f.Source
+		if f.Key == "key" && f.Value != "******" {
+			t.Errorf("secret 应当被隐藏, got %v", f.Value)
+		}
+	}
+	wantSources := map[string]string{"addr": SourceFile, "count": SourceEnv, "delay": SourceFlag, "enabled": SourceDefault, "key": SourceFile}
+	for k, v := range wantSources {
+		if sources[k] != v {
+			t.Errorf("source[%s] = %q, want %q", k, sources[k], v)
+		}
+	}
+}
+
+func TestJSONFile(t *testing.T) {
+	files := map[string]string{"c.json": `{"count": 4194304, "enabled": true, "addr": null}`}
+	cfg, _, err := load(t, []string{"-config", "c.json"}, nil, files)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Count != 4194304 || !cfg.Enabled || cfg.Addr != ":8080" {
+		t.Errorf("cfg = %+v", *cfg)
+	}
+}
+
+// TestBoolFlag bool 参数可以不带值
+func TestBoolFlag(t *testing.T) {
+	cfg, _, err := load(t, []string{"-enabled"}, nil, nil)
+	if err != nil || !cfg.Enabled {
+		t.Fatalf("-enabled: cfg = %+v, %v", cfg, err)
+	}
+	cfg, _, err = load(t, []string{"-enabled=false"}, map[string]string{"T_ENABLED": "true"}, nil)
+	if err != nil || cfg.Enabled {
+		t.Fatalf("-enabled=false: cfg = %+v, %v", cfg, err)
+	}
+}
+
+func TestValidationErrors(t *testing.T) {
+	files := map[string]string{"c.yaml": "count: many\nunknown: 1\n"}
+	env := map[string]string{"T_DELAY": "soon"}
+	_, _, err := load(t, []string{"-config", "c.yaml"}, env, files)
+	if err == nil {
+		t.Fatal("应当返回错误")
+	}
+	for _, want := range []string{"c.yaml:1: count", `c.yaml:2: unknown key "unknown"`, "env T_DELAY"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("错误信息缺少 %q:\n%v", want, err)
+		}
+	}
+
+	if _, _, err := load(t, []string{"-count", "x"}, nil, nil); err == nil {
+		t.Error("非法的命令行参数应当返回错误")
+	}
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// keyValue 是配置文件中的一项，line 用于报错
+type keyValue struct {
+	key   string
+	value string
+	line  int
+}
+
+// pos 返回用于报错的位置，JSON 文件没有行号
+func (kv keyValue) pos(path string) string {
+	if kv.line == 0 {
+		return path
+	}
+	return fmt.Sprintf("%s:%d", path, kv.line)
+}
+
+// readFile 读取配置文件：.json 为扁平的 JSON 对象，其他扩展名按 YAML-lite 解析
+func (l Loader) readFile(path string) ([]keyValue, error) {
+	if l.ReadFile == nil {
+		return nil, fmt.Errorf("config: no ReadFile to load %s", path)
+	}
+	data, err := l.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+	if strings.EqualFold(filepath.Ext(path), ".json") {
+		return parseJSON(path, data)
+	}
+	return parseYAMLLite(path, data)
+}
+
+// parseJSON 解析 {"addr": ":8080", "sse-count": 10, "compress": true}
+func parseJSON(path string, data []byte) ([]keyValue, error) {
+	var m map[string]any
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber() // 保留数字原文，避免大整数变成 4.194304e+06
+	if err := dec.Decode(&m); err != nil {
+		return nil, fmt.Errorf("config: %s: %w", path, err)
+	}
+	out := make([]keyValue, 0, len(m))
+	for k, v := range m {
+		switch v.(type) {
+		case nil:
+			continue // null 表示不设置，保留默认值
+		case map[string]any, []any:
+			return nil, fmt.Errorf("config: %s: %s: nested values are not supported", path, k)
+		}
+		out = append(out, keyValue{key: k, value: fmt.Sprint(v)})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].key < out[j].key })
+	return out, nil
+}
+
+// parseYAMLLite 解析简化的 YAML：每行一个 "key: value"，# 开头为注释，值可以加引号
+//
+//	# 延迟场景
+//	addr: ":9090"
+//	sse-delay: 200ms
+func parseYAMLLite(path string, data []byte) ([]keyValue, error) {
+	var out []keyValue
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("config: %s:%d: expected \"key: value\"", path, i+1)
+		}
+		value = strings.TrimSpace(value)
+		// 去掉行尾注释（引号内的 # 保留）
+		if !strings.HasPrefix(value, `"`) && !strings.HasPrefix(value, "'") {
+			if j := strings.Index(value, " #"); j >= 0 {
+				value = strings.TrimSpace(value[:j])
+			}
+		}
+		if n := len(value); n >= 2 && (value[0] == '"' || value[0] == '\'') && value[n-1] == value[0] {
+			value = value[1 : n-1]
+		}
+		out = append(out, keyValue{key: strings.TrimSpace(key), value: value, line: i + 1})
+	}
+	return out, nil
+}
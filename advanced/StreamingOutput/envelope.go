@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"go-learning/advanced/StreamingOutput/event"
+	"go-learning/advanced/StreamingOutput/format"
+	"go-learning/advanced/StreamingOutput/sse"
+)
+
+// ============ 事件信封 ============
+//
+// 流式接口输出 event 包的信封（delta / error / heartbeat / done / usage），
+// 每个流以 done 和 usage 结束，不再使用 [DONE] 标记：
+//
+//	SSE            event 为信封类型，data 为信封JSON；只有 delta 带 id（即序号），
+//	               客户端重连时的 Last-Event-ID 总是最后收到的内容，从下一条 delta 继续
+//	NDJSON / JSON  每行或每个数组元素是一个信封
+//	text           只输出 delta 的内容和结束说明
+//
+// 例外：/v1/chat/completions 保持 OpenAI 的 chunk 格式和 data: [DONE]；
+// /subscribe/ 转发发布者的消息原文，只有停止和关闭通知使用信封。
+
+// newEventStream 为请求创建信封序列，流ID取自流注册表，序号从 after 之后开始；
+// 处理器 panic 时 recovered 接着这个序列发送错误信封
+func newEventStream(ctx context.Context, after int) *event.Stream {
+	st := event.NewStream(event.Options{ID: streamID(ctx), After: uint64(after)})
+	if rc, ok := ctx.Value(recoveryKey{}).(*recovery); ok {
+		rc.stream = st
+	}
+	return st
+}
+
+// newEncoder 创建编码器，并登记为 panic 时的收尾函数：
+// recovered 写出错误之前先刷新编码器缓冲的数据并停止它的刷新定时器
+// 返回的编码器每写出一条记录统计一条消息（见 sentMessage）
+func newEncoder(w http.ResponseWriter, r *http.Request, f format.Format, opts format.Options) (format.Encoder, error) {
+	enc, err := format.NewEncoder(w, f, opts)
+	if err != nil {
+		return nil, err
+	}
+	onPanic(r.Context(), func() { enc.Finish(errIncomplete) })
+	return countedEncoder{Encoder: enc, ctx: r.Context()}, nil
+}
+
+// countedEncoder 在每条记录写出后调用 sentMessage
+type countedEncoder struct {
+	format.Encoder
+	ctx context.Context
+}
+
+func (ce countedEncoder) Encode(rec format.Record) error {
+	if err := ce.Encoder.Encode(rec); err != nil {
+		return err
+	}
+	sentMessage(ce.ctx)
+	return nil
+}
+
+// record 把信封转换为记录，text 格式的输出见 envelopeText
+func record(env event.Envelope) format.Record {
+	rec := format.Record{Event: string(env.Type), Data: env, Text: envelopeText(env)}
+	if env.Type == event.Delta {
+		rec.ID = strconv.FormatUint(env.Seq, 10)
+	}
+	return rec
+}
+
+// doneText 是各结束原因在 text 格式下的说明
+var doneText = map[string]string{
+	event.ReasonComplete:  "生成完成",
+	event.ReasonStopped:   "已停止",
+	event.ReasonCancelled: "已取消",
+	event.ReasonError:     "生成失败",
+	event.ReasonShutdown:  "服务器关闭，请稍后重试",
+}
+
+// envelopeText 返回信封在 text 格式下的文本，为空表示不输出
+func envelopeText(env event.Envelope) string {
+	switch env.Type {
+	case event.Delta:
+		return env.Delta.Content
+	case event.Error:
+		return fmt.Sprintf("\n\n=== 错误: %s ===\n", env.Error.Message)
+	case event.Done:
+		if env.Done.Detail != "" {
+			return fmt.Sprintf("\n\n=== %s: %s ===\n", doneText[env.Done.Reason], env.Done.Detail)
+		}
+		return fmt.Sprintf("\n\n=== %s ===\n", doneText[env.Done.Reason])
+	case event.Usage:
+		u := env.Usage
+		return fmt.Sprintf("共 %d 个token，用时 %.0fms，%.1f token/s\n", u.Tokens, u.DurationMS, u.TokensPerSec)
+	}
+	return ""
+}
+
+// endRecords 返回流的最后两条记录：done 和 usage
+func endRecords(st *event.Stream, reason, detail string) []format.Record {
+	var recs []format.Record
+	for _, env := range st.End(reason, detail) {
+		recs = append(recs, record(env))
+	}
+	return recs
+}
+
+// encode 依次写出记录，text 格式下跳过没有文本的记录（如 heartbeat）
+func encode(enc format.Encoder, recs ...format.Record) error {
+	for _, rec := range recs {
+		if enc.Format() == format.Text && rec.Text == "" {
+			continue
+		}
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendRecords 向SSE客户端依次发送记录，每条统计一条消息
+func sendRecords(ctx context.Context, sw *sse.Writer, recs ...format.Record) error {
+	for _, rec := range recs {
+		if err := sw.Send(format.SSEEvent(rec)); err != nil {
+			return err
+		}
+		sentMessage(ctx)
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-learning/advanced/StreamingOutput/event"
+	"go-learning/advanced/StreamingOutput/flush"
+	"go-learning/advanced/StreamingOutput/format"
+)
+
+// TestSSEHandlerEnvelopes 续传时序号从 Last-Event-ID 之后继续，流以 done 和 usage 结束
+func TestSSEHandlerEnvelopes(t *testing.T) {
+	req := httptest.NewRequest("GET", "/stream/sse?format=ndjson", nil)
+	req.Header.Set("Last-Event-ID", "1")
+	rec := httptest.NewRecorder()
+	sseStream{count: 3}.serve(rec, req)
+
+	var got []event.Envelope
+	sc := bufio.NewScanner(rec.Body)
+	for sc.Scan() {
+		var env event.Envelope
+		if err := json.Unmarshal(sc.Bytes(), &env); err != nil {
+			t.Fatalf("line %q: %v", sc.Text(), err)
+		}
+		got = append(got, env)
+	}
+
+	want := []struct {
+		typ event.Type
+		seq uint64
+	}{{event.Heartbeat, 1}, {event.Delta, 2}, {event.Delta, 3}, {event.Done, 4}, {event.Usage, 5}}
+	if len(got) != len(want) {
+		t.Fatalf("got %d envelopes: %+v", len(got), got)
+	}
+	for i, w := range want {
+		if got[i].V != event.Version || got[i].Type != w.typ || got[i].Seq != w.seq || got[i].Time.IsZero() {
+			t.Fatalf("envelope %d = %+v, want %s seq %d", i, got[i], w.typ, w.seq)
+		}
+	}
+	if got[1].Delta.Content != "这是第 2 条SSE消息" || got[3].Done.Reason != event.ReasonComplete || got[4].Usage.Tokens != 2 {
+		t.Fatalf("envelopes = %+v %+v %+v", got[1].Delta, got[3].Done, got[4].Usage)
+	}
+	if got[4].Time.Before(got[1].Time) || time.Since(got[4].Time) > time.Minute {
+		t.Fatalf("timestamps out of order: %v .. %v", got[1].Time, got[4].Time)
+	}
+}
+
+// TestMessagesSentPerEnvelope 按字节合并刷新时，消息数仍按写出的信封统计，而不是 Flush 次数
+func TestMessagesSentPerEnvelope(t *testing.T) {
+	const route = "/test/messages"
+	h := instrumented(route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		enc, err := newEncoder(w, r, format.NDJSON, format.Options{Flush: flush.Policy{Mode: flush.Bytes, Bytes: 1 << 20}})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		st := newEventStream(r.Context(), 0)
+		encode(enc, record(st.Delta("a")), record(st.Delta("b")))
+		encode(enc, endRecords(st, event.ReasonComplete, "")...)
+		enc.Finish(nil)
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", route, nil))
+
+	if got := messagesSent.With(route).Value(); got != 4 {
+		t.Fatalf("messages sent = %v, want 4 (2 deltas, done, usage)", got)
+	}
+	if got := flushes.With(route).Value(); got >= 4 {
+		t.Fatalf("flushes = %v, want fewer than messages", got)
+	}
+}
@@ -0,0 +1,186 @@
+// Package event 定义各个流式接口共用的事件信封
+//
+// 一个流由一串信封组成，每个信封带协议版本、序号和 RFC 3339（纳秒精度）时间戳：
+//
+//	{"v":1,"seq":3,"type":"delta","time":"2026-10-18T08:00:00.123456789+08:00","stream":"9fa1e921f941","delta":{"content":"你好"}}
+//
+// 信封类型：
+//
+//	heartbeat  连接已建立或保活；不推进序号，seq 是最近一条信封的序号
+//	delta      一段增量内容，计入用量中的 token 数
+//	error      错误说明，之后仍以 done 和 usage 结束
+//	done       流结束，reason 为 complete / stopped / cancelled / error / shutdown
+//	usage      用量汇总：token 数、耗时和每秒 token 数，总是流的最后一条信封
+//
+// 除 heartbeat 外序号从 Options.After 之后逐条加一，
+// 客户端带着 Last-Event-ID 重连时，服务端以它作为 After 继续编号。
+package event
+
+import "time"
+
+// Version 是信封格式的版本，不兼容的修改时递增
+const Version = 1
+
+// Type 是信封的类型
+type Type string
+
+const (
+	Delta     Type = "delta"
+	Error     Type = "error"
+	Heartbeat Type = "heartbeat"
+	Done      Type = "done"
+	Usage     Type = "usage"
+)
+
+// 流结束的原因（DoneInfo.Reason）
+const (
+	ReasonComplete  = "complete"
+	ReasonStopped   = "stopped"   // 被 DELETE /streams/{id} 停止
+	ReasonCancelled = "cancelled" // 被客户端取消
+	ReasonError     = "error"
+	ReasonShutdown  = "shutdown" // 服务器关闭，客户端可以稍后重连续传
+)
+
+// Envelope 是流中的一条事件，按 Type 只有对应的一个字段非空
+// Time 编码为 RFC 3339 并保留纳秒（time.Time 的 JSON 编码）
+type Envelope struct {
+	V      int       `json:"v"`
+	Seq    uint64    `json:"seq"`
+	Type   Type      `json:"type"`
+	Time   time.Time `json:"time"`
+	Stream string    `json:"stream,omitempty"`
+
+	Delta *DeltaInfo `json:"delta,omitempty"`
+	Error *ErrorInfo `json:"error,omitempty"`
+	Done  *DoneInfo  `json:"done,omitempty"`
+	Usage *UsageInfo `json:"usage,omitempty"`
+}
+
+// DeltaInfo 是一段增量内容
+type DeltaInfo struct {
+	Content string `json:"content"`
+}
+
+// ErrorInfo 描述一个错误，RetryAfterMS 不为0时客户端可以在这之后重试
+type ErrorInfo struct {
+	Code         string `json:"code"`
+	Message      string `json:"message"`
+	RetryAfterMS int64  `json:"retry_after_ms,omitempty"`
+}
+
+// DoneInfo 说明流为何结束
+type DoneInfo struct {
+	Reason string `json:"reason"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// UsageInfo 是流的用量汇总
+type UsageInfo struct {
+	Tokens       int     `json:"tokens"`
+	DurationMS   float64 `json:"duration_ms"`
+	TokensPerSec float64 `json:"tokens_per_sec"`
+}
+
+// Options 配置 Stream
+type Options struct {
+	ID    string           // 流ID，写入每个信封
+	After uint64           // 第一条信封的序号为 After+1
+	Now   func() time.Time // 时钟，默认 time.Now
+}
+
+// Stream 为一个流依次生成信封，负责编号、打时间戳和统计用量
+// 不是并发安全的，通常只由处理器的写循环使用
+type Stream struct {
+	id     string
+	now    func() time.Time
+	seq    uint64
+	start  time.Time
+	tokens int
+}
+
+// NewStream 创建 Stream，用量从此刻开始计时
+func NewStream(opts Options) *Stream {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &Stream{id: opts.ID, now: opts.Now, seq: opts.After, start: opts.Now()}
+}
+
+// ID 返回流ID
+func (s *Stream) ID() string { return s.id }
+
+// Seq 返回最近一条信封的序号
+func (s *Stream) Seq() uint64 { return s.seq }
+
+// Tokens 返回已生成的 delta 数
+func (s *Stream) Tokens() int { return s.tokens }
+
+// Restart 开始同一连接上的下一个流：更换流ID并重新统计用量，序号继续递增
+func (s *Stream) Restart(id string) {
+	s.id = id
+	s.start = s.now()
+	s.tokens = 0
+}
+
+// Forward 记录一条由其他 Stream 生成、原样转发给客户端的信封（如广播）：
+// 序号跟上它，delta 计入用量，之后生成的信封接着它编号
+func (s *Stream) Forward(env Envelope) {
+	if env.Seq > s.seq {
+		s.seq = env.Seq
+	}
+	if env.Type == Delta {
+		s.tokens++
+	}
+}
+
+// next 生成下一条信封，heartbeat 不推进序号
+func (s *Stream) next(t Type) Envelope {
+	if t != Heartbeat {
+		s.seq++
+	}
+	return Envelope{V: Version, Seq: s.seq, Type: t, Time: s.now(), Stream: s.id}
+}
+
+// Delta 生成一条增量内容
+func (s *Stream) Delta(content string) Envelope {
+	s.tokens++
+	env := s.next(Delta)
+	env.Delta = &DeltaInfo{Content: content}
+	return env
+}
+
+// Heartbeat 生成一条心跳
+func (s *Stream) Heartbeat() Envelope {
+	return s.next(Heartbeat)
+}
+
+// Error 生成一条错误说明，retry 不为0时提示客户端多久后重试
+func (s *Stream) Error(code, message string, retry time.Duration) Envelope {
+	env := s.next(Error)
+	env.Error = &ErrorInfo{Code: code, Message: message, RetryAfterMS: retry.Milliseconds()}
+	return env
+}
+
+// Done 生成结束信封
+func (s *Stream) Done(reason, detail string) Envelope {
+	env := s.next(Done)
+	env.Done = &DoneInfo{Reason: reason, Detail: detail}
+	return env
+}
+
+// Usage 生成用量汇总
+func (s *Stream) Usage() Envelope {
+	env := s.next(Usage)
+	elapsed := env.Time.Sub(s.start)
+	u := &UsageInfo{Tokens: s.tokens, DurationMS: float64(elapsed.Microseconds()) / 1000}
+	if elapsed > 0 {
+		u.TokensPerSec = float64(s.tokens) / elapsed.Seconds()
+	}
+	env.Usage = u
+	return env
+}
+
+// End 生成流的最后两条信封：done 和 usage
+func (s *Stream) End(reason, detail string) []Envelope {
+	return []Envelope{s.Done(reason, detail), s.Usage()}
+}
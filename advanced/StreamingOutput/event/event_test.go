@@ -0,0 +1,77 @@
+package event
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock 每次调用前进 step
+func fakeClock(start time.Time, step time.Duration) func() time.Time {
+	t := start.Add(-step)
+	return func() time.Time {
+		t = t.Add(step)
+		return t
+	}
+}
+
+func TestStreamSequence(t *testing.T) {
+	start := time.Date(2026, 10, 18, 8, 0, 0, 0, time.UTC)
+	s := NewStream(Options{ID: "s1", After: 5, Now: fakeClock(start, 250*time.Millisecond)})
+
+	var envs []Envelope
+	envs = append(envs, s.Heartbeat(), s.Delta("你"), s.Delta("好"), s.Heartbeat())
+	envs = append(envs, s.End(ReasonComplete, "")...)
+
+	var types []string
+	var seqs []uint64
+	for _, e := range envs {
+		types = append(types, string(e.Type))
+		seqs = append(seqs, e.Seq)
+		if e.V != Version || e.Stream != "s1" {
+			t.Fatalf("envelope %+v", e)
+		}
+	}
+	if got := strings.Join(types, " "); got != "heartbeat delta delta heartbeat done usage" {
+		t.Fatalf("types = %s", got)
+	}
+	want := []uint64{5, 6, 7, 7, 8, 9}
+	for i := range want {
+		if seqs[i] != want[i] {
+			t.Fatalf("seqs = %v, want %v", seqs, want)
+		}
+	}
+
+	// 时钟第0次用于起点，usage 是第7次：1.5s，2个 token
+	u := envs[len(envs)-1].Usage
+	if u.Tokens != 2 || u.DurationMS != 1500 || u.TokensPerSec < 1.33 || u.TokensPerSec > 1.34 {
+		t.Fatalf("usage = %+v", u)
+	}
+}
+
+func TestEnvelopeJSON(t *testing.T) {
+	at := time.Date(2026, 10, 18, 8, 0, 0, 123456789, time.FixedZone("CST", 8*3600))
+	s := NewStream(Options{ID: "s1", Now: func() time.Time { return at }})
+	data, err := json.Marshal(s.Error("rate_limited", "slow down", 1500*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"v":1,"seq":1,"type":"error","time":"2026-10-18T08:00:00.123456789+08:00","stream":"s1",` +
+		`"error":{"code":"rate_limited","message":"slow down","retry_after_ms":1500}}`
+	if string(data) != want {
+		t.Fatalf("json = %s\nwant   %s", data, want)
+	}
+}
+
+// TestRestart 同一连接上的下一个流：序号继续，用量重新统计
+func TestRestart(t *testing.T) {
+	s := NewStream(Options{ID: "a"})
+	s.Delta("x")
+	s.End(ReasonComplete, "")
+	s.Restart("b")
+	env := s.Delta("y")
+	if env.Seq != 4 || env.Stream != "b" || s.Tokens() != 1 {
+		t.Fatalf("after restart: %+v tokens %d", env, s.Tokens())
+	}
+}
@@ -0,0 +1,529 @@
+// Package eventlog 是一个追加写的磁盘事件日志，让流式事件在进程重启后仍可回放
+//
+// 日志由多个段文件组成，每个段带有索引（见 segment.go）。
+// 活动段写满 SegmentSize 后滚动到新段；旧段按总大小 / 存在时间删除（Retain），
+// 并可按主题只保留最近若干条记录（Compact）。
+package eventlog
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// SyncPolicy 决定何时调用 fsync
+type SyncPolicy int
+
+const (
+	SyncInterval SyncPolicy = iota // 每隔 Options.SyncEvery 刷盘一次（默认），崩溃时最多丢失这段时间的事件
+	SyncAlways                     // 每次追加都刷盘，最安全也最慢
+	SyncNever                      // 交给操作系统决定
+)
+
+// ParseSyncPolicy 把 interval / always / never 解析为 SyncPolicy
+func ParseSyncPolicy(s string) (SyncPolicy, error) {
+	switch s {
+	case "", "interval":
+		return SyncInterval, nil
+	case "always":
+		return SyncAlways, nil
+	case "never":
+		return SyncNever, nil
+	}
+	return 0, fmt.Errorf("eventlog: unknown sync policy %q", s)
+}
+
+// Options 配置日志
+type Options struct {
+	Dir         string        // 段文件所在目录，不存在时创建
+	SegmentSize int64         // 单个段的最大字节数，<=0 时为 4MB
+	Sync        SyncPolicy    // 刷盘策略
+	SyncEvery   time.Duration // SyncInterval 策略的刷盘间隔，<=0 时为 1s
+	MaxBytes    int64         // 所有段的总大小上限，0 表示不限制
+	MaxAge      time.Duration // 段中最后一条记录的最长保留时间，0 表示不限制
+}
+
+// ErrClosed 表示日志已关闭
+var ErrClosed = errors.New("eventlog: closed")
+
+// Log 是追加写的事件日志，可并发使用
+type Log struct {
+	opts Options
+
+	// maint 串行化 Retain 和 Compact：只读段只会被它们修改，
+	// 因此持有 maint 时可以不加 mu 读取只读段
+	maint sync.Mutex
+
+	mu       sync.RWMutex
+	segments []*segment     // 按 base 排序，最后一个是活动段
+	counts   map[string]int // 主题 -> 整个日志中的记录数，随追加、保留和压缩增量维护
+	lastSeq  uint64
+	closed   bool
+
+	// compacted 是被 Compact 删除的最大序号，持久化在 compactedFile 中
+	// 压缩在日志中间留下缺口，从它之前续传会悄悄漏掉记录，FirstSeq 因此不小于 compacted+1
+	compacted uint64
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Open 打开（或创建）日志目录，恢复索引并截断崩溃时写到一半的记录
+func Open(opts Options) (*Log, error) {
+	if opts.SegmentSize <= 0 {
+		opts.SegmentSize = 4 << 20
+	}
+	if opts.SyncEvery <= 0 {
+		opts.SyncEvery = time.Second
+	}
+	if err := os.MkdirAll(opts.Dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	l := &Log{opts: opts, counts: make(map[string]int), stop: make(chan struct{})}
+	compacted, err := readCompacted(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	l.compacted = compacted
+	bases, err := listSegments(opts.Dir)
+	if err != nil {
+		return nil, err
+	}
+	for i, base := range bases {
+		s, err := loadSegment(opts.Dir, base, i == len(bases)-1)
+		if err != nil {
+			return nil, err
+		}
+		l.segments = append(l.segments, s)
+		for topic, n := range s.counts {
+			l.counts[topic] += n
+		}
+		if !s.empty() {
+			l.lastSeq = s.lastSeq()
+		}
+	}
+
+	if len(l.segments) == 0 {
+		l.segments = append(l.segments, &segment{base: 1, dir: opts.Dir})
+	}
+	if err := l.active().openForAppend(); err != nil {
+		return nil, err
+	}
+
+	if opts.Sync == SyncInterval {
+		l.wg.Add(1)
+		go l.syncLoop()
+	}
+	return l, nil
+}
+
+func (l *Log) active() *segment { return l.segments[len(l.segments)-1] }
+
+// LastSeq 返回最后一条记录的序号，空日志返回0
+func (l *Log) LastSeq() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.lastSeq
+}
+
+// FirstSeq 返回可以完整回放的第一个序号，空日志且从未压缩时返回0
+// 序号小于 FirstSeq 的记录可能已被保留策略或压缩删除，从它们之前续传会漏掉记录
+func (l *Log) FirstSeq() uint64 {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	var first uint64
+	for _, s := range l.segments {
+		if !s.empty() {
+			first = s.firstSeq()
+			break
+		}
+	}
+	if l.compacted > 0 && first <= l.compacted {
+		first = l.compacted + 1
+	}
+	return first
+}
+
+// Append 追加一条记录并分配序号
+func (l *Log) Append(topic string, data []byte) (Record, error) {
+	if len(topic) > 0xFFFF {
+		return Record{}, fmt.Errorf("eventlog: topic too long")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return Record{}, ErrClosed
+	}
+
+	rec := Record{Seq: l.lastSeq + 1, Time: time.Now(), Topic: topic, Data: data}
+	if rec.encodedSize() > maxRecordSize {
+		return Record{}, fmt.Errorf("eventlog: record too large")
+	}
+
+	// 活动段写满后滚动到新段
+	if act := l.active(); !act.empty() && act.size+rec.encodedSize() > l.opts.SegmentSize {
+		if err := l.roll(rec.Seq); err != nil {
+			return Record{}, err
+		}
+	}
+
+	if err := l.active().append(&rec); err != nil {
+		return Record{}, err
+	}
+	l.lastSeq = rec.Seq
+	l.counts[topic]++
+
+	if l.opts.Sync == SyncAlways {
+		if err := l.active().sync(); err != nil {
+			return Record{}, err
+		}
+	}
+	return rec, nil
+}
+
+// roll 关闭当前活动段并创建以 base 开头的新段，调用者需持有写锁
+func (l *Log) roll(base uint64) error {
+	old := l.active()
+	if err := old.close(); err != nil {
+		return err
+	}
+	os.Chtimes(old.logPath(), old.lastTime, old.lastTime)
+
+	s := &segment{base: base, dir: l.opts.Dir}
+	if err := s.openForAppend(); err != nil {
+		return err
+	}
+	l.segments = append(l.segments, s)
+	return nil
+}
+
+// Read 依次回调所有序号大于 since 的记录，fn 返回错误时停止
+// 回放期间追加操作会被阻塞，fn 不应做耗时操作
+func (l *Log) Read(since uint64, fn func(Record) error) error {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	if l.closed {
+		return ErrClosed
+	}
+
+	for _, s := range l.segments {
+		if s.empty() || s.lastSeq() <= since {
+			continue
+		}
+		if err := s.scan(since, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Sync 立即刷盘
+func (l *Log) Sync() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return ErrClosed
+	}
+	return l.active().sync()
+}
+
+func (l *Log) syncLoop() {
+	defer l.wg.Done()
+	ticker := time.NewTicker(l.opts.SyncEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.stop:
+			return
+		case <-ticker.C:
+			l.Sync()
+		}
+	}
+}
+
+// Retain 按 MaxBytes / MaxAge 删除最旧的只读段，返回删除的段数
+// 活动段永远不会被删除
+func (l *Log) Retain(now time.Time) (int, error) {
+	l.maint.Lock()
+	defer l.maint.Unlock()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, ErrClosed
+	}
+
+	var total int64
+	for _, s := range l.segments {
+		total += s.size
+	}
+
+	removed := 0
+	for len(l.segments) > 1 {
+		s := l.segments[0]
+		tooBig := l.opts.MaxBytes > 0 && total > l.opts.MaxBytes
+		tooOld := l.opts.MaxAge > 0 && now.Sub(s.lastTime) > l.opts.MaxAge
+		if !tooBig && !tooOld && !s.empty() {
+			break
+		}
+		if err := s.remove(); err != nil {
+			return removed, err
+		}
+		total -= s.size
+		l.uncount(s.counts)
+		l.segments = l.segments[1:]
+		removed++
+	}
+	return removed, nil
+}
+
+// uncount 从主题计数中减去 counts，调用者需持有写锁
+func (l *Log) uncount(counts map[string]int) {
+	for topic, n := range counts {
+		if l.counts[topic] -= n; l.counts[topic] <= 0 {
+			delete(l.counts, topic)
+		}
+	}
+}
+
+// Compact 重写只读段，每个主题只保留（整个日志中）最近的 keep 条记录
+// 返回删除的记录数。活动段不参与压缩，保证追加不受影响。
+//
+// 没有主题超过 keep 条时直接返回，不读取任何段。
+// 需要压缩时，在不持有日志锁的情况下读取只读段并写出临时文件，
+// 最后只在替换文件和索引时短暂持有写锁，期间追加和回放照常进行。
+func (l *Log) Compact(keep int) (int, error) {
+	if keep <= 0 {
+		return 0, nil
+	}
+	l.maint.Lock()
+	defer l.maint.Unlock()
+
+	// 1. 按主题计数算出每个主题要删除的最旧记录数，并取只读段的快照
+	l.mu.RLock()
+	if l.closed {
+		l.mu.RUnlock()
+		return 0, ErrClosed
+	}
+	excess := make(map[string]int)
+	for topic, n := range l.counts {
+		if n > keep {
+			excess[topic] = n - keep
+		}
+	}
+	sealed := append([]*segment(nil), l.segments[:len(l.segments)-1]...)
+	l.mu.RUnlock()
+	if len(excess) == 0 {
+		return 0, nil
+	}
+
+	// 2. 不持锁：从旧到新重写含有待删除记录的只读段
+	var rewrites []*rewrite
+	defer func() {
+		for _, rw := range rewrites {
+			rw.discard()
+		}
+	}()
+	for _, s := range sealed {
+		if len(excess) == 0 {
+			break
+		}
+		rw, err := s.compact(excess)
+		if err != nil {
+			return 0, err
+		}
+		if rw != nil {
+			rewrites = append(rewrites, rw)
+		}
+	}
+	if len(rewrites) == 0 {
+		return 0, nil
+	}
+
+	// 3. 持写锁替换文件和索引，删除压缩后为空的段
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return 0, ErrClosed
+	}
+	// 先持久化新的 compacted，替换文件中途失败时也不会让缺口之前的续传通过
+	compacted := l.compacted
+	for _, rw := range rewrites {
+		compacted = max(compacted, rw.maxDropped)
+	}
+	if err := writeCompacted(l.opts.Dir, compacted); err != nil {
+		return 0, err
+	}
+	l.compacted = compacted
+
+	dropped := 0
+	for _, rw := range rewrites {
+		if err := rw.commit(); err != nil {
+			return dropped, err
+		}
+		l.uncount(rw.dropped)
+		for _, n := range rw.dropped {
+			dropped += n
+		}
+	}
+	rewrites = nil
+
+	segments := l.segments[:0]
+	for i, s := range l.segments {
+		if s.empty() && i < len(l.segments)-1 {
+			if err := s.remove(); err != nil {
+				return dropped, err
+			}
+			continue
+		}
+		segments = append(segments, s)
+	}
+	l.segments = segments
+	return dropped, nil
+}
+
+// rewrite 是一个只读段压缩后的内容，已写入临时文件，commit 后生效
+type rewrite struct {
+	s       *segment
+	tmpLog  string
+	tmpIdx  string
+	index   []indexEntry
+	size    int64
+	last    time.Time
+	counts  map[string]int
+	dropped map[string]int // 主题 -> 删除的记录数
+
+	maxDropped uint64 // 删除的最大序号
+}
+
+// compact 删除段中每个主题最旧的 excess[topic] 条记录，并相应减少 excess
+// 把保留的记录写入临时文件，段中没有要删除的记录时返回 nil；调用者需持有 maint
+func (s *segment) compact(excess map[string]int) (*rewrite, error) {
+	drop := false
+	for topic := range s.counts {
+		if excess[topic] > 0 {
+			drop = true
+			break
+		}
+	}
+	if !drop {
+		return nil, nil
+	}
+
+	rw := &rewrite{
+		s:       s,
+		tmpLog:  s.logPath() + ".compact",
+		tmpIdx:  s.idxPath() + ".compact",
+		counts:  make(map[string]int),
+		dropped: make(map[string]int),
+	}
+	f, err := os.Create(rw.tmpLog)
+	if err != nil {
+		return nil, err
+	}
+	bw := bufio.NewWriter(f)
+	err = s.scan(0, func(r Record) error {
+		if excess[r.Topic] > 0 {
+			if excess[r.Topic]--; excess[r.Topic] == 0 {
+				delete(excess, r.Topic)
+			}
+			rw.dropped[r.Topic]++
+			rw.maxDropped = max(rw.maxDropped, r.Seq)
+			return nil
+		}
+		rw.index = append(rw.index, indexEntry{seq: r.Seq, offset: uint32(rw.size)})
+		rw.counts[r.Topic]++
+		rw.last = r.Time
+		rw.size += r.encodedSize()
+		_, err := bw.Write(r.encode())
+		return err
+	})
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = writeIndex(rw.tmpIdx, rw.index)
+	}
+	if err != nil {
+		rw.discard()
+		return nil, err
+	}
+	return rw, nil
+}
+
+// commit 用临时文件替换段文件并更新内存中的索引，调用者需持有写锁
+func (rw *rewrite) commit() error {
+	s := rw.s
+	if err := os.Rename(rw.tmpLog, s.logPath()); err != nil {
+		return err
+	}
+	if err := os.Rename(rw.tmpIdx, s.idxPath()); err != nil {
+		// .idx 与新的 .log 不一致，下次打开时 loadSegment 会重建
+		return err
+	}
+	s.index, s.size, s.counts = rw.index, rw.size, rw.counts
+	if !rw.last.IsZero() {
+		s.lastTime = rw.last
+		os.Chtimes(s.logPath(), rw.last, rw.last)
+	}
+	return nil
+}
+
+// compactedFile 保存 Log.compacted
+const compactedFile = "compacted"
+
+// readCompacted 读取 compactedFile，不存在时返回0
+func readCompacted(dir string) (uint64, error) {
+	data, err := os.ReadFile(filepath.Join(dir, compactedFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// writeCompacted 原子地写入 compactedFile
+func writeCompacted(dir string, seq uint64) error {
+	path := filepath.Join(dir, compactedFile)
+	if err := os.WriteFile(path+".tmp", []byte(strconv.FormatUint(seq, 10)+"\n"), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// discard 删除未提交的临时文件
+func (rw *rewrite) discard() {
+	os.Remove(rw.tmpLog)
+	os.Remove(rw.tmpIdx)
+}
+
+// Close 刷盘并关闭日志
+func (l *Log) Close() error {
+	l.mu.Lock()
+	if l.closed {
+		l.mu.Unlock()
+		return nil
+	}
+	l.closed = true
+	close(l.stop)
+	err := l.active().close()
+	l.mu.Unlock()
+
+	l.wg.Wait()
+	return err
+}
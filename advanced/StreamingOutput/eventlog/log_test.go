@@ -0,0 +1,163 @@
+package eventlog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func readAll(t *testing.T, l *Log, since uint64) []Record {
+	t.Helper()
+	var out []Record
+	if err := l.Read(since, func(r Record) error {
+		out = append(out, r)
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestAppendReadAcrossRestart(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(Options{Dir: dir, SegmentSize: 200, Sync: SyncAlways})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 20; i++ {
+		if _, err := l.Append("news", []byte(fmt.Sprintf("msg-%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(l.segments) < 2 {
+		t.Fatalf("应当滚动出多个段, got %d", len(l.segments))
+	}
+	l.Close()
+
+	// 模拟崩溃：在活动段末尾写入半条记录
+	bases, _ := listSegments(dir)
+	last := filepath.Join(dir, segmentName(bases[len(bases)-1])+".log")
+	f, _ := os.OpenFile(last, os.O_WRONLY|os.O_APPEND, 0)
+	f.Write([]byte{0, 0, 0, 40, 1, 2})
+	f.Close()
+
+	l, err = Open(Options{Dir: dir, SegmentSize: 200, Sync: SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if l.LastSeq() != 20 {
+		t.Fatalf("LastSeq() = %d, want 20", l.LastSeq())
+	}
+
+	got := readAll(t, l, 15)
+	if len(got) != 5 || got[0].Seq != 16 || string(got[4].Data) != "msg-20" {
+		t.Errorf("Read(15) = %v", got)
+	}
+
+	rec, _ := l.Append("news", []byte("after-restart"))
+	if rec.Seq != 21 {
+		t.Errorf("重启后序号应继续递增, got %d", rec.Seq)
+	}
+}
+
+func TestRetain(t *testing.T) {
+	l, err := Open(Options{Dir: t.TempDir(), SegmentSize: 100, MaxAge: time.Hour, Sync: SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for i := 0; i < 10; i++ {
+		l.Append("t", []byte("0123456789"))
+	}
+	segs := len(l.segments)
+
+	if n, _ := l.Retain(time.Now()); n != 0 {
+		t.Errorf("未过期时不应删除, removed %d", n)
+	}
+	n, _ := l.Retain(time.Now().Add(2 * time.Hour))
+	if n != segs-1 || len(l.segments) != 1 {
+		t.Errorf("过期后应只剩活动段, removed %d of %d", n, segs)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	l, err := Open(Options{Dir: t.TempDir(), SegmentSize: 120, Sync: SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	for i := 0; i < 12; i++ {
+		topic := "a"
+		if i%3 == 0 {
+			topic = "b"
+		}
+		l.Append(topic, []byte{byte(i)})
+	}
+
+	if _, err := l.Compact(2); err != nil {
+		t.Fatal(err)
+	}
+	count := map[string]int{}
+	for _, r := range readAll(t, l, 0) {
+		count[r.Topic]++
+	}
+	// 活动段不参与压缩，所以数量可能略多于2，但旧记录必须被清理
+	if count["a"] >= 8 || count["b"] >= 4 || count["a"] < 2 || count["b"] < 2 {
+		t.Errorf("compact 后 = %v", count)
+	}
+}
+
+// TestCompactCounts 主题计数随追加和压缩增量维护，重新打开后从段中恢复
+func TestCompactCounts(t *testing.T) {
+	dir := t.TempDir()
+	l, err := Open(Options{Dir: dir, SegmentSize: 120, Sync: SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Append("b", []byte{0}) // 保留下来的最旧记录，压缩在它之后留下缺口
+	for i := 0; i < 12; i++ {
+		l.Append("a", []byte{byte(i)})
+	}
+
+	// 没有主题超过 keep 时不做任何事
+	if n, err := l.Compact(20); n != 0 || err != nil {
+		t.Fatalf("Compact(20) = %d, %v", n, err)
+	}
+	n, err := l.Compact(3)
+	if err != nil || n == 0 {
+		t.Fatalf("Compact(3) = %d, %v", n, err)
+	}
+	if got := len(readAll(t, l, 0)); got != 13-n || l.counts["a"] != 12-n || l.counts["b"] != 1 {
+		t.Fatalf("after compact: %d records, counts %v, dropped %d", got, l.counts, n)
+	}
+	// FirstSeq 越过压缩留下的所有缺口，从缺口之前续传会被拒绝
+	kept := map[uint64]bool{}
+	for _, r := range readAll(t, l, 0) {
+		kept[r.Seq] = true
+	}
+	first := l.FirstSeq()
+	for seq := uint64(1); seq <= l.LastSeq(); seq++ {
+		if !kept[seq] && seq >= first {
+			t.Fatalf("FirstSeq = %d, but %d was compacted away", first, seq)
+		}
+	}
+	// 再次压缩只会处理剩下的超额记录
+	again, _ := l.Compact(3)
+	first = l.FirstSeq()
+	l.Close()
+
+	l, err = Open(Options{Dir: dir, SegmentSize: 120, Sync: SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	if l.counts["a"] != 12-n-again || l.counts["b"] != 1 {
+		t.Fatalf("counts after reopen = %v", l.counts)
+	}
+	if l.FirstSeq() != first {
+		t.Fatalf("FirstSeq after reopen = %d, want %d", l.FirstSeq(), first)
+	}
+}
@@ -0,0 +1,90 @@
+package eventlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"time"
+)
+
+// 记录在段文件中的格式（大端序）：
+//
+//	+--------+--------+-----------------------------------------------+
+//	| len u32| crc u32| seq u64 | time i64 | topicLen u16 | topic | data |
+//	+--------+--------+-----------------------------------------------+
+//
+// len 是 body 的长度，crc 是 body 的 CRC32，用于发现写到一半的记录（如进程崩溃）
+
+const (
+	headerSize    = 8
+	bodyFixedSize = 8 + 8 + 2
+	maxRecordSize = 16 << 20
+)
+
+// errCorrupt 表示记录损坏或不完整
+var errCorrupt = errors.New("eventlog: corrupt record")
+
+// Record 是日志中的一条事件
+type Record struct {
+	Seq   uint64    // 全局递增的序号，从1开始
+	Time  time.Time // 写入时间
+	Topic string    // 主题（或流名称）
+	Data  []byte    // 事件内容
+}
+
+func (r *Record) encodedSize() int64 {
+	return int64(headerSize + bodyFixedSize + len(r.Topic) + len(r.Data))
+}
+
+// encode 把记录编码为字节
+func (r *Record) encode() []byte {
+	bodyLen := bodyFixedSize + len(r.Topic) + len(r.Data)
+	buf := make([]byte, headerSize+bodyLen)
+	body := buf[headerSize:]
+	binary.BigEndian.PutUint64(body[0:], r.Seq)
+	binary.BigEndian.PutUint64(body[8:], uint64(r.Time.UnixNano()))
+	binary.BigEndian.PutUint16(body[16:], uint16(len(r.Topic)))
+	copy(body[bodyFixedSize:], r.Topic)
+	copy(body[bodyFixedSize+len(r.Topic):], r.Data)
+
+	binary.BigEndian.PutUint32(buf[0:], uint32(bodyLen))
+	binary.BigEndian.PutUint32(buf[4:], crc32.ChecksumIEEE(body))
+	return buf
+}
+
+// readRecord 从 br 读取一条记录，返回记录及其占用的字节数
+// 文件正好结束时返回 io.EOF；记录不完整或校验失败时返回 errCorrupt
+func readRecord(br *bufio.Reader) (Record, int64, error) {
+	var header [headerSize]byte
+	if _, err := io.ReadFull(br, header[:]); err != nil {
+		if err == io.EOF {
+			return Record{}, 0, io.EOF
+		}
+		return Record{}, 0, errCorrupt
+	}
+	bodyLen := binary.BigEndian.Uint32(header[0:])
+	if bodyLen < bodyFixedSize || bodyLen > maxRecordSize {
+		return Record{}, 0, errCorrupt
+	}
+	body := make([]byte, bodyLen)
+	if _, err := io.ReadFull(br, body); err != nil {
+		return Record{}, 0, errCorrupt
+	}
+	if crc32.ChecksumIEEE(body) != binary.BigEndian.Uint32(header[4:]) {
+		return Record{}, 0, errCorrupt
+	}
+
+	topicLen := int(binary.BigEndian.Uint16(body[16:]))
+	if bodyFixedSize+topicLen > len(body) {
+		return Record{}, 0, errCorrupt
+	}
+	rec := Record{
+		Seq:   binary.BigEndian.Uint64(body[0:]),
+		Time:  time.Unix(0, int64(binary.BigEndian.Uint64(body[8:]))),
+		Topic: string(body[bodyFixedSize : bodyFixedSize+topicLen]),
+		Data:  body[bodyFixedSize+topicLen:],
+	}
+	return rec, int64(headerSize + bodyLen), nil
+}
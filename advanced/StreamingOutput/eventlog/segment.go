@@ -0,0 +1,279 @@
+package eventlog
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// 每个段由两个文件组成：
+//   - {base}.log 记录本身，base 是创建时的第一个序号（20位补零，便于按文件名排序）
+//   - {base}.idx 索引，每条记录 12 字节：seq u64 + 在 .log 中的偏移 u32
+
+const indexEntrySize = 12
+
+type indexEntry struct {
+	seq    uint64
+	offset uint32
+}
+
+type segment struct {
+	base     uint64
+	dir      string
+	size     int64          // .log 文件大小
+	index    []indexEntry   // 内存中的完整索引
+	lastTime time.Time      // 最后一条记录的写入时间，用于按时间保留
+	counts   map[string]int // 主题 -> 段中的记录数，供 Compact 判断是否需要压缩
+
+	// 只有活动段（最后一个段）打开用于追加
+	log *os.File
+	idx *os.File
+}
+
+func segmentName(base uint64) string { return fmt.Sprintf("%020d", base) }
+
+func (s *segment) logPath() string { return filepath.Join(s.dir, segmentName(s.base)+".log") }
+func (s *segment) idxPath() string { return filepath.Join(s.dir, segmentName(s.base)+".idx") }
+
+func (s *segment) empty() bool { return len(s.index) == 0 }
+
+func (s *segment) firstSeq() uint64 { return s.index[0].seq }
+func (s *segment) lastSeq() uint64  { return s.index[len(s.index)-1].seq }
+
+// listSegments 按 base 顺序列出目录中的段
+func listSegments(dir string) ([]uint64, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "*.log"))
+	if err != nil {
+		return nil, err
+	}
+	var bases []uint64
+	for _, m := range matches {
+		var base uint64
+		if _, err := fmt.Sscanf(filepath.Base(m), "%020d.log", &base); err == nil {
+			bases = append(bases, base)
+		}
+	}
+	sort.Slice(bases, func(i, j int) bool { return bases[i] < bases[j] })
+	return bases, nil
+}
+
+// loadSegment 加载段的索引
+// 索引文件缺失或与 .log 不一致时重新扫描 .log 重建；
+// repair 为 true 时（活动段）截断末尾写到一半的记录
+func loadSegment(dir string, base uint64, repair bool) (*segment, error) {
+	s := &segment{base: base, dir: dir}
+	fi, err := os.Stat(s.logPath())
+	if err != nil {
+		return nil, err
+	}
+	s.size = fi.Size()
+
+	if !repair {
+		if idx, err := readIndex(s.idxPath()); err == nil && indexConsistent(idx, s.size) {
+			s.index = idx
+			s.lastTime = fi.ModTime()
+			if err := s.countTopics(); err != nil {
+				return nil, err
+			}
+			return s, nil
+		}
+	}
+	if err := s.rebuild(repair); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// rebuild 扫描 .log 重建索引并重写 .idx
+func (s *segment) rebuild(truncate bool) error {
+	f, err := os.Open(s.logPath())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	s.index = s.index[:0]
+	s.counts = make(map[string]int)
+	br := bufio.NewReader(f)
+	var offset int64
+	for {
+		rec, n, err := readRecord(br)
+		if err == io.EOF {
+			break
+		}
+		if errors.Is(err, errCorrupt) {
+			if !truncate {
+				return fmt.Errorf("eventlog: %s at offset %d: %w", s.logPath(), offset, err)
+			}
+			// 活动段末尾写到一半的记录（崩溃造成），截断丢弃
+			if err := os.Truncate(s.logPath(), offset); err != nil {
+				return err
+			}
+			break
+		}
+		s.index = append(s.index, indexEntry{seq: rec.Seq, offset: uint32(offset)})
+		s.counts[rec.Topic]++
+		s.lastTime = rec.Time
+		offset += n
+	}
+	s.size = offset
+	return writeIndex(s.idxPath(), s.index)
+}
+
+// countTopics 扫描 .log 统计每个主题的记录数
+func (s *segment) countTopics() error {
+	s.counts = make(map[string]int)
+	return s.scan(0, func(r Record) error {
+		s.counts[r.Topic]++
+		return nil
+	})
+}
+
+// openForAppend 打开活动段的文件句柄
+func (s *segment) openForAppend() error {
+	var err error
+	if s.log, err = os.OpenFile(s.logPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+		return err
+	}
+	if s.idx, err = os.OpenFile(s.idxPath(), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644); err != nil {
+		s.log.Close()
+		return err
+	}
+	return nil
+}
+
+// append 写入一条记录（调用者负责 fsync）
+func (s *segment) append(rec *Record) error {
+	offset := s.size
+	if _, err := s.log.Write(rec.encode()); err != nil {
+		return err
+	}
+	var entry [indexEntrySize]byte
+	binary.BigEndian.PutUint64(entry[0:], rec.Seq)
+	binary.BigEndian.PutUint32(entry[8:], uint32(offset))
+	if _, err := s.idx.Write(entry[:]); err != nil {
+		return err
+	}
+	s.size += rec.encodedSize()
+	s.index = append(s.index, indexEntry{seq: rec.Seq, offset: uint32(offset)})
+	if s.counts == nil {
+		s.counts = make(map[string]int)
+	}
+	s.counts[rec.Topic]++
+	s.lastTime = rec.Time
+	return nil
+}
+
+func (s *segment) sync() error {
+	if s.log == nil {
+		return nil
+	}
+	if err := s.log.Sync(); err != nil {
+		return err
+	}
+	return s.idx.Sync()
+}
+
+// close 关闭活动段的文件句柄，段变为只读
+func (s *segment) close() error {
+	if s.log == nil {
+		return nil
+	}
+	err := s.sync()
+	if cerr := s.log.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := s.idx.Close(); err == nil {
+		err = cerr
+	}
+	s.log, s.idx = nil, nil
+	return err
+}
+
+func (s *segment) remove() error {
+	s.close()
+	if err := os.Remove(s.logPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err := os.Remove(s.idxPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// scan 从第一条序号大于 since 的记录开始依次回调 fn
+func (s *segment) scan(since uint64, fn func(Record) error) error {
+	i := sort.Search(len(s.index), func(i int) bool { return s.index[i].seq > since })
+	if i == len(s.index) {
+		return nil
+	}
+
+	f, err := os.Open(s.logPath())
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err := f.Seek(int64(s.index[i].offset), io.SeekStart); err != nil {
+		return err
+	}
+
+	br := bufio.NewReader(io.LimitReader(f, s.size-int64(s.index[i].offset)))
+	for {
+		rec, _, err := readRecord(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("eventlog: %s: %w", s.logPath(), err)
+		}
+		if err := fn(rec); err != nil {
+			return err
+		}
+	}
+}
+
+func readIndex(path string) ([]indexEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(data)%indexEntrySize != 0 {
+		return nil, errCorrupt
+	}
+	idx := make([]indexEntry, 0, len(data)/indexEntrySize)
+	for i := 0; i < len(data); i += indexEntrySize {
+		idx = append(idx, indexEntry{
+			seq:    binary.BigEndian.Uint64(data[i:]),
+			offset: binary.BigEndian.Uint32(data[i+8:]),
+		})
+	}
+	return idx, nil
+}
+
+func writeIndex(path string, idx []indexEntry) error {
+	buf := make([]byte, 0, len(idx)*indexEntrySize)
+	for _, e := range idx {
+		buf = binary.BigEndian.AppendUint64(buf, e.seq)
+		buf = binary.BigEndian.AppendUint32(buf, e.offset)
+	}
+	return os.WriteFile(path, buf, 0o644)
+}
+
+// indexConsistent 粗略检查索引：偏移递增且都在文件范围内
+func indexConsistent(idx []indexEntry, size int64) bool {
+	if len(idx) == 0 {
+		return size == 0
+	}
+	for i := 1; i < len(idx); i++ {
+		if idx[i].offset <= idx[i-1].offset || idx[i].seq <= idx[i-1].seq {
+			return false
+		}
+	}
+	return int64(idx[len(idx)-1].offset) < size
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"time"
+
+	"go-learning/advanced/StreamingOutput/eventlog"
+	"go-learning/advanced/StreamingOutput/hub"
+	"go-learning/advanced/StreamingOutput/pubsub"
+)
+
+// ============ 持久化事件日志：重启后仍可按 Last-Event-ID 回放 ============
+
+// messageStore 用 eventlog 持久化 Message，实现 pubsub.Store
+type messageStore struct {
+	log *eventlog.Log
+}
+
+func (s messageStore) Append(topic string, msg Message) (uint64, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return 0, err
+	}
+	rec, err := s.log.Append(topic, data)
+	return rec.Seq, err
+}
+
+func (s messageStore) Replay(since uint64, fn func(pubsub.Item[Message]) error) error {
+	return s.log.Read(since, func(rec eventlog.Record) error {
+		var msg Message
+		if err := json.Unmarshal(rec.Data, &msg); err != nil {
+			return err
+		}
+		return fn(pubsub.Item[Message]{Seq: rec.Seq, Topic: rec.Topic, Value: msg})
+	})
+}
+
+func (s messageStore) FirstSeq() uint64 { return s.log.FirstSeq() }
+
+// setupEventLog 打开事件日志并创建持久化的 Broker，同时打开SSE流的流日志（见 streamlog.go），未配置目录时什么都不做
+// 返回的函数用于关闭日志
+func setupEventLog() (func() error, error) {
+	if cfg.EventLog == "" {
+		return func() error { return nil }, nil
+	}
+	elog, err := openEventLog(cfg.EventLog)
+	if err != nil {
+		return nil, err
+	}
+
+	policy, _ := hub.ParsePolicy(cfg.BroadcastPolicy)
+	broker, err := pubsub.NewDurable[Message](pubsub.Options{
+		Retain:    cfg.TopicRetain,
+		MaxReplay: cfg.EventLogReplay,
+		Hub:       hub.Options{Buffer: cfg.BroadcastBuffer, Policy: policy},
+	}, messageStore{log: elog})
+	if err != nil {
+		elog.Close()
+		return nil, err
+	}
+	if err := openStreamLog(); err != nil {
+		elog.Close()
+		return nil, err
+	}
+	brokerInst = broker
+	slog.Info("事件日志已打开", "component", "eventlog", "dir", cfg.EventLog, "last_seq", elog.LastSeq())
+
+	go maintainEventLog(elog)
+	return func() error { return errors.Join(streamLog.Close(), elog.Close()) }, nil
+}
+
+// openEventLog 按配置打开 dir 中的事件日志
+func openEventLog(dir string) (*eventlog.Log, error) {
+	syncPolicy, _ := eventlog.ParseSyncPolicy(cfg.EventLogSync) // 已在 Validate 中校验
+	return eventlog.Open(eventlog.Options{
+		Dir:         dir,
+		SegmentSize: cfg.EventLogSegment,
+		Sync:        syncPolicy,
+		MaxBytes:    cfg.EventLogMaxBytes,
+		MaxAge:      cfg.EventLogMaxAge,
+	})
+}
+
+// maintainEventLog 定期执行保留策略与压缩，日志关闭后退出
+// 日志按主题维护计数，没有主题超过保留条数时 Compact 不读取任何段
+func maintainEventLog(elog *eventlog.Log) {
+	logger := slog.With("component", "eventlog")
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for range ticker.C {
+		removed, err := elog.Retain(time.Now())
+		if err == eventlog.ErrClosed {
+			return
+		}
+		if err != nil {
+			logger.Warn("保留策略执行失败", "err", err)
+		}
+
+		// 压缩时每个主题保留的条数比内存中多，给断线较久的客户端留出回放空间
+		dropped, err := elog.Compact(cfg.TopicRetain * 10)
+		if err != nil {
+			logger.Warn("压缩失败", "err", err)
+		}
+		if removed > 0 || dropped > 0 {
+			logger.Info("清理事件日志", "segments_removed", removed, "records_compacted", dropped)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+// Package flush 为流式响应提供可配置的刷新合并策略
+//
+// 每个token都调用一次 Flush 意味着每个token一次 write 系统调用（HTTPS 下还是一个 TLS 记录），
+// token 很小、到达很快时，大部分开销都花在了系统调用和帧头上。Writer 把数据先写入
+// bufio.Writer，再按策略决定何时通过 http.ResponseController 刷新到客户端：
+//
+//	immediate       每次写入后立即刷新（原先的行为，延迟最低）
+//	bytes:N         缓冲达到 N 字节时刷新
+//	latency:D       第一个未刷新的字节最多等待 D 后刷新，期间的写入合并为一次
+//	adaptive[:D]    根据生产速度自动选择：写入间隔大于 D 时立即刷新，
+//	                生产得快时按 D 的窗口合并（默认 D=20ms）
+//
+// 除 immediate 外，缓冲超过 MaxBuffer 时总会立即刷新。流结束时调用 Close 刷新剩余数据。
+package flush
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Mode 是刷新策略的类型
+type Mode int
+
+const (
+	Immediate Mode = iota
+	Bytes
+	Latency
+	Adaptive
+)
+
+func (m Mode) String() string {
+	switch m {
+	case Immediate:
+		return "immediate"
+	case Bytes:
+		return "bytes"
+	case Latency:
+		return "latency"
+	case Adaptive:
+		return "adaptive"
+	}
+	return "unknown"
+}
+
+const (
+	// DefaultWindow 是 adaptive 未指定窗口时的合并窗口
+	DefaultWindow = 20 * time.Millisecond
+	// MaxBuffer 是 bytes 以外的策略最多缓冲的字节数
+	MaxBuffer = 4096
+)
+
+// ErrClosed 表示 Writer 已经关闭
+var ErrClosed = errors.New("flush: writer closed")
+
+// Policy 描述何时刷新
+type Policy struct {
+	Mode   Mode
+	Bytes  int           // Bytes 模式的阈值
+	Window time.Duration // Latency / Adaptive 模式的合并窗口
+}
+
+func (p Policy) String() string {
+	switch p.Mode {
+	case Bytes:
+		return "bytes:" + strconv.Itoa(p.Bytes)
+	case Latency, Adaptive:
+		return p.Mode.String() + ":" + p.Window.String()
+	}
+	return p.Mode.String()
+}
+
+// ParsePolicy 解析 immediate / bytes:N / latency:D / adaptive[:D]
+func ParsePolicy(s string) (Policy, error) {
+	name, arg, hasArg := strings.Cut(strings.TrimSpace(s), ":")
+	switch name {
+	case "", "immediate":
+		if hasArg {
+			break
+		}
+		return Policy{Mode: Immediate}, nil
+	case "bytes":
+		n, err := strconv.Atoi(arg)
+		if err != nil || n <= 0 {
+			return Policy{}, fmt.Errorf("flush: bytes policy needs a positive size, got %q", arg)
+		}
+		return Policy{Mode: Bytes, Bytes: n}, nil
+	case "latency", "adaptive":
+		p := Policy{Mode: Latency, Window: DefaultWindow}
+		if name == "adaptive" {
+			p.Mode = Adaptive
+		}
+		if !hasArg && p.Mode == Adaptive {
+			return p, nil
+		}
+		d, err := time.ParseDuration(arg)
+		if err != nil || d <= 0 {
+			return Policy{}, fmt.Errorf("flush: %s policy needs a positive duration, got %q", name, arg)
+		}
+		p.Window = d
+		return p, nil
+	}
+	return Policy{}, errors.New("flush: unknown policy " + s)
+}
+
+// Writer 按 Policy 合并刷新，可以被处理器和内部的定时器并发使用
+type Writer struct {
+	rc     *http.ResponseController
+	policy Policy
+
+	mu      sync.Mutex
+	bw      *bufio.Writer
+	pending int         // 上次刷新后写入的字节数
+	timer   *time.Timer // Latency / Adaptive 模式下等待中的刷新
+	armed   bool
+	last    time.Time     // 上一次写入的时间
+	gap     time.Duration // 写入间隔的指数移动平均，Adaptive 模式使用
+	flushes int
+	closed  bool
+	err     error
+}
+
+// NewWriter 创建写入 w 的 Writer，w 及其包装层需要支持刷新（通过 Unwrap 查找）
+func NewWriter(w http.ResponseWriter, p Policy) *Writer {
+	size := MaxBuffer
+	if p.Mode == Bytes && p.Bytes > size {
+		size = p.Bytes
+	}
+	return &Writer{
+		rc:     http.NewResponseController(w),
+		policy: p,
+		bw:     bufio.NewWriterSize(w, size),
+	}
+}
+
+// Policy 返回 Writer 使用的策略
+func (fw *Writer) Policy() Policy { return fw.policy }
+
+// Flushes 返回已经刷新到客户端的次数
+func (fw *Writer) Flushes() int {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	return fw.flushes
+}
+
+// Write 写入缓冲区，并按策略决定是否刷新；返回第一次写入或刷新失败的错误
+func (fw *Writer) Write(p []byte) (int, error) {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.closed {
+		return 0, ErrClosed
+	}
+	if fw.err != nil {
+		return 0, fw.err
+	}
+	n, err := fw.bw.Write(p)
+	fw.pending += n
+	if err != nil {
+		fw.err = err
+		return n, err
+	}
+
+	now := time.Now()
+	switch fw.policy.Mode {
+	case Immediate:
+		fw.flushLocked()
+	case Bytes:
+		if fw.pending >= fw.policy.Bytes {
+			fw.flushLocked()
+		}
+	case Latency:
+		fw.coalesceLocked()
+	case Adaptive:
+		// 第一次写入总是立即刷新，不拖慢首字节时间
+		if fw.last.IsZero() {
+			fw.gap = fw.policy.Window
+		} else {
+			fw.gap = (3*fw.gap + now.Sub(fw.last)) / 4
+		}
+		if fw.gap >= fw.policy.Window {
+			fw.flushLocked() // 生产得慢，等待不会合并出更多数据
+		} else {
+			fw.coalesceLocked()
+		}
+	}
+	fw.last = now
+	return n, fw.err
+}
+
+// coalesceLocked 缓冲超过上限时立即刷新，否则确保窗口结束时会刷新
+func (fw *Writer) coalesceLocked() {
+	if fw.pending >= MaxBuffer {
+		fw.flushLocked()
+		return
+	}
+	if fw.armed {
+		return
+	}
+	fw.armed = true
+	if fw.timer == nil {
+		fw.timer = time.AfterFunc(fw.policy.Window, fw.onTimer)
+	} else {
+		fw.timer.Reset(fw.policy.Window)
+	}
+}
+
+func (fw *Writer) onTimer() {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	fw.armed = false
+	if fw.closed || fw.pending == 0 {
+		return
+	}
+	fw.flushLocked()
+}
+
+// Flush 立即刷新缓冲的数据，用于流的开头、结尾等必须马上送达的内容
+func (fw *Writer) Flush() error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.closed {
+		return ErrClosed
+	}
+	fw.flushLocked()
+	return fw.err
+}
+
+func (fw *Writer) flushLocked() {
+	if fw.armed {
+		fw.timer.Stop()
+		fw.armed = false
+	}
+	if fw.err != nil {
+		return
+	}
+	if err := fw.bw.Flush(); err != nil {
+		fw.err = err
+		return
+	}
+	if err := fw.rc.Flush(); err != nil {
+		fw.err = err
+		return
+	}
+	fw.pending = 0
+	fw.flushes++
+}
+
+// Close 刷新剩余数据并停止定时器，之后不再访问 ResponseWriter，处理器返回前必须调用
+func (fw *Writer) Close() error {
+	fw.mu.Lock()
+	defer fw.mu.Unlock()
+	if fw.closed {
+		return fw.err
+	}
+	if fw.pending > 0 {
+		fw.flushLocked()
+	}
+	if fw.timer != nil {
+		fw.timer.Stop()
+	}
+	fw.closed = true
+	return fw.err
+}
@@ -0,0 +1,218 @@
+package flush
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParsePolicy(t *testing.T) {
+	tests := []struct {
+		in   string
+		want Policy
+	}{
+		{"", Policy{Mode: Immediate}},
+		{"immediate", Policy{Mode: Immediate}},
+		{"bytes:1024", Policy{Mode: Bytes, Bytes: 1024}},
+		{"latency:5ms", Policy{Mode: Latency, Window: 5 * time.Millisecond}},
+		{"adaptive", Policy{Mode: Adaptive, Window: DefaultWindow}},
+		{"adaptive:50ms", Policy{Mode: Adaptive, Window: 50 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		got, err := ParsePolicy(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParsePolicy(%q) = %+v, %v; want %+v", tt.in, got, err, tt.want)
+		}
+		if again, err := ParsePolicy(got.String()); err != nil || again != got {
+			t.Errorf("ParsePolicy(%q) does not round-trip: %+v, %v", got.String(), again, err)
+		}
+	}
+	for _, bad := range []string{"bytes", "bytes:0", "latency", "latency:-1s", "adaptive:x", "immediate:1", "fast"} {
+		if _, err := ParsePolicy(bad); err == nil {
+			t.Errorf("ParsePolicy(%q) should fail", bad)
+		}
+	}
+}
+
+// recorder 记录 Flush 调用次数及每次刷新时已写出的内容
+type recorder struct {
+	*httptest.ResponseRecorder
+	mu      sync.Mutex
+	flushed []string
+}
+
+func newRecorder() *recorder { return &recorder{ResponseRecorder: httptest.NewRecorder()} }
+
+func (r *recorder) Flush() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.flushed = append(r.flushed, r.Body.String())
+}
+
+func (r *recorder) flushes() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]string(nil), r.flushed...)
+}
+
+func TestWriterPolicies(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   []string // 每次刷新时客户端已收到的内容
+	}{
+		{"immediate", []string{"ab", "abcd", "abcdef", "abcdefgh"}},
+		{"bytes:4", []string{"abcd", "abcdefgh"}},
+		{"latency:1h", []string{"abcdefgh"}},                           // 只在 Close 时刷新
+		{"adaptive:1h", []string{"ab", "abcdefgh"}},                    // 首次写入立即刷新，之后都是快速写入
+		{"adaptive:1ns", []string{"ab", "abcd", "abcdef", "abcdefgh"}}, // 写入间隔都大于窗口
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			p, err := ParsePolicy(tt.policy)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec := newRecorder()
+			fw := NewWriter(rec, p)
+			for _, s := range []string{"ab", "cd", "ef", "gh"} {
+				if _, err := io.WriteString(fw, s); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := fw.Close(); err != nil {
+				t.Fatal(err)
+			}
+			if got := rec.flushes(); strings.Join(got, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("flushes = %q, want %q", got, tt.want)
+			}
+			if fw.Flushes() != len(tt.want) {
+				t.Errorf("Flushes() = %d, want %d", fw.Flushes(), len(tt.want))
+			}
+			if _, err := fw.Write([]byte("x")); err != ErrClosed {
+				t.Errorf("Write after Close = %v, want ErrClosed", err)
+			}
+		})
+	}
+}
+
+func TestLatencyWindowFlushes(t *testing.T) {
+	rec := newRecorder()
+	fw := NewWriter(rec, Policy{Mode: Latency, Window: 10 * time.Millisecond})
+	defer fw.Close()
+
+	io.WriteString(fw, "a")
+	io.WriteString(fw, "b")
+	deadline := time.Now().Add(time.Second)
+	for len(rec.flushes()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("latency window did not flush")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if got := rec.flushes(); len(got) != 1 || got[0] != "ab" {
+		t.Fatalf("flushes = %q, want [ab]", got)
+	}
+}
+
+func TestMaxBufferFlushes(t *testing.T) {
+	rec := newRecorder()
+	fw := NewWriter(rec, Policy{Mode: Latency, Window: time.Hour})
+	defer fw.Close()
+	fw.Write(make([]byte, MaxBuffer))
+	if n := len(rec.flushes()); n != 1 {
+		t.Fatalf("flushes = %d, want 1 once MaxBuffer is reached", n)
+	}
+}
+
+// ============ 基准测试：每种策略的 write 系统调用次数 ============
+//
+// 通过真实的TCP连接发送 benchTokens 个token，统计服务端连接上的 Write 调用次数
+// （每次都是一个 write 系统调用）。burst 表示token一次性到达，paced 表示每隔 200µs 到达一个：
+//
+//	go test -bench . -benchtime 20x ./flush
+
+const benchTokens = 256
+
+// countingListener 统计所有已接受连接上的 Write 调用
+type countingListener struct {
+	net.Listener
+	writes atomic.Int64
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &countingConn{Conn: c, l: l}, nil
+}
+
+type countingConn struct {
+	net.Conn
+	l *countingListener
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	c.l.writes.Add(1)
+	return c.Conn.Write(p)
+}
+
+func benchmarkPolicy(b *testing.B, policy string, interval time.Duration) {
+	p, err := ParsePolicy(policy)
+	if err != nil {
+		b.Fatal(err)
+	}
+	var flushes atomic.Int64
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fw := NewWriter(w, p)
+		for i := 0; i < benchTokens; i++ {
+			io.WriteString(fw, "token ")
+			if interval > 0 {
+				time.Sleep(interval)
+			}
+		}
+		fw.Close()
+		flushes.Add(int64(fw.Flushes()))
+	}))
+	ln := &countingListener{Listener: srv.Listener}
+	srv.Listener = ln
+	srv.Start()
+	defer srv.Close()
+
+	client := srv.Client()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		resp, err := client.Get(srv.URL)
+		if err != nil {
+			b.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+	}
+	b.StopTimer()
+	b.ReportMetric(float64(ln.writes.Load())/float64(b.N), "writes/op")
+	b.ReportMetric(float64(flushes.Load())/float64(b.N), "flushes/op")
+}
+
+func BenchmarkFlushPolicy(b *testing.B) {
+	policies := []string{"immediate", "bytes:512", "latency:5ms", "adaptive:5ms"}
+	for _, load := range []struct {
+		name     string
+		interval time.Duration
+	}{
+		{"burst", 0},
+		{"paced", 200 * time.Microsecond},
+	} {
+		for _, policy := range policies {
+			b.Run(load.name+"/"+policy, func(b *testing.B) {
+				benchmarkPolicy(b, policy, load.interval)
+			})
+		}
+	}
+}
@@ -0,0 +1,251 @@
+// Package format 让同一个流式路由按客户端的要求选择输出格式
+//
+// 处理器只产生与格式无关的 Record，由 Encoder 负责分帧：
+//
+//	sse     text/event-stream      每条记录一个事件，id / event / retry 对应同名字段
+//	ndjson  application/x-ndjson   每条记录一行JSON
+//	json    application/json       整个流是一个JSON数组，结束时通过 trailer 报告是否完整（见 jsonstream）
+//	text    text/plain             只输出文本，适合 curl 直接查看
+//
+// 格式由 ?format= 指定，否则按 Accept 头协商，都没有时使用路由的默认格式。
+//
+// ndjson / json 格式直接写出记录的 Data，ID 和 Event 只用于SSE的 id 和 event 字段，
+// 因此 Data 应当自带类型和序号（见 event 包的信封）。
+package format
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-learning/advanced/StreamingOutput/flush"
+	"go-learning/advanced/StreamingOutput/jsonstream"
+	"go-learning/advanced/StreamingOutput/sse"
+	"go-learning/advanced/StreamingOutput/utf8chunk"
+)
+
+// Format 是一种输出格式
+type Format int
+
+const (
+	Text Format = iota
+	SSE
+	NDJSON
+	JSON
+)
+
+// formats 按 ?format= 的名称和 MIME 类型描述每种格式
+var formats = []struct {
+	f           Format
+	name        string
+	mime        string
+	contentType string
+}{
+	{Text, "text", "text/plain", "text/plain; charset=utf-8"},
+	{SSE, "sse", sse.ContentType, sse.ContentType},
+	{NDJSON, "ndjson", "application/x-ndjson", "application/x-ndjson"},
+	{JSON, "json", "application/json", "application/json; charset=utf-8"},
+}
+
+func (f Format) String() string {
+	for _, d := range formats {
+		if d.f == f {
+			return d.name
+		}
+	}
+	return "unknown"
+}
+
+// ContentType 返回格式的 Content-Type 响应头
+func (f Format) ContentType() string {
+	for _, d := range formats {
+		if d.f == f {
+			return d.contentType
+		}
+	}
+	return "application/octet-stream"
+}
+
+// Parse 解析格式名称（sse / ndjson / json / text）或 MIME 类型
+func Parse(s string) (Format, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	for _, d := range formats {
+		if s == d.name || s == d.mime {
+			return d.f, nil
+		}
+	}
+	return 0, fmt.Errorf("format: unknown format %q (sse / ndjson / json / text)", s)
+}
+
+// Negotiate 选择响应格式：?format= 优先，其次是 Accept 中权重最高的受支持类型，否则为 def
+// 只有 ?format= 的值无法识别时返回错误；Accept 中没有受支持的类型时同样使用 def
+func Negotiate(r *http.Request, def Format) (Format, error) {
+	if s := r.URL.Query().Get("format"); s != "" {
+		return Parse(s)
+	}
+	best, bestQ := def, 0.0
+	for _, part := range strings.Split(r.Header.Get("Accept"), ",") {
+		mime, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		f, err := Parse(mime)
+		if err != nil {
+			continue // 包括 */*：交给默认格式
+		}
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(p), "q="); ok {
+				if parsed, err := strconv.ParseFloat(v, 64); err == nil {
+					q = parsed
+				}
+			}
+		}
+		if q > bestQ {
+			best, bestQ = f, q
+		}
+	}
+	return best, nil
+}
+
+// Record 是一条与格式无关的流式记录
+type Record struct {
+	ID    string        // SSE 的 id，客户端重连时通过 Last-Event-ID 回传
+	Event string        // SSE 的 event，如 delta / done；为空表示默认的 message 事件
+	Data  any           // 字符串原样输出，其他值编码为JSON
+	Text  string        // text 格式下代替 Data 输出的文本，为空时使用 Data
+	Retry time.Duration // SSE 的 retry 字段，其他格式忽略
+}
+
+// SSEEvent 把记录转换为SSE事件
+func SSEEvent(rec Record) sse.Event {
+	ev := sse.Event{ID: rec.ID, Event: rec.Event, Retry: rec.Retry}
+	switch v := rec.Data.(type) {
+	case nil:
+	case string:
+		ev.Data = v
+	default:
+		data, _ := json.Marshal(v)
+		ev.Data = string(data)
+	}
+	return ev
+}
+
+// Options 配置 Encoder
+type Options struct {
+	Flush     flush.Policy // 刷新策略，零值为每条记录立即刷新；json 格式每个元素都会刷新
+	Graphemes bool         // text 格式下保证每次写出的是完整的字素簇
+}
+
+// Encoder 按某种格式写出记录
+type Encoder interface {
+	Format() Format
+	// Encode 写出一条记录，返回错误通常意味着客户端已经断开
+	Encode(rec Record) error
+	// Finish 结束流并写出剩余数据，err 不为 nil 表示流被中止（json 格式的 trailer 为 aborted）
+	// 结束原因的说明由处理器先用一条记录写出；处理器返回前必须调用
+	Finish(err error) error
+}
+
+// NewEncoder 设置响应头并返回 f 格式的 Encoder，响应头在第一次写入时发出
+func NewEncoder(w http.ResponseWriter, f Format, opts Options) (Encoder, error) {
+	h := w.Header()
+	h.Add("Vary", "Accept")
+	if f == JSON {
+		enc, err := jsonstream.NewEncoder[any](w) // 设置 Content-Type 并声明 trailer
+		if err != nil {
+			return nil, err
+		}
+		return &jsonEncoder{enc: enc}, nil
+	}
+
+	h.Set("Content-Type", f.ContentType())
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+	fw := flush.NewWriter(w, opts.Flush)
+	switch f {
+	case SSE:
+		return &sseEncoder{fw: fw}, nil
+	case NDJSON:
+		return &ndjsonEncoder{fw: fw}, nil
+	case Text:
+		return &textEncoder{fw: fw, tw: utf8chunk.NewWriter(fw, utf8chunk.Options{Graphemes: opts.Graphemes})}, nil
+	}
+	return nil, fmt.Errorf("format: unsupported format %d", f)
+}
+
+type sseEncoder struct{ fw *flush.Writer }
+
+func (e *sseEncoder) Format() Format { return SSE }
+
+func (e *sseEncoder) Encode(rec Record) error {
+	return sse.Encode(e.fw, SSEEvent(rec))
+}
+
+func (e *sseEncoder) Finish(error) error { return e.fw.Close() }
+
+type ndjsonEncoder struct{ fw *flush.Writer }
+
+func (e *ndjsonEncoder) Format() Format { return NDJSON }
+
+func (e *ndjsonEncoder) Encode(rec Record) error {
+	data, err := json.Marshal(rec.Data)
+	if err != nil {
+		return err
+	}
+	_, err = e.fw.Write(append(data, '\n'))
+	return err
+}
+
+func (e *ndjsonEncoder) Finish(error) error { return e.fw.Close() }
+
+type textEncoder struct {
+	fw *flush.Writer
+	tw *utf8chunk.Writer
+}
+
+func (e *textEncoder) Format() Format { return Text }
+
+func (e *textEncoder) Encode(rec Record) error {
+	text := rec.Text
+	if text == "" {
+		switch v := rec.Data.(type) {
+		case nil:
+			return nil
+		case string:
+			text = v
+		default:
+			data, err := json.Marshal(v)
+			if err != nil {
+				return err
+			}
+			text = string(data) + "\n"
+		}
+	}
+	_, err := io.WriteString(e.tw, text)
+	return err
+}
+
+func (e *textEncoder) Finish(error) error {
+	if err := e.tw.Close(); err != nil {
+		e.fw.Close()
+		return err
+	}
+	return e.fw.Close()
+}
+
+type jsonEncoder struct{ enc *jsonstream.Encoder[any] }
+
+func (e *jsonEncoder) Format() Format { return JSON }
+
+func (e *jsonEncoder) Encode(rec Record) error {
+	return e.enc.Encode(rec.Data)
+}
+
+func (e *jsonEncoder) Finish(err error) error {
+	if err != nil {
+		return e.enc.Abort(err)
+	}
+	return e.enc.Close()
+}
@@ -0,0 +1,111 @@
+package format
+
+import (
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-learning/advanced/StreamingOutput/jsonstream"
+)
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		query, accept string
+		want          Format
+	}{
+		{"", "", Text},
+		{"", "*/*", Text},
+		{"", "text/event-stream", SSE},
+		{"", "application/x-ndjson", NDJSON},
+		{"", "text/html, application/json;q=0.9, */*;q=0.8", JSON},
+		{"", "text/plain;q=0.5, text/event-stream", SSE},
+		{"", "application/json;q=0, text/event-stream;q=0.1", SSE},
+		{"ndjson", "text/event-stream", NDJSON}, // ?format= 优先
+		{"application/json", "", JSON},
+		{"TEXT", "text/event-stream", Text},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest("GET", "/?format="+tt.query, nil)
+		r.Header.Set("Accept", tt.accept)
+		got, err := Negotiate(r, Text)
+		if err != nil || got != tt.want {
+			t.Errorf("Negotiate(format=%q, Accept=%q) = %v, %v; want %v", tt.query, tt.accept, got, err, tt.want)
+		}
+	}
+	if _, err := Negotiate(httptest.NewRequest("GET", "/?format=xml", nil), Text); err == nil {
+		t.Error("unknown ?format= should fail")
+	}
+	if got, _ := Negotiate(httptest.NewRequest("GET", "/", nil), JSON); got != JSON {
+		t.Errorf("default = %v, want json", got)
+	}
+}
+
+// 每种格式对同一组记录的输出
+var records = []Record{
+	{Event: "stream", Data: map[string]string{"id": "s1"}, Text: "== 开始 ==\n", Retry: time.Second},
+	{ID: "1", Event: "token", Data: "你好"},
+	{ID: "2", Event: "token", Data: "世界\n"},
+	{Data: map[string]int{"n": 1}},
+	{Event: "done", Data: map[string]int{"tokens": 2}, Text: "== 完成 ==\n"},
+}
+
+func TestEncoders(t *testing.T) {
+	tests := []struct {
+		f           Format
+		contentType string
+		want        string
+	}{
+		{SSE, "text/event-stream", "event: stream\nretry: 1000\ndata: {\"id\":\"s1\"}\n\n" +
+			"id: 1\nevent: token\ndata: 你好\n\n" +
+			"id: 2\nevent: token\ndata: 世界\ndata: \n\n" +
+			"data: {\"n\":1}\n\n" +
+			"event: done\ndata: {\"tokens\":2}\n\n"},
+		{NDJSON, "application/x-ndjson", `{"id":"s1"}` + "\n" + `"你好"` + "\n" + `"世界\n"` + "\n" +
+			`{"n":1}` + "\n" + `{"tokens":2}` + "\n"},
+		{Text, "text/plain; charset=utf-8", "== 开始 ==\n你好世界\n{\"n\":1}\n== 完成 ==\n"},
+		{JSON, "application/json; charset=utf-8", "[\n" +
+			`{"id":"s1"},` + "\n" + `"你好",` + "\n" + `"世界\n",` + "\n" +
+			`{"n":1},` + "\n" + `{"tokens":2}` + "\n]\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.f.String(), func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			enc, err := NewEncoder(rec, tt.f, Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.f == JSON {
+				enc.(*jsonEncoder).enc.SetIndent("", "") // 每个元素一行，便于比较
+			}
+			for _, r := range records {
+				if err := enc.Encode(r); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if err := enc.Finish(nil); err != nil {
+				t.Fatal(err)
+			}
+			if got := rec.Body.String(); got != tt.want {
+				t.Errorf("body:\n%s\nwant:\n%s", got, tt.want)
+			}
+			if got := rec.Header().Get("Content-Type"); got != tt.contentType {
+				t.Errorf("Content-Type = %q, want %q", got, tt.contentType)
+			}
+			if !rec.Flushed {
+				t.Error("response was never flushed")
+			}
+		})
+	}
+}
+
+func TestJSONFinishError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	enc, _ := NewEncoder(rec, JSON, Options{})
+	enc.Encode(Record{Data: 1})
+	enc.Finish(errors.New("stopped"))
+	trailer := rec.Result().Trailer
+	if trailer.Get(jsonstream.TrailerStatus) != jsonstream.StatusAborted || trailer.Get(jsonstream.TrailerError) != "stopped" {
+		t.Fatalf("trailer = %v", trailer)
+	}
+}
@@ -0,0 +1,44 @@
+package generator
+
+import (
+	"context"
+	"time"
+)
+
+// Echo 是脚本化的回显生成器：固定的一段回答，中间嵌入提示词
+type Echo struct {
+	Delay time.Duration // 默认每个token的延迟，Options.Delay 非零时优先
+}
+
+// Deterministic 实现 Deterministic 接口：token 列表只取决于提示词
+func (Echo) Deterministic() bool { return true }
+
+// Generate 实现 Generator 接口
+func (e Echo) Generate(ctx context.Context, prompt string, opts Options, out chan<- string) error {
+	// 模拟大模型逐token生成（如 OpenAI/Claude streaming API）
+	tokens := []string{
+		"你好", "！", "我", "是", "AI", "助手", "。\n",
+		"根据", "你的", "提示", "「", prompt, "」", "，\n",
+		"我", "将", "逐步", "生成", "回答", "内容", "。\n",
+		"这", "展示", "了", "通道", "解耦", "的", "威力", "！",
+	}
+	if opts.MaxTokens > 0 && opts.MaxTokens < len(tokens) {
+		tokens = tokens[:opts.MaxTokens]
+	}
+
+	delay := e.Delay
+	if opts.Delay > 0 {
+		delay = opts.Delay
+	}
+
+	for _, token := range tokens {
+		// 模拟大模型API的延迟（生成延迟），等待期间也能响应取消
+		if err := Sleep(ctx, delay); err != nil {
+			return err
+		}
+		if err := Send(ctx, out, token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,120 @@
+// Package generator 定义"提示词进、token流出"的生成器接口及几种内置实现
+//
+//   - echo:   脚本化的回显生成器（原 generateWithPipeline 中写死的token列表）
+//   - replay: 按录制的间隔回放转录文件
+//   - openai: 代理到本地 OpenAI 兼容的 /v1/chat/completions 接口
+package generator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Options 是一次生成的参数，零值字段由各生成器使用自己的默认值
+type Options struct {
+	Model       string        // 模型名，openai 生成器会透传给上游
+	MaxTokens   int           // 最多生成的token数，0 表示不限制
+	Temperature float64       // 采样温度，openai 生成器会透传给上游
+	Delay       time.Duration // 每个token的生成延迟，echo 生成器使用
+}
+
+// Generator 把 prompt 对应的token依次发送到 out
+//
+// 实现必须在 ctx 取消后尽快返回 ctx.Err()，每次发送都应使用 Send 以免阻塞泄漏；
+// out 由调用者负责关闭。正常生成完毕返回 nil。
+type Generator interface {
+	Generate(ctx context.Context, prompt string, opts Options, out chan<- string) error
+}
+
+// Deterministic 由对相同的提示词和参数总是生成相同token序列的生成器实现
+// 只有这样的生成器才能通过重新生成并跳过已发送的token来续传
+type Deterministic interface {
+	Deterministic() bool
+}
+
+// IsDeterministic 报告 g 是否声明自己是确定性的
+func IsDeterministic(g Generator) bool {
+	d, ok := g.(Deterministic)
+	return ok && d.Deterministic()
+}
+
+// Func 让普通函数实现 Generator 接口
+type Func func(ctx context.Context, prompt string, opts Options, out chan<- string) error
+
+// Generate 调用 f 本身
+func (f Func) Generate(ctx context.Context, prompt string, opts Options, out chan<- string) error {
+	return f(ctx, prompt, opts, out)
+}
+
+// ErrUnknown 表示注册表中没有该名称的生成器
+var ErrUnknown = errors.New("generator: unknown generator")
+
+// Send 把token发送到 out，同时监听取消
+func Send(ctx context.Context, out chan<- string, token string) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case out <- token:
+		return nil
+	}
+}
+
+// Sleep 等待 d，期间 ctx 取消则提前返回 ctx.Err()
+func Sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err()
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// Registry 按名称保存生成器，可并发使用
+type Registry struct {
+	mu   sync.RWMutex
+	gens map[string]Generator
+}
+
+// NewRegistry 创建空注册表
+func NewRegistry() *Registry {
+	return &Registry{gens: make(map[string]Generator)}
+}
+
+// Register 注册生成器，同名时覆盖
+func (r *Registry) Register(name string, g Generator) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.gens[name] = g
+}
+
+// Get 按名称查找生成器
+func (r *Registry) Get(name string) (Generator, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	g, ok := r.gens[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknown, name)
+	}
+	return g, nil
+}
+
+// Names 返回已注册的名称（已排序）
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.gens))
+	for name := range r.gens {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,94 @@
+package generator
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// collect 运行生成器并收集所有token
+func collect(t *testing.T, g Generator, prompt string, opts Options) ([]string, error) {
+	t.Helper()
+	out := make(chan string)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(out)
+		errc <- g.Generate(context.Background(), prompt, opts, out)
+	}()
+
+	var tokens []string
+	for tok := range out {
+		tokens = append(tokens, tok)
+	}
+	return tokens, <-errc
+}
+
+func TestEchoMaxTokens(t *testing.T) {
+	tokens, err := collect(t, Echo{}, "p", Options{MaxTokens: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(tokens, ""); got != "你好！我" {
+		t.Errorf("tokens = %q", got)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	tokens, err := collect(t, Replay{Path: "testdata/transcript.jsonl", Speed: 100}, "", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(tokens, ""); got != "你好！这是回放的内容。" {
+		t.Errorf("tokens = %q", got)
+	}
+
+	if _, err := collect(t, Replay{Path: "testdata/missing.jsonl"}, "", Options{}); err == nil {
+		t.Error("文件不存在时应当返回错误")
+	}
+}
+
+func TestOpenAI(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/chat/completions" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, tok := range []string{"上游", "的", "回答"} {
+			fmt.Fprintf(w, "data: {\"choices\":[{\"delta\":{\"content\":%q}}]}\n\n", tok)
+		}
+		fmt.Fprint(w, "data: [DONE]\n\n")
+	}))
+	defer srv.Close()
+
+	tokens, err := collect(t, OpenAI{BaseURL: srv.URL}, "hi", Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(tokens, ""); got != "上游的回答" {
+		t.Errorf("tokens = %q", got)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	r.Register("echo", Echo{})
+	if _, err := r.Get("echo"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Get("nope"); err == nil {
+		t.Error("未注册的名称应当返回 ErrUnknown")
+	}
+}
+
+func TestIsDeterministic(t *testing.T) {
+	if !IsDeterministic(Echo{}) || !IsDeterministic(Replay{}) {
+		t.Error("echo and replay should be deterministic")
+	}
+	if IsDeterministic(OpenAI{}) || IsDeterministic(Func(nil)) {
+		t.Error("openai and plain funcs must not resume by regenerating")
+	}
+}
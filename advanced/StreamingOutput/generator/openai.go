@@ -0,0 +1,123 @@
+package generator
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// OpenAI 把生成请求代理到 OpenAI 兼容的流式接口（如本地的 llama.cpp / vLLM / Ollama）
+type OpenAI struct {
+	BaseURL string       // 例如 http://localhost:11434，请求发往 BaseURL + "/v1/chat/completions"
+	APIKey  string       // 可选，非空时以 Bearer token 发送
+	Model   string       // 默认模型名，Options.Model 非空时优先
+	Client  *http.Client // 为 nil 时使用 http.DefaultClient
+}
+
+type chatMessage struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+type chatRequest struct {
+	Model       string        `json:"model"`
+	Messages    []chatMessage `json:"messages"`
+	Stream      bool          `json:"stream"`
+	MaxTokens   int           `json:"max_tokens,omitempty"`
+	Temperature float64       `json:"temperature,omitempty"`
+}
+
+type chatChunk struct {
+	Choices []struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	} `json:"choices"`
+}
+
+// Generate 实现 Generator 接口：解析上游的 SSE 响应，把每个 delta.content 作为一个token转发
+func (g OpenAI) Generate(ctx context.Context, prompt string, opts Options, out chan<- string) error {
+	model := g.Model
+	if opts.Model != "" {
+		model = opts.Model
+	}
+	body, err := json.Marshal(chatRequest{
+		Model:       model,
+		Messages:    []chatMessage{{Role: "user", Content: prompt}},
+		Stream:      true,
+		MaxTokens:   opts.MaxTokens,
+		Temperature: opts.Temperature,
+	})
+	if err != nil {
+		return err
+	}
+
+	url := strings.TrimSuffix(g.BaseURL, "/") + "/v1/chat/completions"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "text/event-stream")
+	if g.APIKey != "" {
+		req.Header.Set("Authorization", "Bearer "+g.APIKey)
+	}
+
+	client := g.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("generator: openai request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("generator: openai upstream %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		data, ok := strings.CutPrefix(line, "data:")
+		if !ok {
+			continue // 空行、注释、event/id 字段
+		}
+		data = strings.TrimPrefix(data, " ")
+		if data == "[DONE]" {
+			return nil
+		}
+
+		var chunk chatChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return fmt.Errorf("generator: openai chunk: %w", err)
+		}
+		for _, c := range chunk.Choices {
+			if c.Delta.Content == "" {
+				continue
+			}
+			if err := Send(ctx, out, c.Delta.Content); err != nil {
+				return err
+			}
+		}
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("generator: openai stream: %w", err)
+	}
+	// 上游没有发送 [DONE] 就关闭了连接，视为正常结束
+	return nil
+}
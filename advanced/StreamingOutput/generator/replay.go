@@ -0,0 +1,86 @@
+package generator
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Replay 按录制的间隔回放转录文件，用于复现一次真实的生成过程
+//
+// 文件为 JSON Lines，每行一个token及它距上一个token的延迟（毫秒）：
+//
+//	{"delay_ms": 120, "token": "你好"}
+//	{"delay_ms": 35, "token": "！"}
+//
+// 空行和以 # 开头的行会被忽略。prompt 不影响回放内容。
+type Replay struct {
+	Path  string  // 转录文件路径
+	Speed float64 // 回放倍速，0 或 1 为原速，2 表示两倍速
+}
+
+// Deterministic 实现 Deterministic 接口：同一个转录文件总是回放出相同的token序列
+func (Replay) Deterministic() bool { return true }
+
+// ReplayEntry 是转录文件中的一行
+type ReplayEntry struct {
+	DelayMS int64  `json:"delay_ms"`
+	Token   string `json:"token"`
+}
+
+// Generate 实现 Generator 接口，每次调用都重新读取文件
+func (g Replay) Generate(ctx context.Context, prompt string, opts Options, out chan<- string) error {
+	entries, err := ReadTranscript(g.Path)
+	if err != nil {
+		return err
+	}
+	if opts.MaxTokens > 0 && opts.MaxTokens < len(entries) {
+		entries = entries[:opts.MaxTokens]
+	}
+
+	speed := g.Speed
+	if speed <= 0 {
+		speed = 1
+	}
+
+	for _, e := range entries {
+		delay := time.Duration(float64(e.DelayMS) * float64(time.Millisecond) / speed)
+		if err := Sleep(ctx, delay); err != nil {
+			return err
+		}
+		if err := Send(ctx, out, e.Token); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadTranscript 读取并解析转录文件
+func ReadTranscript(path string) ([]ReplayEntry, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("generator: open transcript: %w", err)
+	}
+	defer f.Close()
+
+	var entries []ReplayEntry
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := scanner.Bytes()
+		if len(text) == 0 || text[0] == '#' {
+			continue
+		}
+		var e ReplayEntry
+		if err := json.Unmarshal(text, &e); err != nil {
+			return nil, fmt.Errorf("generator: %s:%d: %w", path, line, err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("generator: read transcript: %w", err)
+	}
+	return entries, nil
+}
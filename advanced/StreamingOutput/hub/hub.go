@@ -0,0 +1,201 @@
+// Package hub 实现一对多的广播：一个生产者发布，N 个订阅者各自通过有界缓冲区接收
+//
+// Publish 永远不会阻塞，订阅者缓冲区满时按慢消费者策略处理，
+// 因此一个慢客户端不会拖慢生产者或其他订阅者。
+package hub
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// Policy 是订阅者缓冲区满时的处理策略
+type Policy int
+
+const (
+	DropOldest Policy = iota // 丢弃缓冲区中最旧的一条，放入新消息（默认）
+	DropNewest               // 丢弃新消息，保留缓冲区内容
+	Disconnect               // 断开该订阅者，关闭其通道
+)
+
+func (p Policy) String() string {
+	switch p {
+	case DropOldest:
+		return "drop-oldest"
+	case DropNewest:
+		return "drop-newest"
+	case Disconnect:
+		return "disconnect"
+	}
+	return "unknown"
+}
+
+// ParsePolicy 把 drop-oldest / drop-newest / disconnect 解析为 Policy
+func ParsePolicy(s string) (Policy, error) {
+	switch s {
+	case "", "drop-oldest":
+		return DropOldest, nil
+	case "drop-newest":
+		return DropNewest, nil
+	case "disconnect":
+		return Disconnect, nil
+	}
+	return 0, errors.New("hub: unknown policy " + s)
+}
+
+var (
+	// ErrSlowConsumer 表示订阅者因消费太慢被 Disconnect 策略断开
+	ErrSlowConsumer = errors.New("hub: slow consumer disconnected")
+	// ErrHubClosed 表示 Hub 已关闭
+	ErrHubClosed = errors.New("hub: closed")
+)
+
+// Options 配置每个订阅者的缓冲区和慢消费者策略
+type Options struct {
+	Buffer int    // 每个订阅者的缓冲区大小，<=0 时为 16
+	Policy Policy // 缓冲区满时的策略
+}
+
+// Stats 是 Hub 的统计信息
+type Stats struct {
+	Subscribers  int    `json:"subscribers"`  // 当前订阅者数
+	Joined       uint64 `json:"joined"`       // 累计加入
+	Left         uint64 `json:"left"`         // 累计主动离开
+	Disconnected uint64 `json:"disconnected"` // 累计因慢消费被断开
+	Published    uint64 `json:"published"`    // 累计发布的消息数
+	Dropped      uint64 `json:"dropped"`      // 累计丢弃的消息数（所有订阅者之和）
+}
+
+// Hub 把消息广播给所有订阅者，可并发使用
+type Hub[T any] struct {
+	opts Options
+
+	mu     sync.Mutex
+	subs   map[*Subscriber[T]]struct{}
+	closed bool
+	stats  Stats
+}
+
+// New 创建 Hub
+func New[T any](opts Options) *Hub[T] {
+	if opts.Buffer <= 0 {
+		opts.Buffer = 16
+	}
+	return &Hub[T]{opts: opts, subs: make(map[*Subscriber[T]]struct{})}
+}
+
+// Subscriber 是一个订阅者，从 C() 读取消息；通道关闭后通过 Err() 得知原因
+type Subscriber[T any] struct {
+	ch      chan T
+	dropped atomic.Uint64
+	err     error // 通道关闭的原因，关闭前写入
+}
+
+// C 返回消息通道，订阅者被断开、取消订阅或 Hub 关闭时通道关闭
+func (s *Subscriber[T]) C() <-chan T { return s.ch }
+
+// Dropped 返回该订阅者被丢弃的消息数
+func (s *Subscriber[T]) Dropped() uint64 { return s.dropped.Load() }
+
+// Err 返回通道关闭的原因：nil（主动取消订阅）、ErrSlowConsumer 或 ErrHubClosed
+// 只应在 C() 关闭之后调用
+func (s *Subscriber[T]) Err() error { return s.err }
+
+// Subscribe 加入一个订阅者，Hub 已关闭时返回的订阅者通道立即关闭
+func (h *Hub[T]) Subscribe() *Subscriber[T] {
+	s := &Subscriber[T]{ch: make(chan T, h.opts.Buffer)}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		s.err = ErrHubClosed
+		close(s.ch)
+		return s
+	}
+	h.subs[s] = struct{}{}
+	h.stats.Joined++
+	return s
+}
+
+// Unsubscribe 移除订阅者并关闭其通道，重复调用是安全的
+func (h *Hub[T]) Unsubscribe(s *Subscriber[T]) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[s]; !ok {
+		return
+	}
+	delete(h.subs, s)
+	h.stats.Left++
+	close(s.ch)
+}
+
+// Publish 把消息发给所有订阅者，不会阻塞
+func (h *Hub[T]) Publish(v T) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.stats.Published++
+
+	for s := range h.subs {
+		select {
+		case s.ch <- v:
+			continue
+		default:
+		}
+
+		// 缓冲区已满，按策略处理
+		switch h.opts.Policy {
+		case DropOldest:
+			// 只有 Publish 会写入通道且持有锁，所以取出一条后一定能放入
+			select {
+			case <-s.ch:
+			default:
+			}
+			s.ch <- v
+			s.dropped.Add(1)
+			h.stats.Dropped++
+		case DropNewest:
+			s.dropped.Add(1)
+			h.stats.Dropped++
+		case Disconnect:
+			delete(h.subs, s)
+			h.stats.Disconnected++
+			s.err = ErrSlowConsumer
+			close(s.ch)
+		}
+	}
+}
+
+// Len 返回当前订阅者数
+func (h *Hub[T]) Len() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+// Stats 返回统计信息快照
+func (h *Hub[T]) Stats() Stats {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st := h.stats
+	st.Subscribers = len(h.subs)
+	return st
+}
+
+// Close 关闭 Hub 及所有订阅者的通道
+func (h *Hub[T]) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return
+	}
+	h.closed = true
+	for s := range h.subs {
+		s.err = ErrHubClosed
+		close(s.ch)
+	}
+	h.subs = nil
+}
@@ -0,0 +1,130 @@
+package hub
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSlowConsumerDoesNotStallOthers 一个从不读取的订阅者不应影响其他订阅者
+func TestSlowConsumerDoesNotStallOthers(t *testing.T) {
+	for _, policy := range []Policy{DropOldest, DropNewest, Disconnect} {
+		t.Run(policy.String(), func(t *testing.T) {
+			h := New[int](Options{Buffer: 4, Policy: policy})
+			slow := h.Subscribe()
+
+			const n = 1000
+			const fast = 3
+			var wg sync.WaitGroup
+			for i := 0; i < fast; i++ {
+				s := h.Subscribe()
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					want := 0
+					for v := range s.C() {
+						if v != want {
+							t.Errorf("got %d, want %d", v, want)
+							return
+						}
+						want++
+						if want == n {
+							return
+						}
+					}
+				}()
+			}
+
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				for i := 0; i < n; i++ {
+					h.Publish(i)
+					// 给快速订阅者留出消费时间，保证它们的缓冲区不会满
+					if i%4 == 3 {
+						waitDrained(h, slow)
+					}
+				}
+			}()
+
+			select {
+			case <-done:
+			case <-time.After(5 * time.Second):
+				t.Fatal("Publish 被慢消费者阻塞")
+			}
+			wg.Wait()
+
+			switch policy {
+			case DropOldest:
+				if got := drain(slow); len(got) != 4 || got[3] != n-1 {
+					t.Errorf("drop-oldest 应保留最新的4条, got %v", got)
+				}
+			case DropNewest:
+				if got := drain(slow); len(got) != 4 || got[0] != 0 {
+					t.Errorf("drop-newest 应保留最旧的4条, got %v", got)
+				}
+			case Disconnect:
+				drain(slow)
+				if slow.Err() != ErrSlowConsumer {
+					t.Errorf("Err() = %v, want ErrSlowConsumer", slow.Err())
+				}
+				if st := h.Stats(); st.Disconnected != 1 || st.Subscribers != fast {
+					t.Errorf("stats = %+v", st)
+				}
+			}
+		})
+	}
+}
+
+func TestJoinLeaveAccounting(t *testing.T) {
+	h := New[string](Options{})
+	a := h.Subscribe()
+	b := h.Subscribe()
+	h.Unsubscribe(a)
+	h.Unsubscribe(a) // 重复取消订阅不重复计数
+
+	if st := h.Stats(); st.Joined != 2 || st.Left != 1 || st.Subscribers != 1 {
+		t.Errorf("stats = %+v", st)
+	}
+
+	h.Close()
+	if _, ok := <-b.C(); ok || b.Err() != ErrHubClosed {
+		t.Errorf("Close 后通道应关闭, err = %v", b.Err())
+	}
+	if s := h.Subscribe(); s.Err() != ErrHubClosed {
+		t.Errorf("关闭后订阅应失败, err = %v", s.Err())
+	}
+}
+
+// waitDrained 等待除 slow 之外的订阅者把缓冲区读空
+func waitDrained[T any](h *Hub[T], slow *Subscriber[T]) {
+	for {
+		h.mu.Lock()
+		busy := false
+		for s := range h.subs {
+			if s != slow && len(s.ch) > 0 {
+				busy = true
+			}
+		}
+		h.mu.Unlock()
+		if !busy {
+			return
+		}
+		time.Sleep(time.Microsecond)
+	}
+}
+
+func drain[T any](s *Subscriber[T]) []T {
+	var out []T
+	for {
+		select {
+		case v, ok := <-s.C():
+			if !ok {
+				return out
+			}
+			out = append(out, v)
+		default:
+			return out
+		}
+	}
+}
@@ -0,0 +1,174 @@
+// Package jsonstream 以流式方式输出JSON数组，并通过 HTTP trailer 报告响应是否完整
+//
+// 直接手写 "[" 和逗号的做法在中途出错或服务器中止时只会留下被截断的数组和 200 状态码，
+// 客户端无法区分"数组本来就这么短"和"被截断了"。Encoder 保证：
+//   - 只要连接还在，结束时总会写出 "]"，得到合法的JSON
+//   - 响应结束后发送 trailer：
+//     X-Stream-Status    complete / aborted
+//     X-Stream-Count     写出的元素个数
+//     X-Stream-Checksum  sha256:<十六进制>，覆盖响应体的全部字节（从 "[" 到最后的换行）
+//     X-Stream-Error     aborted 时的原因
+//
+// 用法：
+//
+//	enc, _ := jsonstream.NewEncoder[Message](w)
+//	for ... {
+//		if err := enc.Encode(msg); err != nil { return } // 客户端已断开
+//	}
+//	enc.Close() // 或 enc.Abort(err)
+package jsonstream
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Trailer 名称
+const (
+	TrailerStatus   = "X-Stream-Status"
+	TrailerCount    = "X-Stream-Count"
+	TrailerChecksum = "X-Stream-Checksum"
+	TrailerError    = "X-Stream-Error"
+)
+
+// X-Stream-Status 的取值
+const (
+	StatusComplete = "complete"
+	StatusAborted  = "aborted"
+)
+
+// ErrClosed 表示 Encoder 已经结束
+var ErrClosed = errors.New("jsonstream: encoder closed")
+
+// Encoder 把 T 类型的元素逐个写成一个JSON数组，每个元素写完后立即刷新
+type Encoder[T any] struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	body    io.Writer // 同时写入响应和校验和
+	sum     hash.Hash
+
+	prefix, indent string
+	count          int
+	closed         bool
+	err            error // 第一次写入失败的错误，之后的写入直接返回它
+}
+
+// NewEncoder 设置响应头并声明 trailer，响应头在第一次写入时发出
+func NewEncoder[T any](w http.ResponseWriter) (*Encoder[T], error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, errors.New("jsonstream: streaming unsupported")
+	}
+	h := w.Header()
+	h.Set("Content-Type", "application/json; charset=utf-8")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Trailer", strings.Join([]string{TrailerStatus, TrailerCount, TrailerChecksum, TrailerError}, ", "))
+
+	sum := sha256.New()
+	return &Encoder[T]{
+		w:       w,
+		flusher: flusher,
+		body:    io.MultiWriter(w, sum),
+		sum:     sum,
+		prefix:  "  ",
+		indent:  "  ",
+	}, nil
+}
+
+// SetIndent 设置每个元素的缩进，与 json.MarshalIndent 相同；都为空时每个元素占一行
+func (e *Encoder[T]) SetIndent(prefix, indent string) {
+	e.prefix, e.indent = prefix, indent
+}
+
+// Count 返回已写出的元素个数
+func (e *Encoder[T]) Count() int { return e.count }
+
+// Encode 写出一个元素并刷新；写入失败通常意味着客户端已经断开
+func (e *Encoder[T]) Encode(v T) error {
+	if e.closed {
+		return ErrClosed
+	}
+	if e.err != nil {
+		return e.err
+	}
+
+	var data []byte
+	var err error
+	if e.prefix == "" && e.indent == "" {
+		data, err = json.Marshal(v)
+	} else {
+		data, err = json.MarshalIndent(v, e.prefix, e.indent)
+	}
+	if err != nil {
+		return err // 编码失败不影响已经写出的内容，调用者可以跳过或 Abort
+	}
+
+	sep := ",\n"
+	if e.count == 0 {
+		sep = "[\n"
+	}
+	if err := e.write(sep + e.prefix + string(data)); err != nil {
+		return err
+	}
+	e.count++
+	e.flusher.Flush()
+	return nil
+}
+
+// Close 结束数组，trailer 中的状态为 complete
+func (e *Encoder[T]) Close() error {
+	return e.finish(StatusComplete, "")
+}
+
+// Abort 在服务器主动中止时结束数组（JSON 依然合法），trailer 中的状态为 aborted
+func (e *Encoder[T]) Abort(reason error) error {
+	msg := "aborted"
+	if reason != nil {
+		msg = reason.Error()
+	}
+	return e.finish(StatusAborted, msg)
+}
+
+func (e *Encoder[T]) finish(status, reason string) error {
+	if e.closed {
+		return ErrClosed
+	}
+	e.closed = true
+
+	tail := "\n]\n"
+	if e.count == 0 {
+		tail = "[]\n"
+	}
+	if err := e.write(tail); err != nil {
+		return err
+	}
+
+	// 声明过的 trailer 在处理器返回后随最后一个分块发出
+	h := e.w.Header()
+	h.Set(TrailerStatus, status)
+	h.Set(TrailerCount, strconv.Itoa(e.count))
+	h.Set(TrailerChecksum, "sha256:"+hex.EncodeToString(e.sum.Sum(nil)))
+	if reason != "" {
+		h.Set(TrailerError, strings.NewReplacer("\r", " ", "\n", " ").Replace(reason))
+	}
+	e.flusher.Flush()
+	return nil
+}
+
+func (e *Encoder[T]) write(s string) error {
+	if e.err != nil {
+		return e.err
+	}
+	if _, err := io.WriteString(e.body, s); err != nil {
+		e.err = err
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package jsonstream
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type item struct {
+	N int `json:"n"`
+}
+
+// get 请求 h 并返回完整响应体和 trailer
+func get(t *testing.T, h http.HandlerFunc) ([]byte, http.Header) {
+	t.Helper()
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body, resp.Trailer
+}
+
+func TestEncoderTrailers(t *testing.T) {
+	tests := []struct {
+		name   string
+		items  int
+		abort  error
+		status string
+	}{
+		{"complete", 3, nil, StatusComplete},
+		{"empty", 0, nil, StatusComplete},
+		{"aborted", 2, errors.New("upstream failed"), StatusAborted},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, trailer := get(t, func(w http.ResponseWriter, r *http.Request) {
+				enc, err := NewEncoder[item](w)
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				for i := 0; i < tt.items; i++ {
+					enc.Encode(item{N: i})
+				}
+				if tt.abort != nil {
+					enc.Abort(tt.abort)
+				} else {
+					enc.Close()
+				}
+			})
+
+			// 无论完成还是中止，响应体都是合法的JSON数组
+			var got []item
+			if err := json.Unmarshal(body, &got); err != nil || len(got) != tt.items {
+				t.Fatalf("body %q: %v (%d items)", body, err, len(got))
+			}
+			sum := sha256.Sum256(body)
+			want := map[string]string{
+				TrailerStatus:   tt.status,
+				TrailerCount:    string(rune('0' + tt.items)),
+				TrailerChecksum: "sha256:" + hex.EncodeToString(sum[:]),
+			}
+			if tt.abort != nil {
+				want[TrailerError] = tt.abort.Error()
+			}
+			for k, v := range want {
+				if got := trailer.Get(k); got != v {
+					t.Errorf("trailer %s = %q, want %q", k, got, v)
+				}
+			}
+		})
+	}
+}
+
+// TestEncoderNoCloseMeansNoTrailer 处理器直接返回（例如 panic 或客户端断开）时没有 complete 状态
+func TestEncoderNoCloseMeansNoTrailer(t *testing.T) {
+	_, trailer := get(t, func(w http.ResponseWriter, r *http.Request) {
+		enc, _ := NewEncoder[item](w)
+		enc.Encode(item{N: 1})
+	})
+	if got := trailer.Get(TrailerStatus); got != "" {
+		t.Fatalf("status = %q, want empty", got)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"go-learning/advanced/StreamingOutput/ratelimit"
+	"go-learning/advanced/StreamingOutput/sse"
+)
+
+// ============ 按客户端限流 ============
+//
+// 客户端按认证通过的身份区分，未配置认证时按 IP（见 clientKey）。
+// 每个流式请求消耗一个令牌（-rate-limit / -rate-burst），
+// 同一客户端同时进行的流不超过 -max-streams-per-client，WebSocket 上的每个新提示词也消耗一个令牌。
+// 两者默认都是 0，即不限流。
+//
+// 被拒绝时返回 429 和 Retry-After。
+// SSE 请求例外：EventSource 收到 429 会永久放弃重连，所以改为返回一条 error 信封
+// （code 为 rate_limited 或 too_many_streams），并用 retry 字段让它按 Retry-After 重连。
+
+var (
+	limiterOnce sync.Once
+	limiterInst *ratelimit.Limiter
+
+	rejectedRequests = promRegistry.Counter("streaming_rejected_total",
+		"Requests rejected by per-client rate limits, by reason.", "route", "reason")
+)
+
+// sharedLimiter 返回全局的限流器，首次调用时根据配置创建
+func sharedLimiter() *ratelimit.Limiter {
+	limiterOnce.Do(func() {
+		limiterInst = ratelimit.New(ratelimit.Options{
+			Rate:       cfg.RateLimit,
+			Burst:      cfg.RateBurst,
+			MaxStreams: cfg.MaxStreamsPerClient,
+		})
+	})
+	return limiterInst
+}
+
+// limited 为流式路由添加按客户端的速率和并发限制，两种限制都关闭时原样返回
+func limited(route string, h http.Handler) http.Handler {
+	if cfg.RateLimit <= 0 && cfg.MaxStreamsPerClient <= 0 {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release, retry, err := sharedLimiter().Admit(clientKey(r))
+		if err != nil {
+			rejectLimited(w, r, route, retry, err)
+			return
+		}
+		defer release()
+		h.ServeHTTP(w, r)
+	})
+}
+
+// clientKey 返回区分客户端的标识：认证通过的身份，未配置认证时为客户端 IP
+// 请求头中未经验证的 API Key 不能作为标识，否则换一个随意的值就能绕过限流
+func clientKey(r *http.Request) string {
+	if principal, ok := principalFrom(r.Context()); ok {
+		return "principal:" + principal
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// rejectLimited 以 429 或SSE错误事件拒绝请求
+func rejectLimited(w http.ResponseWriter, r *http.Request, route string, retry time.Duration, err error) {
+	reason := "rate"
+	if errors.Is(err, ratelimit.ErrTooManyStreams) {
+		reason = "streams"
+	}
+	rejectedRequests.With(route, reason).Inc()
+	code := "rate_limited"
+	if reason == "streams" {
+		code = "too_many_streams"
+	}
+
+	// Retry-After 以秒为单位，至少 1 秒
+	secs := int(math.Ceil(retry.Seconds()))
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(secs))
+	loggerFrom(r.Context()).Warn("请求被限流", "reason", reason, "retry_after", secs)
+
+	if sse.Accepts(r) {
+		if sw, werr := sse.NewWriter(w, r); werr == nil {
+			// 流还没有开始，只发送错误信封，不发送 done 和 usage；连接关闭后 EventSource 按 retry 重连
+			wait := time.Duration(secs) * time.Second
+			rec := record(newEventStream(r.Context(), resumeFrom(r)).Error(code, err.Error(), wait))
+			rec.Retry = wait
+			sendRecords(r.Context(), sw, rec)
+			return
+		}
+	}
+	http.Error(w, err.Error(), http.StatusTooManyRequests)
+}
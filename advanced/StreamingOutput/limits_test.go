@@ -0,0 +1,58 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-learning/advanced/StreamingOutput/auth"
+	"go-learning/advanced/StreamingOutput/ratelimit"
+)
+
+// TestRejectLimited 普通请求返回 429，SSE 请求（无论是否重连）收到 error 信封和 retry
+func TestRejectLimited(t *testing.T) {
+	rec := httptest.NewRecorder()
+	rejectLimited(rec, httptest.NewRequest("GET", "/stream/pipeline", nil), "/stream/pipeline", 1500*time.Millisecond, ratelimit.ErrRateLimited)
+	if rec.Code != http.StatusTooManyRequests || rec.Header().Get("Retry-After") != "2" {
+		t.Fatalf("plain rejection = %d Retry-After %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	for _, lastID := range []string{"", "7"} {
+		req := httptest.NewRequest("GET", "/stream/pipeline", nil)
+		req.Header.Set("Accept", "text/event-stream")
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		rec = httptest.NewRecorder()
+		rejectLimited(rec, req, "/stream/pipeline", time.Second, ratelimit.ErrTooManyStreams)
+		body := rec.Body.String()
+		if rec.Code != http.StatusOK || !strings.Contains(body, "event: error\n") || !strings.Contains(body, "retry: 1000\n") ||
+			!strings.Contains(body, `"type":"error"`) || !strings.Contains(body, `"code":"too_many_streams"`) ||
+			!strings.Contains(body, `"retry_after_ms":1000`) {
+			t.Fatalf("SSE rejection (Last-Event-ID %q) = %d %q", lastID, rec.Code, body)
+		}
+	}
+}
+
+// TestClientKey 按认证通过的身份区分客户端，未经验证的请求头不起作用
+func TestClientKey(t *testing.T) {
+	r := httptest.NewRequest("GET", "/", nil)
+	r.RemoteAddr = "10.0.0.1:5555"
+	r.Header.Set("Authorization", "Bearer made-up")
+	if got := clientKey(r); got != "ip:10.0.0.1" {
+		t.Fatalf("unauthenticated key = %q", got)
+	}
+
+	keys, _ := auth.ParseKeys([]byte("secret alice\n"))
+	withAuth(t, &auth.Authenticator{Keys: keys})
+	r.Header.Set("Authorization", "Bearer secret")
+	var got string
+	authenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = clientKey(r)
+	})).ServeHTTP(httptest.NewRecorder(), r)
+	if got != "principal:alice" {
+		t.Fatalf("authenticated key = %q", got)
+	}
+}
@@ -0,0 +1,326 @@
+// loadtest 对任意流式路由做并发压测，统计首字节时间（TTFB）、块间隔、总时长和错误
+//
+//	go run ./loadtest -url http://localhost:8080/stream/pipeline -c 200 -ramp 10s -out report.json
+//	go run ./loadtest -url http://localhost:8080/stream/sse -sse -c 50 -n 3
+//
+// 每个连接按 -ramp 均匀错开启动，各自顺序发起 -n 个请求；
+// 每次 Read 到数据算一个块（-sse 时每条事件算一块）。
+// 结果以百分位表打印，并可以写出 JSON 报告，方便在两次提交之间 diff。
+//
+// 被服务器拒绝的请求（非 2xx，或 -sse 时第一条事件就是 error）单独计数，不计入 TTFB 等统计。
+// 服务器开启了按客户端限流时，压测的所有连接都来自同一个客户端，大部分请求会被拒绝，
+// 因此压测前应当以 -rate-limit 0 -max-streams-per-client 0（默认值）启动服务器：
+//
+//	go run . -rate-limit 0 -max-streams-per-client 0
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"go-learning/advanced/StreamingOutput/sse"
+	httpconfig "go-learning/advanced/httpConfig"
+)
+
+// options 是命令行参数
+type options struct {
+	URL         string        `json:"url"`
+	Concurrency int           `json:"concurrency"`
+	Requests    int           `json:"requests_per_connection"`
+	Ramp        time.Duration `json:"-"`
+	Timeout     time.Duration `json:"-"`
+	SSE         bool          `json:"sse"`
+	Token       string        `json:"-"`
+	Out         string        `json:"-"`
+	Label       string        `json:"label,omitempty"`
+}
+
+// result 是一次请求的测量结果
+type result struct {
+	Status   int
+	Rejected string // 被服务器拒绝的原因，如 "http 429" 或 "sse error"；为空表示没有被拒绝
+	TTFB     time.Duration
+	Gaps     []time.Duration
+	Total    time.Duration
+	Chunks   int
+	Bytes    int64
+	Err      error
+}
+
+// Report 是 JSON 报告
+type Report struct {
+	Options  options        `json:"options"`
+	Ramp     string         `json:"ramp"`
+	Elapsed  float64        `json:"elapsed_s"`
+	Requests int            `json:"requests"`
+	Success  int            `json:"success"`
+	Rejected map[string]int `json:"rejected"` // 被服务器拒绝的请求，按原因计数，不计入耗时统计
+	Errors   map[string]int `json:"errors"`
+	Chunks   int            `json:"chunks"`
+	Bytes    int64          `json:"bytes"`
+	TTFB     Summary        `json:"ttfb"`
+	Gap      Summary        `json:"inter_chunk"`
+	Total    Summary        `json:"total"`
+}
+
+func main() {
+	var opts options
+	flag.StringVar(&opts.URL, "url", "http://localhost:8080/stream/pipeline", "要压测的流式接口")
+	flag.IntVar(&opts.Concurrency, "c", 10, "并发连接数")
+	flag.IntVar(&opts.Requests, "n", 1, "每个连接顺序发起的请求数")
+	flag.DurationVar(&opts.Ramp, "ramp", 0, "在这段时间内均匀启动全部连接，0 表示同时启动")
+	flag.DurationVar(&opts.Timeout, "timeout", time.Minute, "单个请求的超时时间")
+	flag.BoolVar(&opts.SSE, "sse", false, "以 text/event-stream 请求，每条事件算一个块")
+	flag.StringVar(&opts.Token, "token", "", "Bearer Token")
+	flag.StringVar(&opts.Out, "out", "", "JSON 报告的输出文件，- 表示标准输出")
+	flag.StringVar(&opts.Label, "label", "", "写入报告的标签，例如提交号")
+	flag.Parse()
+
+	if opts.Concurrency <= 0 || opts.Requests <= 0 {
+		fmt.Fprintln(os.Stderr, "-c 和 -n 必须大于 0")
+		os.Exit(2)
+	}
+
+	// Ctrl+C 时停止发起新请求，已有结果照常输出
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+
+	report := run(ctx, opts)
+	printReport(os.Stdout, report)
+
+	if opts.Out != "" {
+		if err := writeReport(opts.Out, report); err != nil {
+			fmt.Fprintln(os.Stderr, "写入报告失败:", err)
+			os.Exit(1)
+		}
+	}
+	if report.Success < report.Requests {
+		os.Exit(1)
+	}
+}
+
+// run 按 ramp 启动全部连接并汇总结果
+func run(ctx context.Context, opts options) Report {
+	// 连接池上限必须不小于并发数，否则多出的连接会在客户端排队，测出来的是排队时间
+	transport := httpconfig.NewProductionTransport()
+	transport.MaxConnsPerHost = opts.Concurrency
+	transport.MaxIdleConnsPerHost = opts.Concurrency
+	transport.DisableCompression = true // 不透明解压，按线上收到的字节计时
+	hc := &http.Client{Transport: transport}
+	defer transport.CloseIdleConnections()
+
+	results := make(chan result, opts.Concurrency)
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < opts.Concurrency; i++ {
+		delay := time.Duration(0)
+		if opts.Concurrency > 1 {
+			delay = opts.Ramp * time.Duration(i) / time.Duration(opts.Concurrency-1)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			for n := 0; n < opts.Requests && ctx.Err() == nil; n++ {
+				results <- measure(ctx, hc, opts)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	report := Report{Options: opts, Ramp: opts.Ramp.String(), Rejected: make(map[string]int), Errors: make(map[string]int)}
+	var ttfbs, gaps, totals []time.Duration
+	for r := range results {
+		report.Requests++
+		if r.Rejected != "" {
+			report.Rejected[r.Rejected]++
+			continue
+		}
+		report.Chunks += r.Chunks
+		report.Bytes += r.Bytes
+		if r.Err != nil {
+			report.Errors[errorKind(r)]++
+		} else {
+			report.Success++
+			totals = append(totals, r.Total)
+		}
+		if r.Chunks > 0 {
+			ttfbs = append(ttfbs, r.TTFB)
+		}
+		gaps = append(gaps, r.Gaps...)
+	}
+	report.Elapsed = time.Since(start).Round(time.Millisecond).Seconds()
+	report.TTFB = summarize(ttfbs)
+	report.Gap = summarize(gaps)
+	report.Total = summarize(totals)
+	return report
+}
+
+// measure 发起一次请求并读到结束
+func measure(ctx context.Context, hc *http.Client, opts options) (res result) {
+	ctx, cancel := context.WithTimeout(ctx, opts.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	defer func() { res.Total = time.Since(start) }()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, opts.URL, nil)
+	if err != nil {
+		res.Err = err
+		return
+	}
+	if opts.SSE {
+		req.Header.Set("Accept", sse.ContentType)
+	}
+	if opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.Token)
+	}
+	resp, err := hc.Do(req)
+	if err != nil {
+		res.Err = err
+		return
+	}
+	defer resp.Body.Close()
+	res.Status = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		res.Rejected = fmt.Sprintf("http %d", resp.StatusCode)
+		return
+	}
+
+	last := start
+	chunk := func(n int) {
+		now := time.Now()
+		if res.Chunks == 0 {
+			res.TTFB = now.Sub(start)
+		} else {
+			res.Gaps = append(res.Gaps, now.Sub(last))
+		}
+		last = now
+		res.Chunks++
+		res.Bytes += int64(n)
+	}
+
+	if opts.SSE {
+		dec := sse.NewDecoder(resp.Body)
+		for {
+			ev, err := dec.Next()
+			if err != nil {
+				if err != io.EOF {
+					res.Err = err
+				}
+				return
+			}
+			if res.Chunks == 0 && ev.Event == "error" {
+				// SSE 接口以 200 和一条 error 事件拒绝请求（EventSource 收到 429 会放弃重连）
+				res.Rejected = "sse error"
+				return
+			}
+			chunk(len(ev.Data))
+		}
+	}
+
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := resp.Body.Read(buf)
+		if n > 0 {
+			chunk(n)
+		}
+		if err != nil {
+			if err != io.EOF {
+				res.Err = err
+			}
+			return
+		}
+	}
+}
+
+// errorKind 把错误归类，报告中按类别计数而不是逐条列出
+func errorKind(r result) string {
+	switch {
+	case errors.Is(r.Err, context.DeadlineExceeded):
+		return "timeout"
+	case errors.Is(r.Err, context.Canceled):
+		return "canceled"
+	case r.Chunks > 0:
+		return "mid-stream: " + r.Err.Error()
+	}
+	return "connect: " + r.Err.Error()
+}
+
+func printReport(w io.Writer, r Report) {
+	fmt.Fprintf(w, "%s  连接 %d × %d 请求，ramp %s，耗时 %.1fs\n",
+		r.Options.URL, r.Options.Concurrency, r.Options.Requests, r.Ramp, r.Elapsed)
+	fmt.Fprintf(w, "请求 %d，成功 %d，被拒绝 %d，块 %d，字节 %d\n\n", r.Requests, r.Success, count(r.Rejected), r.Chunks, r.Bytes)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(tw, "\tcount\tmin\tmean\tp50\tp90\tp95\tp99\tmax\t")
+	for _, row := range []struct {
+		name string
+		s    Summary
+	}{{"ttfb (ms)", r.TTFB}, {"inter-chunk (ms)", r.Gap}, {"total (ms)", r.Total}} {
+		s := row.s
+		fmt.Fprintf(tw, "%s\t%d\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\t%.1f\t\n",
+			row.name, s.Count, s.Min, s.Mean, s.P50, s.P90, s.P95, s.P99, s.Max)
+	}
+	tw.Flush()
+
+	printCounts(w, "被拒绝（未计入上表）", r.Rejected)
+	printCounts(w, "错误", r.Errors)
+}
+
+// printCounts 按类别打印计数，没有时不输出
+func printCounts(w io.Writer, title string, counts map[string]int) {
+	if len(counts) == 0 {
+		return
+	}
+	kinds := make([]string, 0, len(counts))
+	for k := range counts {
+		kinds = append(kinds, k)
+	}
+	sort.Strings(kinds)
+	fmt.Fprintf(w, "\n%s:\n", title)
+	for _, k := range kinds {
+		fmt.Fprintf(w, "  %5d  %s\n", counts[k], strings.TrimSpace(k))
+	}
+}
+
+// count 返回各类别的计数之和
+func count(counts map[string]int) int {
+	n := 0
+	for _, c := range counts {
+		n += c
+	}
+	return n
+}
+
+func writeReport(path string, r Report) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if path == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"go-learning/advanced/StreamingOutput/sse"
+)
+
+// TestRun 对本地服务器压测：被拒绝的请求单独计数，不进入 TTFB 等统计
+func TestRun(t *testing.T) {
+	var calls atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sw, err := sse.NewWriter(w, r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		switch calls.Add(1) % 3 {
+		case 0:
+			http.Error(w, "slow down", http.StatusTooManyRequests)
+		case 1:
+			sw.Send(sse.Event{Event: "error", Data: `{"code":"too_many_streams"}`})
+		default:
+			for i := 0; i < 3; i++ {
+				sw.Data("token")
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}))
+	defer srv.Close()
+
+	r := run(context.Background(), options{URL: srv.URL, Concurrency: 3, Requests: 3, Timeout: 5 * time.Second, SSE: true})
+	if r.Requests != 9 || r.Success != 3 || r.Rejected["http 429"] != 3 || r.Rejected["sse error"] != 3 || len(r.Errors) != 0 {
+		t.Fatalf("report = %+v", r)
+	}
+	if r.TTFB.Count != 3 || r.Total.Count != 3 || r.Chunks != 9 || r.Gap.Count != 6 {
+		t.Fatalf("stats include rejected requests: ttfb %+v total %+v chunks %d gaps %+v", r.TTFB, r.Total, r.Chunks, r.Gap)
+	}
+}
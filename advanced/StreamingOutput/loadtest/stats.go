@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+)
+
+// Summary 是一组时长的分布，单位为毫秒，方便在 JSON 报告中直接比较
+type Summary struct {
+	Count int     `json:"count"`
+	Min   float64 `json:"min_ms"`
+	Mean  float64 `json:"mean_ms"`
+	P50   float64 `json:"p50_ms"`
+	P90   float64 `json:"p90_ms"`
+	P95   float64 `json:"p95_ms"`
+	P99   float64 `json:"p99_ms"`
+	Max   float64 `json:"max_ms"`
+}
+
+// summarize 计算 ds 的分布，会对 ds 排序
+func summarize(ds []time.Duration) Summary {
+	if len(ds) == 0 {
+		return Summary{}
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	var sum time.Duration
+	for _, d := range ds {
+		sum += d
+	}
+	return Summary{
+		Count: len(ds),
+		Min:   ms(ds[0]),
+		Mean:  ms(sum / time.Duration(len(ds))),
+		P50:   ms(percentile(ds, 50)),
+		P90:   ms(percentile(ds, 90)),
+		P95:   ms(percentile(ds, 95)),
+		P99:   ms(percentile(ds, 99)),
+		Max:   ms(ds[len(ds)-1]),
+	}
+}
+
+// percentile 使用 nearest-rank 方法，sorted 必须已排序且非空
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// ms 把时长转换为保留三位小数的毫秒数
+func ms(d time.Duration) float64 {
+	return math.Round(float64(d)/float64(time.Millisecond)*1000) / 1000
+}
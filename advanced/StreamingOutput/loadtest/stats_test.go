@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSummarize(t *testing.T) {
+	var ds []time.Duration
+	for i := 100; i >= 1; i-- {
+		ds = append(ds, time.Duration(i)*time.Millisecond)
+	}
+	s := summarize(ds)
+	want := Summary{Count: 100, Min: 1, Mean: 50.5, P50: 50, P90: 90, P95: 95, P99: 99, Max: 100}
+	if s != want {
+		t.Fatalf("summarize = %+v, want %+v", s, want)
+	}
+	if got := summarize(nil); got != (Summary{}) {
+		t.Fatalf("empty = %+v", got)
+	}
+	if got := summarize([]time.Duration{1500 * time.Microsecond}); got.P99 != 1.5 || got.P50 != 1.5 {
+		t.Fatalf("single = %+v", got)
+	}
+}
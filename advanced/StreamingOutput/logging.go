@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ============ 结构化日志：log/slog + 请求ID ============
+//
+// 每个请求都有一个请求级别的 logger，自动带上：
+//
+//	request_id  请求ID，优先使用客户端传入的 X-Request-ID，否则随机生成，并通过响应头返回
+//	route       注册的路由
+//	remote      客户端地址
+//	stream_id   流ID（流式路由上每个请求一个；WebSocket 上每次生成一个）
+//
+// 处理器通过 loggerFrom(r.Context()) 取得它，日志就可以按请求过滤和关联。
+//
+// 排查线上单个客户端时，可以让它带上 X-Log-Level: debug 请求头，
+// 只有这个请求的日志级别会被调低，其他请求仍使用 -log-level。
+// 该请求头只对通过认证的请求生效，并且只能调低级别（见 requestedLevel）。
+
+const (
+	requestIDHeader = "X-Request-ID"
+	logLevelHeader  = "X-Log-Level"
+)
+
+type loggerKey struct{}
+
+// leveledHandler 在 slog.Handler 外面套一层自己的级别
+// 底层 handler 以最低级别创建，真正的过滤在这里做，请求级别的 logger 因此可以拥有独立的级别
+type leveledHandler struct {
+	slog.Handler
+	level slog.Leveler
+}
+
+func (h *leveledHandler) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= h.level.Level()
+}
+
+func (h *leveledHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return &leveledHandler{Handler: h.Handler.WithAttrs(attrs), level: h.level}
+}
+
+func (h *leveledHandler) WithGroup(name string) slog.Handler {
+	return &leveledHandler{Handler: h.Handler.WithGroup(name), level: h.level}
+}
+
+// withLevel 返回使用另一个级别的 logger，其他属性保持不变
+func withLevel(l *slog.Logger, level slog.Leveler) *slog.Logger {
+	if h, ok := l.Handler().(*leveledHandler); ok {
+		return slog.New(&leveledHandler{Handler: h.Handler, level: level})
+	}
+	return l
+}
+
+// requestedLevel 按 X-Log-Level 请求头调低 logger 的级别，由 authenticated 在认证通过后调用
+// 只能调低（输出更多日志）：允许调高的话，客户端可以借此隐藏自己请求的日志。
+// 无效的值和不低于当前级别的值直接忽略，也不记录日志
+func requestedLevel(l *slog.Logger, r *http.Request) *slog.Logger {
+	v := r.Header.Get(logLevelHeader)
+	if v == "" {
+		return l
+	}
+	level, err := parseLogLevel(v)
+	h, ok := l.Handler().(*leveledHandler)
+	if err != nil || !ok || level >= h.level.Level() {
+		return l
+	}
+	return withLevel(l, level)
+}
+
+// parseLogLevel 解析 debug / info / warn / error
+func parseLogLevel(s string) (slog.Level, error) {
+	var l slog.Level
+	if err := l.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("unknown log level %q (want debug, info, warn or error)", s)
+	}
+	return l, nil
+}
+
+// newLogger 根据格式（text / json）和级别创建 logger
+func newLogger(w io.Writer, format string, level slog.Leveler) (*slog.Logger, error) {
+	opts := &slog.HandlerOptions{Level: slog.LevelDebug}
+	var h slog.Handler
+	switch format {
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	default:
+		return nil, fmt.Errorf("unknown log format %q (want text or json)", format)
+	}
+	return slog.New(&leveledHandler{Handler: h, level: level}), nil
+}
+
+// setupLogger 按配置替换默认 logger，标准库 log 包的输出也会转到 slog
+func setupLogger() error {
+	level, err := parseLogLevel(cfg.LogLevel)
+	if err != nil {
+		return err
+	}
+	logger, err := newLogger(os.Stderr, cfg.LogFormat, level)
+	if err != nil {
+		return err
+	}
+	slog.SetDefault(logger)
+	return nil
+}
+
+// loggerFrom 返回 ctx 中的请求级别 logger，没有时返回默认 logger
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+	return slog.Default()
+}
+
+// withLogger 把 logger 放进 ctx
+func withLogger(ctx context.Context, l *slog.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// logged 为请求创建带请求ID、路由和客户端地址的 logger
+// 开启 -access-log 时在请求结束后输出一行访问日志
+func logged(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newID(8)
+		}
+		w.Header().Set(requestIDHeader, id)
+
+		logger := slog.Default().With("request_id", id, "route", route, "remote", r.RemoteAddr)
+		if !cfg.AccessLog {
+			h.ServeHTTP(w, r.WithContext(withLogger(r.Context(), logger)))
+			return
+		}
+
+		rw := &responseWriter{ResponseWriter: w}
+		h.ServeHTTP(rw, r.WithContext(withLogger(r.Context(), logger)))
+		accessLog(logger, r, rw, start)
+	})
+}
+
+// accessLog 输出请求的访问日志：状态码、写入的字节数、总耗时和首字节时间
+func accessLog(logger *slog.Logger, r *http.Request, rw *responseWriter, start time.Time) {
+	status := rw.status
+	if status == 0 { // 处理器什么都没写，net/http 会补上 200
+		status = http.StatusOK
+	}
+	attrs := []any{"method", r.Method, "path", r.URL.Path, "status", status, "bytes", rw.bytes,
+		"duration", time.Since(start)}
+	if !rw.firstByte.IsZero() {
+		attrs = append(attrs, "ttfb", rw.firstByte.Sub(start))
+	}
+	logger.Info("请求完成", attrs...)
+}
+
+// withStream 为 ctx 中的 logger 加上流ID
+func withStream(ctx context.Context, id string) context.Context {
+	return withLogger(ctx, loggerFrom(ctx).With("stream_id", id))
+}
+
+// validRequestID 只接受不太长的可打印ASCII，避免日志注入
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	return !strings.ContainsFunc(id, func(c rune) bool { return c <= ' ' || c > '~' })
+}
+
+// newID 生成 n 字节的随机十六进制ID
+func newID(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
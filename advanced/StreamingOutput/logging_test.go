@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-learning/advanced/StreamingOutput/auth"
+)
+
+// TestLoggedRequestScope 请求级别的 logger 带上请求ID，X-Log-Level 只影响当前请求
+// 并且只对通过认证的请求生效、只能调低级别
+func TestLoggedRequestScope(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "json", slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := slog.Default()
+	slog.SetDefault(logger)
+	defer slog.SetDefault(saved)
+	savedCfg := cfg.AccessLog
+	cfg.AccessLog = false // 只看处理器自己的日志，访问日志见 TestAccessLog
+	defer func() { cfg.AccessLog = savedCfg }()
+	keys, _ := auth.ParseKeys([]byte("token-1 alice\n"))
+	withAuth(t, &auth.Authenticator{Keys: keys})
+
+	h := logged("/test", authenticated(registered("/test", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		loggerFrom(r.Context()).Debug("debug line")
+		loggerFrom(r.Context()).Info("info line")
+	}))))
+	serve := func(level string, authorized bool) *httptest.ResponseRecorder {
+		buf.Reset()
+		req := httptest.NewRequest("GET", "/test", nil)
+		req.Header.Set(requestIDHeader, "client-42")
+		if level != "" {
+			req.Header.Set(logLevelHeader, level)
+		}
+		if authorized {
+			req.Header.Set("Authorization", "Bearer token-1")
+		}
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// 默认级别 info，debug 日志被过滤；调高级别不会隐藏 info 日志，无效的值被忽略
+	for _, level := range []string{"", "error", "bogus"} {
+		serve(level, true)
+		if strings.Contains(buf.String(), "debug line") || !strings.Contains(buf.String(), "info line") ||
+			strings.Count(buf.String(), "\n") != 1 {
+			t.Fatalf("X-Log-Level %q: %s", level, buf.String())
+		}
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/test", nil))
+	if id := rec.Header().Get(requestIDHeader); len(id) != 16 {
+		t.Fatalf("generated request id = %q", id)
+	}
+
+	// 未认证的请求不能调低级别
+	serve("debug", false)
+	if strings.Contains(buf.String(), "debug line") {
+		t.Fatalf("unauthenticated X-Log-Level applied: %s", buf.String())
+	}
+
+	// 通过认证的请求带上 X-Log-Level: debug
+	rec = serve("debug", true)
+	var line map[string]any
+	first, _, _ := strings.Cut(buf.String(), "\n")
+	if err := json.Unmarshal([]byte(first), &line); err != nil {
+		t.Fatalf("log line %q: %v", buf.String(), err)
+	}
+	if line["msg"] != "debug line" || line["request_id"] != "client-42" || line["route"] != "/test" ||
+		line["stream_id"] == nil || line["principal"] != "alice" {
+		t.Fatalf("log line = %v", line)
+	}
+	if rec.Header().Get(requestIDHeader) != "client-42" {
+		t.Fatalf("response request id = %q", rec.Header().Get(requestIDHeader))
+	}
+}
+
+func TestValidRequestID(t *testing.T) {
+	for id, want := range map[string]bool{
+		"abc-123":               true,
+		"":                      false,
+		"has space":             false,
+		"new\nline":             false,
+		strings.Repeat("a", 65): false,
+	} {
+		if got := validRequestID(id); got != want {
+			t.Errorf("validRequestID(%q) = %v, want %v", id, got, want)
+		}
+	}
+}
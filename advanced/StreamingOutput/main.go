@@ -1,42 +1,108 @@
 package main
 
 import (
+	"context"
 	_ "embed"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 	"time"
+
+	"go-learning/advanced/StreamingOutput/compression"
+	"go-learning/advanced/StreamingOutput/event"
+	"go-learning/advanced/StreamingOutput/flush"
+	"go-learning/advanced/StreamingOutput/format"
+	"go-learning/advanced/StreamingOutput/generator"
+	"go-learning/advanced/StreamingOutput/utf8chunk"
 )
 
-// 数据模型
-type Message struct {
-	ID      int    `json:"id"`
-	Content string `json:"content"`
-	Time    string `json:"time"`
-}
+// errIncomplete 是处理器没有正常结束流时 Encoder.Finish 的原因（JSON数组的 trailer 为 aborted）
+var errIncomplete = errors.New("stream ended early")
+
+// errResumeUnsupported 表示生成器不是确定性的，或流日志中找不到客户端最后收到的信封，无法按 Last-Event-ID 续传
+var errResumeUnsupported = errors.New("resume unsupported")
 
 //go:embed index.html
 var html string
 
 func main() {
-	// 设置路由
-	http.HandleFunc("/", indexHandler)
-	http.HandleFunc("/stream/sse", sseHandler)
-	http.HandleFunc("/stream/text", textStreamHandler)
-	http.HandleFunc("/stream/json", jsonStreamHandler)
-	http.HandleFunc("/stream/pipeline", pipelineHandler) // 新增：通道解耦示例
+	if err := loadConfig(os.Args[1:]); err != nil {
+		fatal(err)
+	}
+	if err := setupLogger(); err != nil {
+		fatal(err)
+	}
+	if err := setupAuth(); err != nil {
+		fatal(err)
+	}
+	if err := setupGenerators(); err != nil {
+		fatal(err)
+	}
+	if err := setupSessions(); err != nil {
+		fatal(err)
+	}
+	closeEventLog, err := setupEventLog()
+	if err != nil {
+		fatal(err)
+	}
+	defer closeEventLog()
 
 	// 启动服务器
-	fmt.Println("流式输出服务器启动在 http://localhost:8080")
+	host := cfg.Addr
+	if strings.HasPrefix(host, ":") {
+		host = "localhost" + host
+	}
+	fmt.Println("流式输出服务器启动在 http://" + host)
 	fmt.Println("可用的端点:")
-	fmt.Println("  - http://localhost:8080/ (主页)")
-	fmt.Println("  - http://localhost:8080/stream/sse (SSE流式输出)")
-	fmt.Println("  - http://localhost:8080/stream/text (文本流式输出)")
-	fmt.Println("  - http://localhost:8080/stream/json (JSON流式输出)")
-	fmt.Println("  - http://localhost:8080/stream/pipeline (通道解耦示例)")
+	fmt.Println("  - http://" + host + "/ (主页)")
+	fmt.Println("  - http://" + host + "/stream/sse (SSE流式输出)")
+	fmt.Println("  - http://" + host + "/stream/text (文本流式输出)")
+	fmt.Println("  - http://" + host + "/stream/json (JSON流式输出)")
+	fmt.Println("  - http://"+host+"/stream/pipeline (通道解耦示例，?generator= 可选:", strings.Join(generators.Names(), "/"), "，?format= 可选: text/sse/ndjson/json)")
+	fmt.Println("  - ws://" + host + "/stream/ws (WebSocket双向流，可发送 stop / 新提示词)")
+	fmt.Println("  - http://" + host + "/stream/broadcast (广播：多个SSE订阅者共享一个生产者)")
+	fmt.Println("  - http://" + host + "/publish/{topic} (发布消息，POST)")
+	fmt.Println("  - http://" + host + "/subscribe/{topic} (SSE订阅，支持 * 和 ** 通配符)")
+	fmt.Println("  - http://" + host + "/v1/chat/completions (OpenAI 兼容接口，POST)")
+	fmt.Println("  - http://" + host + "/streams (正在进行的流，DELETE /streams/{id} 停止)")
+	fmt.Println("  - http://" + host + "/sessions/{id} (按原始时序回放录制的会话，需要 -record-dir)")
+	fmt.Println("  - http://" + host + "/config (当前生效的配置)")
+	fmt.Println("  - http://" + host + "/metrics (Prometheus 指标)")
+	fmt.Println("  - http://" + host + "/auth/sign (用 Bearer Token 签发签名URL，POST)")
+
+	if err := serve(newMux()); err != nil {
+		closeEventLog()
+		fatal(err)
+	}
+}
+
+// fatal 记录错误并退出
+func fatal(err error) {
+	slog.Error("启动失败", "err", err)
+	os.Exit(1)
+}
 
-	log.Fatal(http.ListenAndServe(":8080", nil))
+// compressed 为路由添加逐块刷新的压缩，-compress=false 或路由在 -compress-exclude 中时原样返回
+func compressed(route string, h http.Handler) http.Handler {
+	if !cfg.Compress || inList(cfg.CompressExclude, route) {
+		return h
+	}
+	return compression.Handler(h, compression.Options{MinSize: cfg.CompressMinSize})
+}
+
+// inList 判断 s 是否在逗号分隔的列表中
+func inList(list, s string) bool {
+	for _, item := range strings.Split(list, ",") {
+		if strings.TrimSpace(item) == s {
+			return true
+		}
+	}
+	return false
 }
 
 // 主页处理器
@@ -47,67 +113,112 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 // SSE (Server-Sent Events) 流式输出处理器
+// 默认输出SSE，也可以通过 ?format= 或 Accept 选择 ndjson / json / text
 func sseHandler(w http.ResponseWriter, r *http.Request) {
-	// 1. 设置SSE响应头
-	w.Header().Set("Content-Type", "text/event-stream")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-
-	// 2. 获取Flusher接口
-	flusher, ok := w.(http.Flusher)
-	if !ok {
+	sseStream{count: cfg.SSECount, delay: cfg.SSEDelay}.serve(w, r)
+}
+
+// sseStream 是 /stream/sse 模拟的数据流：count 条消息，每条间隔 delay
+type sseStream struct {
+	count int
+	delay time.Duration
+}
+
+// serve 向客户端发送 s 描述的数据流
+func (s sseStream) serve(w http.ResponseWriter, r *http.Request) {
+	// 1. 协商格式并创建编码器（内部设置响应头）
+	f, err := format.Negotiate(r, format.SSE)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+		return
+	}
+	enc, err := newEncoder(w, r, f, format.Options{})
+	if err != nil {
 		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
 		return
 	}
+	defer enc.Finish(errIncomplete)
 
 	ctx := r.Context()
-	// 3. 发送初始数据
-	fmt.Fprintf(w, "data: %s\n\n", "SSE连接已建立")
-	flusher.Flush()
+	// 2. 断线重连时从 Last-Event-ID 的下一条继续，第 i 条消息的序号就是 i
+	after := resumeFrom(r)
+	if after > 0 {
+		loggerFrom(ctx).Info("SSE客户端重连，继续发送", "from", after+1, "total", s.count)
+	}
+	st := newEventStream(ctx, after)
+
+	// 3. 心跳表示连接已建立（同时建议客户端3秒后重连）
+	hello := record(st.Heartbeat())
+	hello.Retry = 3 * time.Second
+	encode(enc, hello)
+
+	// 只有SSE客户端能带着 Last-Event-ID 重连续传，关闭时通知它们；其他格式继续输出直到完成
+	var drain <-chan struct{}
+	if f == format.SSE {
+		drain = draining()
+	}
 
 	// 4. 模拟数据流
-	for i := 1; i <= 10; i++ {
+	for i := after + 1; i <= s.count; i++ {
 		select {
 		case <-ctx.Done():
-			log.Printf("[SSE] ⚠️ 客户端断开连接（Context取消，在第 %d/10 条消息时）", i)
+			if reason, ok := stopReason(ctx); ok {
+				encode(enc, stoppedRecords(st, reason)...)
+				enc.Finish(context.Cause(ctx))
+				loggerFrom(ctx).Info("流被停止", "at", i, "reason", reason)
+				return
+			}
+			loggerFrom(ctx).Warn("客户端断开连接", "at", i, "total", s.count)
+			return
+		case <-drain:
+			encode(enc, shutdownRecords(st)...)
+			enc.Finish(errShuttingDown)
+			loggerFrom(ctx).Info("服务器关闭，通知客户端稍后重连", "resume_from", i)
 			return
 		default:
 		}
-		message := Message{
-			ID:      i,
-			Content: fmt.Sprintf("这是第 %d 条SSE消息", i),
-			Time:    time.Now().Format("15:04:05"),
-		}
 
-		// 将消息转换为JSON
-		jsonData, _ := json.Marshal(message)
-
-		// 发送SSE格式的数据
-		fmt.Fprintf(w, "data: %s\n\n", string(jsonData))
-		flusher.Flush()
+		// 发送消息，序号用于断线续传
+		rec := record(st.Delta(fmt.Sprintf("这是第 %d 条SSE消息", i)))
+		rec.Text += "\n"
+		if err := encode(enc, rec); err != nil {
+			return
+		}
 
 		// 模拟处理延迟
-		time.Sleep(1 * time.Second)
+		time.Sleep(s.delay)
 	}
 
-	// 5. 发送结束信号
-	fmt.Fprintf(w, "data: [DONE]\n\n")
-	flusher.Flush()
+	// 5. 以 done 和 usage 结束
+	encode(enc, endRecords(st, event.ReasonComplete, "")...)
+	enc.Finish(nil)
+}
+
+// resumeFrom 解析 Last-Event-ID，返回客户端已收到的最后一条序号，首次连接返回0
+func resumeFrom(r *http.Request) int {
+	n, err := strconv.Atoi(r.Header.Get("Last-Event-ID"))
+	if err != nil || n < 0 {
+		return 0
+	}
+	return n
 }
 
 // 文本流式输出处理器
 func textStreamHandler(w http.ResponseWriter, r *http.Request) {
-	// 1. 设置文本流响应头
+	// 1. 设置文本流响应头（Cache-Control 等由 streaming 中间件统一设置）
 	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
 
-	// 2. 获取Flusher接口
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
-		return
+	// 2. 中间件已确认可以刷新；ResponseController 会穿过各层包装找到 Flusher
+	rc := http.NewResponseController(w)
+
+	// ?chunk=N 把文本按 N 个字节切开发送，模拟按字节输出的生产者；
+	// 经过 utf8chunk.Writer 后客户端收到的每一块仍是完整的字符
+	chunkSize, _ := strconv.Atoi(r.URL.Query().Get("chunk"))
+	var out io.Writer = w
+	if chunkSize > 0 {
+		cw := utf8chunk.NewWriter(w, utf8chunk.Options{Graphemes: true})
+		defer cw.Close()
+		out = cw
 	}
 
 	ctx := r.Context()
@@ -120,6 +231,7 @@ func textStreamHandler(w http.ResponseWriter, r *http.Request) {
 		"数据1: 处理完成\n",
 		"数据2: 处理完成\n",
 		"数据3: 处理完成\n",
+		"表情: 👍🏽 🇨🇳 👨‍👩‍👧\n",
 		"所有数据处理完毕\n",
 		"文本流输出结束\n",
 	}
@@ -127,173 +239,285 @@ func textStreamHandler(w http.ResponseWriter, r *http.Request) {
 	for i, chunk := range textChunks {
 		select {
 		case <-ctx.Done():
-			log.Printf("[Text] ⚠️ 客户端断开连接（Context取消，在第 %d/%d 块时）", i+1, len(textChunks))
+			if reason, ok := stopReason(ctx); ok {
+				fmt.Fprintf(out, "\n=== 已停止: %s ===\n", reason)
+				rc.Flush()
+				loggerFrom(ctx).Info("流被停止", "at", i+1, "reason", reason)
+				return
+			}
+			loggerFrom(ctx).Warn("客户端断开连接", "at", i+1, "total", len(textChunks))
 			return
 		default:
 		}
-		fmt.Fprint(w, chunk)
-		flusher.Flush()
-		time.Sleep(500 * time.Millisecond)
+		for _, part := range splitBytes(chunk, chunkSize) {
+			fmt.Fprint(out, part)
+			rc.Flush()
+		}
+		time.Sleep(cfg.TextDelay)
+	}
+}
+
+// splitBytes 把 s 按 n 个字节切开，可能切在字符中间；n <= 0 时不切分
+func splitBytes(s string, n int) []string {
+	if n <= 0 {
+		return []string{s}
+	}
+	var parts []string
+	for len(s) > n {
+		parts = append(parts, s[:n])
+		s = s[n:]
 	}
+	return append(parts, s)
 }
 
 // JSON流式输出处理器
+// 默认输出信封的JSON数组；请求 text/event-stream 时每个信封作为一条SSE事件发送（支持 Last-Event-ID 续传），
+// 也可以通过 ?format= 选择 ndjson / text
 func jsonStreamHandler(w http.ResponseWriter, r *http.Request) {
-	// 1. 设置JSON流响应头
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-
-	// 2. 获取Flusher接口
-	flusher, ok := w.(http.Flusher)
-	if !ok {
+	// 1. 协商格式并创建编码器（JSON数组会声明 trailer）
+	f, err := format.Negotiate(r, format.JSON)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
+		return
+	}
+	enc, err := newEncoder(w, r, f, format.Options{})
+	if err != nil {
 		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
 		return
 	}
+	defer enc.Finish(errIncomplete)
 
+	// 2. 模拟JSON数据流，每个信封写完立即刷新
 	ctx := r.Context()
-	// 3. 开始JSON数组
-	fmt.Fprint(w, "[\n")
-	flusher.Flush()
-
-	// 4. 模拟JSON数据流
-	for i := 1; i <= 5; i++ {
+	after := resumeFrom(r)
+	st := newEventStream(ctx, after)
+	// 与 /stream/pipeline 相同，关闭时只中止能续传的SSE流，JSON数组等继续输出直到完成
+	var drain <-chan struct{}
+	if f == format.SSE {
+		drain = draining()
+	}
+	for i := after + 1; i <= cfg.JSONCount; i++ {
 		select {
 		case <-ctx.Done():
-			log.Printf("[JSON] ⚠️ 客户端断开连接（Context取消，在第 %d/5 条消息时）", i)
+			if reason, ok := stopReason(ctx); ok {
+				// 在服务端被停止：连接仍然可用，JSON数组以 aborted 状态正确结束
+				encode(enc, stoppedRecords(st, reason)...)
+				enc.Finish(context.Cause(ctx))
+				loggerFrom(ctx).Info("流被停止", "at", i, "reason", reason)
+				return
+			}
+			// 连接已断开，写不出任何东西，客户端收不到 complete 状态的 trailer 即可判断被截断
+			loggerFrom(ctx).Warn("客户端断开连接", "at", i, "total", cfg.JSONCount)
+			return
+		case <-drain:
+			// 服务器主动中止：SSE客户端稍后重连续传
+			encode(enc, shutdownRecords(st)...)
+			enc.Finish(errShuttingDown)
 			return
 		default:
 		}
-		message := Message{
-			ID:      i,
-			Content: fmt.Sprintf("JSON流消息 %d", i),
-			Time:    time.Now().Format("15:04:05"),
-		}
-
-		jsonData, _ := json.MarshalIndent(message, "  ", "  ")
-
-		// 添加逗号（除了第一个元素）
-		if i > 1 {
-			fmt.Fprint(w, ",\n")
+		rec := record(st.Delta(fmt.Sprintf("JSON流消息 %d", i)))
+		rec.Text += "\n"
+		if err := encode(enc, rec); err != nil {
+			return
 		}
-
-		fmt.Fprintf(w, "  %s", string(jsonData))
-		flusher.Flush()
-
-		time.Sleep(1 * time.Second)
+		time.Sleep(cfg.JSONDelay)
 	}
 
-	// 5. 结束JSON数组
-	fmt.Fprint(w, "\n]")
-	flusher.Flush()
+	// 3. 结束：以 done 和 usage 结束，JSON数组的 trailer 中带上 complete、元素个数和校验和
+	encode(enc, endRecords(st, event.ReasonComplete, "")...)
+	enc.Finish(nil)
 }
 
 // ============ 通道解耦：生产与传输分离示例 ============
 
-// generateWithPipeline 模拟大模型逐token生成
-// 💡 关键点：返回只读通道 (<-chan string)，调用者只能接收数据
-func generateWithPipeline(prompt string) <-chan string {
-	ch := make(chan string, 5) // 带缓冲的通道，生产者不会因为消费者慢而阻塞
-
-	// 在独立的 goroutine 中生成数据（生产者）
-	go func() {
-		defer close(ch) // 确保生成完成后关闭通道
-		log.Printf("[Pipeline-生产者] 开始生成，提示词: %s", prompt)
-
-		// 模拟大模型逐token生成（如 OpenAI/Claude streaming API）
-		tokens := []string{
-			"你好", "！", "我", "是", "AI", "助手", "。\n",
-			"根据", "你的", "提示", "「", prompt, "」", "，\n",
-			"我", "将", "逐步", "生成", "回答", "内容", "。\n",
-			"这", "展示", "了", "通道", "解耦", "的", "威力", "！",
-		}
-
-		for i, token := range tokens {
-			// 模拟大模型API的延迟（生成延迟）
-			time.Sleep(100 * time.Millisecond)
-
-			// 发送到通道
-			ch <- token
-			log.Printf("[Pipeline-生产者] ✓ 生成token %d/%d: %q", i+1, len(tokens), token)
-		}
-
-		log.Printf("[Pipeline-生产者] ✓ 生成完成，通道已关闭")
-	}()
-
-	return ch // 立即返回通道，不等待生成完成
-}
-
 // pipelineHandler 演示通道解耦的流式输出处理器
+// 同一份token流按 ?format= 或 Accept 输出为纯文本（默认）、SSE、NDJSON 或JSON数组；
+// EventSource 会发送 Accept: text/event-stream，每个token一条事件，id 是token的序号。
+// 启用事件日志时SSE流写入流日志，任何生成器都可以从日志续传（见 streamlog.go）；
+// 否则续传靠重新生成并跳过已发送的token，只对确定性的生成器（echo、replay）成立，
+// 其他生成器收到 Last-Event-ID 时返回 resume_unsupported 错误并结束
 func pipelineHandler(w http.ResponseWriter, r *http.Request) {
-	// 1. 设置响应头
-	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
-	w.Header().Set("Cache-Control", "no-cache")
-	w.Header().Set("Connection", "keep-alive")
-
-	// 2. 获取 Flusher 接口
-	flusher, ok := w.(http.Flusher)
-	if !ok {
-		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+	// 1. 选择输出格式
+	f, err := format.Negotiate(r, format.Text)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotAcceptable)
 		return
 	}
 
-	// 3. 获取查询参数作为提示词
-	prompt := r.URL.Query().Get("prompt")
-	if prompt == "" {
-		prompt = "通道解耦示例"
+	// 2. 获取查询参数：提示词、生成器名称和刷新策略
+	prompt, gen, err := pipelineParams(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	policy, err := flushPolicy(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	ctx := r.Context()
-	log.Printf("[Pipeline-消费者] 客户端连接: %s, 提示词: %s", r.RemoteAddr, prompt)
-
-	// 4. 启动生产者（立即返回通道）
-	tokenCh := generateWithPipeline(prompt)
+	// 3. 按格式分帧、按策略合并刷新，而不是每个token都 Flush 一次；
+	// 纯文本保证每次写出的都是完整字符，?graphemes=1 时连同 emoji 修饰符等组成的字素簇一起保证完整
+	enc, err := newEncoder(w, r, f, format.Options{Flush: policy, Graphemes: r.URL.Query().Get("graphemes") == "1"})
+	if err != nil {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	defer enc.Finish(errIncomplete)
+
+	// 消费者无论因何返回，都通过 cancel 通知生产者停止
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	skip := resumeFrom(r)
+	logger := loggerFrom(ctx)
+	logger.Info("客户端连接", "prompt", prompt, "format", f.String(), "flush", policy.String(), "resume_after", skip)
+	streamFrom(ctx).setPrompt(prompt)
+
+	// 启用事件日志时SSE流写入流日志，带着 <流ID>@<日志序号> 重连的客户端从日志续传（见 streamlog.go）
+	j := newJournal(r, f)
+	lost := false
+	if from, since, ok := parseDurableID(r.Header.Get("Last-Event-ID")); ok && j != nil {
+		last, complete, err := j.resume(ctx, enc, from, since)
+		switch {
+		case complete:
+			enc.Finish(nil)
+			logger.Info("从流日志续传完成", "from_stream", from)
+			return
+		case errors.Is(err, errShuttingDown):
+			encode(enc, shutdownRecords(newEventStream(ctx, int(last)))...)
+			enc.Finish(err)
+			return
+		case err == nil:
+			// 原来的流没有结束也不再生成，接着日志中最后一条 delta 重新生成
+			skip = int(last)
+			logger.Info("流日志中的流未结束，按序号续传", "from_stream", from, "resume_after", skip)
+		case errors.Is(err, errResumeUnsupported):
+			lost = true // 不知道客户端收到了多少，无法续传
+		default:
+			logger.Warn("从流日志续传失败", "from_stream", from, "err", err)
+			return
+		}
+	}
 
-	fmt.Fprintf(w, "=== 通道解耦流式输出示例 ===\n")
-	fmt.Fprintf(w, "提示词: %s\n", prompt)
-	fmt.Fprintf(w, "开始接收生成的token...\n\n")
-	flusher.Flush()
+	st := newEventStream(ctx, skip)
+	if lost || (skip > 0 && !generator.IsDeterministic(gen)) {
+		// 重新生成的内容与已发送的不同，跳过前 skip 个token会拼出错误的回答
+		unsupported := record(st.Error("resume_unsupported", "该生成器不支持续传，请不带 Last-Event-ID 重新请求", 0))
+		unsupported.Text = ""
+		encode(enc, unsupported)
+		encode(enc, endRecords(st, event.ReasonError, "resume unsupported")...)
+		enc.Finish(errResumeUnsupported)
+		logger.Warn("生成器不支持续传", "resume_after", skip)
+		return
+	}
+	// 4. 启动生产者（立即返回通道）；写流日志时客户端断开后它继续运行
+	genCtx, cancelGen := j.detach(ctx)
+	defer cancelGen()
+	pipe := generateWithPipeline(genCtx, gen, prompt, generator.Options{})
+
+	// 第一条心跳告诉客户端流ID，可用于 DELETE /streams/{id}；心跳不带 id，不影响 Last-Event-ID
+	hello := record(st.Heartbeat())
+	hello.Text = fmt.Sprintf("=== 通道解耦流式输出示例 ===\n流ID: %s（DELETE /streams/%s 可停止）\n提示词: %s\n开始接收生成的token...\n\n",
+		st.ID(), st.ID(), prompt)
+	encode(enc, hello)
+
+	// 只有SSE客户端能带着 Last-Event-ID 重连续传，关闭时通知它们；其他格式继续输出直到完成
+	var drain <-chan struct{}
+	if f == format.SSE {
+		drain = draining()
+	}
 
 	// 5. 消费者：从通道读取并传输（传输过程）
 	tokenCount := 0
+	tokens := pipe.Tokens()
 	for {
 		select {
 		case <-ctx.Done():
+			if reason, ok := stopReason(ctx); ok {
+				encode(enc, j.end(st, event.ReasonStopped, reason)...)
+				enc.Finish(context.Cause(ctx))
+				logger.Info("流被停止", "tokens", tokenCount, "reason", reason)
+				return
+			}
 			// 客户端断开连接
-			log.Printf("[Pipeline-消费者] ⚠️ 客户端断开连接（已接收 %d 个token）", tokenCount)
+			logger.Warn("客户端断开连接", "tokens", tokenCount)
+			if j != nil {
+				j.rest(genCtx, st, pipe, skip-tokenCount)
+			}
+			return
+
+		case <-drain:
+			// 写流日志时客户端重连后从日志续传，否则跳过已收到的token重新生成（仅限确定性的生成器）；
+			// 关闭通知不写入日志，也不占用流的序号，日志中的流接着生成
+			notice := *st
+			encode(enc, shutdownRecords(&notice)...)
+			enc.Finish(errShuttingDown)
+			if j != nil {
+				j.rest(genCtx, st, pipe, skip-tokenCount)
+			}
 			return
 
-		case token, ok := <-tokenCh:
+		case token, ok := <-tokens:
 			if !ok {
+				if ctx.Err() != nil {
+					tokens = nil // 生产者因取消而停止，交给 ctx.Done 分支处理
+					continue
+				}
+				if err := pipe.Err(); err != nil {
+					failed := j.record(st.Error("generation_failed", err.Error(), 0))
+					failed.Text = "" // text 格式由 done 说明失败原因
+					encode(enc, failed)
+					encode(enc, j.end(st, event.ReasonError, err.Error())...)
+					enc.Finish(err)
+					logger.Warn("生产者提前停止", "err", err, "tokens", tokenCount)
+					return
+				}
 				// 通道已关闭，生产者完成
-				fmt.Fprintf(w, "\n\n=== 生成完成 ===\n")
-				fmt.Fprintf(w, "共接收到 %d 个token\n", tokenCount)
-				flusher.Flush()
-				log.Printf("[Pipeline-消费者] ✓ 传输完成，共发送 %d 个token", tokenCount)
+				encode(enc, j.end(st, event.ReasonComplete, "")...)
+				enc.Finish(nil)
+				logger.Info("传输完成", "tokens", tokenCount)
 				return
 			}
 
-			// 发送token给客户端
 			tokenCount++
-			fmt.Fprint(w, token)
-			flusher.Flush()
-			log.Printf("[Pipeline-消费者] → 发送token %d: %q", tokenCount, token)
+			if tokenCount <= skip {
+				continue // 重连前已经发送过
+			}
+			// 发送token给客户端，何时真正送达由刷新策略决定
+			if err := encode(enc, j.record(st.Delta(token))); err != nil {
+				logger.Warn("发送失败", "err", err, "tokens", tokenCount)
+				if j != nil {
+					j.rest(genCtx, st, pipe, 0)
+				}
+				return
+			}
+			sentToken(ctx, "/stream/pipeline")
+			logger.Debug("发送token", "seq", tokenCount, "token", token)
 
 			// 模拟网络传输延迟（可选）
 			// 注意：即使这里延迟，也不会阻塞生产者的生成
-			time.Sleep(50 * time.Millisecond)
+			time.Sleep(cfg.SendDelay)
 		}
 	}
 }
 
+// flushPolicy 返回 ?flush= 指定的刷新策略，未指定时使用配置的默认策略
+func flushPolicy(r *http.Request) (flush.Policy, error) {
+	if s := r.URL.Query().Get("flush"); s != "" {
+		return flush.ParsePolicy(s)
+	}
+	return flush.ParsePolicy(cfg.FlushPolicy) // 已在 Validate 中校验
+}
+
 // ============ 对比：无通道解耦的传统方式 ============
-// 
+//
 // 传统方式的问题：
 // func traditionalHandler(w http.ResponseWriter, r *http.Request) {
 //     for i := 0; i < 10; i++ {
 //         token := generateToken()        // 生成（阻塞）
 //         fmt.Fprint(w, token)           // 传输（阻塞）
-//         flusher.Flush()
+//         rc.Flush()
 //         // 问题：生成完一个才能传输一个，串行执行
 //     }
 // }
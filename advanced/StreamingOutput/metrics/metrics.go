@@ -0,0 +1,375 @@
+// Package metrics 实现一个不依赖第三方库的 Prometheus 文本格式指标集
+//
+// 支持 counter、gauge、histogram 三种类型，都可以带标签：
+//
+//	reg := metrics.NewRegistry()
+//	tokens := reg.Counter("tokens_sent_total", "已发送的token数", "route")
+//	tokens.With("/stream/pipeline").Inc()
+//	http.Handle("/metrics", reg)
+//
+// 输出遵循 text exposition format 0.0.4：每个指标族先输出 # HELP 和 # TYPE，
+// 同一指标族内的样本按标签值排序，输出结果是确定的，方便测试。
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ContentType 是 Prometheus 文本格式的 Content-Type
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefBuckets 是以秒为单位的默认直方图桶，覆盖 1ms 到 10s
+var DefBuckets = []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry 保存所有指标族，实现 http.Handler 输出 /metrics
+type Registry struct {
+	mu       sync.Mutex
+	families []family
+	names    map[string]bool
+}
+
+// family 是一个指标族（同名、不同标签的一组样本）
+type family interface {
+	write(w io.Writer)
+}
+
+// NewRegistry 创建空的 Registry
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (r *Registry) register(name string, f family) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[name] {
+		panic("metrics: duplicate metric " + name)
+	}
+	r.names[name] = true
+	r.families = append(r.families, f)
+}
+
+// WriteTo 以文本格式输出全部指标，按注册顺序
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	families := append([]family(nil), r.families...)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	for _, f := range families {
+		f.write(cw)
+	}
+	return cw.n, cw.err
+}
+
+// ServeHTTP 实现 http.Handler
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", ContentType)
+	r.WriteTo(w)
+}
+
+// ============ 带标签的样本集合 ============
+
+// vec 按标签值保存样本，labels 为空时只有一个样本
+type vec[T any] struct {
+	name, help, typ string
+	labels          []string
+	newSample       func() T
+
+	mu      sync.RWMutex
+	samples map[string]T
+	values  map[string][]string
+}
+
+func newVec[T any](name, help, typ string, labels []string, newSample func() T) *vec[T] {
+	return &vec[T]{
+		name: name, help: help, typ: typ, labels: labels, newSample: newSample,
+		samples: make(map[string]T),
+		values:  make(map[string][]string),
+	}
+}
+
+// with 返回标签值对应的样本，不存在时创建
+func (v *vec[T]) with(values []string) T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s expects %d label values, got %d", v.name, len(v.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	v.mu.RLock()
+	s, ok := v.samples[key]
+	v.mu.RUnlock()
+	if ok {
+		return s
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok := v.samples[key]; ok {
+		return s
+	}
+	s = v.newSample()
+	v.samples[key] = s
+	v.values[key] = append([]string(nil), values...)
+	return s
+}
+
+// each 按标签值排序遍历样本
+func (v *vec[T]) each(fn func(labels string, s T)) {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.samples))
+	for k := range v.samples {
+		keys = append(keys, k)
+	}
+	v.mu.RUnlock()
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v.mu.RLock()
+		s, values := v.samples[k], v.values[k]
+		v.mu.RUnlock()
+		fn(formatLabels(v.labels, values), s)
+	}
+}
+
+func (v *vec[T]) header(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", v.name, escapeHelp(v.help), v.name, v.typ)
+}
+
+// ============ Counter ============
+
+// Counter 是只增不减的计数器
+type Counter struct{ bits atomic.Uint64 }
+
+// Inc 加 1
+func (c *Counter) Inc() { c.Add(1) }
+
+// Add 增加 n，n 不能为负
+func (c *Counter) Add(n float64) {
+	if n < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	addFloat(&c.bits, n)
+}
+
+// Value 返回当前值
+func (c *Counter) Value() float64 { return math.Float64frombits(c.bits.Load()) }
+
+// CounterVec 是一组按标签区分的计数器
+type CounterVec struct{ v *vec[*Counter] }
+
+// Counter 注册计数器指标族
+func (r *Registry) Counter(name, help string, labels ...string) *CounterVec {
+	cv := &CounterVec{newVec(name, help, "counter", labels, func() *Counter { return new(Counter) })}
+	r.register(name, cv)
+	return cv
+}
+
+// With 返回标签值对应的计数器
+func (cv *CounterVec) With(values ...string) *Counter { return cv.v.with(values) }
+
+func (cv *CounterVec) write(w io.Writer) {
+	cv.v.header(w)
+	cv.v.each(func(labels string, c *Counter) {
+		fmt.Fprintf(w, "%s%s %s\n", cv.v.name, labels, formatFloat(c.Value()))
+	})
+}
+
+// ============ Gauge ============
+
+// Gauge 是可增可减的数值
+type Gauge struct{ bits atomic.Uint64 }
+
+// Set 设置为 n
+func (g *Gauge) Set(n float64) { g.bits.Store(math.Float64bits(n)) }
+
+// Inc 加 1
+func (g *Gauge) Inc() { addFloat(&g.bits, 1) }
+
+// Dec 减 1
+func (g *Gauge) Dec() { addFloat(&g.bits, -1) }
+
+// Add 增加 n，n 可以为负
+func (g *Gauge) Add(n float64) { addFloat(&g.bits, n) }
+
+// Value 返回当前值
+func (g *Gauge) Value() float64 { return math.Float64frombits(g.bits.Load()) }
+
+// GaugeVec 是一组按标签区分的 Gauge
+type GaugeVec struct{ v *vec[*Gauge] }
+
+// Gauge 注册 gauge 指标族
+func (r *Registry) Gauge(name, help string, labels ...string) *GaugeVec {
+	gv := &GaugeVec{newVec(name, help, "gauge", labels, func() *Gauge { return new(Gauge) })}
+	r.register(name, gv)
+	return gv
+}
+
+// With 返回标签值对应的 Gauge
+func (gv *GaugeVec) With(values ...string) *Gauge { return gv.v.with(values) }
+
+func (gv *GaugeVec) write(w io.Writer) {
+	gv.v.header(w)
+	gv.v.each(func(labels string, g *Gauge) {
+		fmt.Fprintf(w, "%s%s %s\n", gv.v.name, labels, formatFloat(g.Value()))
+	})
+}
+
+// gaugeFunc 在每次输出时调用函数取值
+type gaugeFunc struct {
+	name, help string
+	fn         func() float64
+}
+
+// GaugeFunc 注册一个无标签的 gauge，值在每次抓取时由 fn 计算
+func (r *Registry) GaugeFunc(name, help string, fn func() float64) {
+	r.register(name, &gaugeFunc{name: name, help: help, fn: fn})
+}
+
+func (g *gaugeFunc) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s gauge\n%s %s\n", g.name, escapeHelp(g.help), g.name, g.name, formatFloat(g.fn()))
+}
+
+// ============ Histogram ============
+
+// Histogram 统计观测值的分布，桶是累积的（le 表示小于等于）
+type Histogram struct {
+	upper  []float64
+	counts []atomic.Uint64 // 每个桶（不累积），最后一个是 +Inf
+	sum    atomic.Uint64
+	count  atomic.Uint64
+}
+
+// Observe 记录一个观测值
+func (h *Histogram) Observe(v float64) {
+	i := sort.SearchFloat64s(h.upper, v)
+	h.counts[i].Add(1)
+	addFloat(&h.sum, v)
+	h.count.Add(1)
+}
+
+// ObserveDuration 以秒为单位记录时长
+func (h *Histogram) ObserveDuration(d time.Duration) { h.Observe(d.Seconds()) }
+
+// Count 返回观测次数
+func (h *Histogram) Count() uint64 { return h.count.Load() }
+
+// HistogramVec 是一组按标签区分的直方图
+type HistogramVec struct {
+	v *vec[*Histogram]
+}
+
+// Histogram 注册直方图指标族，buckets 为空时使用 DefBuckets
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	upper := append([]float64(nil), buckets...)
+	sort.Float64s(upper)
+	hv := &HistogramVec{newVec(name, help, "histogram", labels, func() *Histogram {
+		return &Histogram{upper: upper, counts: make([]atomic.Uint64, len(upper)+1)}
+	})}
+	r.register(name, hv)
+	return hv
+}
+
+// With 返回标签值对应的直方图
+func (hv *HistogramVec) With(values ...string) *Histogram { return hv.v.with(values) }
+
+func (hv *HistogramVec) write(w io.Writer) {
+	hv.v.header(w)
+	name := hv.v.name
+	hv.v.each(func(labels string, h *Histogram) {
+		var cum uint64
+		for i, le := range h.upper {
+			cum += h.counts[i].Load()
+			fmt.Fprintf(w, "%s_bucket%s %d\n", name, withLabel(labels, "le", formatFloat(le)), cum)
+		}
+		cum += h.counts[len(h.upper)].Load()
+		fmt.Fprintf(w, "%s_bucket%s %d\n", name, withLabel(labels, "le", "+Inf"), cum)
+		fmt.Fprintf(w, "%s_sum%s %s\n", name, labels, formatFloat(math.Float64frombits(h.sum.Load())))
+		fmt.Fprintf(w, "%s_count%s %d\n", name, labels, cum)
+	})
+}
+
+// ============ 工具函数 ============
+
+// addFloat 以 CAS 循环原子地累加 float64
+func addFloat(bits *atomic.Uint64, n float64) {
+	for {
+		old := bits.Load()
+		if bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+n)) {
+			return
+		}
+	}
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// formatLabels 输出 {a="1",b="2"}，没有标签时为空字符串
+func formatLabels(names, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, n := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, n, escapeLabel(values[i]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// withLabel 在已格式化的标签后追加一个标签（直方图的 le）
+func withLabel(labels, name, value string) string {
+	extra := fmt.Sprintf(`%s="%s"`, name, value)
+	if labels == "" {
+		return "{" + extra + "}"
+	}
+	return labels[:len(labels)-1] + "," + extra + "}"
+}
+
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
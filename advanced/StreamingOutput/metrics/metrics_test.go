@@ -0,0 +1,78 @@
+package metrics
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestExposition(t *testing.T) {
+	reg := NewRegistry()
+	tokens := reg.Counter("tokens_sent_total", "已发送的token数", "route")
+	active := reg.Gauge("active_streams", "当前流数量", "route")
+	ttfb := reg.Histogram("ttfb_seconds", "首字节时间", []float64{0.1, 0.5}, "route")
+	reg.GaugeFunc("queue_depth", "队列深度", func() float64 { return 3 })
+
+	tokens.With("/b").Add(2)
+	tokens.With(`/a"x`).Inc()
+	active.With("/a").Inc()
+	active.With("/a").Inc()
+	active.With("/a").Dec()
+	ttfb.With("/a").Observe(0.1)
+	ttfb.With("/a").Observe(0.3)
+	ttfb.With("/a").Observe(2)
+
+	var b strings.Builder
+	reg.WriteTo(&b)
+	want := `# HELP tokens_sent_total 已发送的token数
+# TYPE tokens_sent_total counter
+tokens_sent_total{route="/a\"x"} 1
+tokens_sent_total{route="/b"} 2
+# HELP active_streams 当前流数量
+# TYPE active_streams gauge
+active_streams{route="/a"} 1
+# HELP ttfb_seconds 首字节时间
+# TYPE ttfb_seconds histogram
+ttfb_seconds_bucket{route="/a",le="0.1"} 1
+ttfb_seconds_bucket{route="/a",le="0.5"} 2
+ttfb_seconds_bucket{route="/a",le="+Inf"} 3
+ttfb_seconds_sum{route="/a"} 2.4
+ttfb_seconds_count{route="/a"} 3
+# HELP queue_depth 队列深度
+# TYPE queue_depth gauge
+queue_depth 3
+`
+	if b.String() != want {
+		t.Fatalf("got:\n%s\nwant:\n%s", b.String(), want)
+	}
+}
+
+func TestConcurrentCounter(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.Counter("c_total", "c")
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 1000; j++ {
+				c.With().Inc()
+			}
+		}()
+	}
+	wg.Wait()
+	if got := c.With().Value(); got != 8000 {
+		t.Fatalf("counter = %v, want 8000", got)
+	}
+}
+
+func TestDuplicatePanics(t *testing.T) {
+	reg := NewRegistry()
+	reg.Counter("x", "x")
+	defer func() {
+		if recover() == nil {
+			t.Fatal("duplicate registration did not panic")
+		}
+	}()
+	reg.Gauge("x", "x")
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"go-learning/advanced/StreamingOutput/event"
+	"go-learning/advanced/StreamingOutput/format"
+	"go-learning/advanced/StreamingOutput/sse"
+)
+
+// ============ 路由与中间件链 ============
+//
+// 所有路由注册在 newMux 创建的专用 ServeMux 上，不再使用 http.DefaultServeMux。
+// 每个路由由一条中间件链包装，排在前面的在外层：
+//
+//	普通路由  logged → withCORS → authenticated → recovered
+//	流式路由  logged → withCORS → authenticated → limited → tracked → instrumented
+//	          → registered → compressed → recorded → recovered → streaming
+//
+// logged 分配请求ID并在请求结束时输出访问日志；recovered 捕获处理器的 panic；
+// streaming 是流式路由共同的前置处理：确认能逐块刷新，并关闭缓存和代理缓冲。
+
+// middleware 包装某个路由的处理器，route 是注册时的路由模式
+type middleware func(route string, next http.Handler) http.Handler
+
+// chain 把多个中间件组合成一个，第一个在最外层
+func chain(mws ...middleware) middleware {
+	return func(route string, h http.Handler) http.Handler {
+		for i := len(mws) - 1; i >= 0; i-- {
+			h = mws[i](route, h)
+		}
+		return h
+	}
+}
+
+// anyRoute 把与路由无关的包装函数适配为 middleware
+func anyRoute(wrap func(http.Handler) http.Handler) middleware {
+	return func(_ string, h http.Handler) http.Handler { return wrap(h) }
+}
+
+// newMux 创建服务器的路由，须在配置和各组件初始化之后调用
+func newMux() *http.ServeMux {
+	var (
+		// 普通路由：CORS 和认证
+		plain = chain(logged, withCORS, anyRoute(authenticated), recovered)
+		// 流式路由：另外按客户端限流、跟踪优雅关闭、记录指标、分配流ID，并按配置启用压缩和录制
+		stream = chain(logged, withCORS, anyRoute(authenticated), limited, streams.tracked, instrumented,
+			registered, compressed, recorded, recovered, streaming)
+		// WebSocket：连接被 Hijack，不经过 CORS、压缩和录制；跨站来源由 ws.Upgrade 按 CORS 配置拒绝
+		socket = chain(logged, anyRoute(authenticated), limited, streams.tracked, instrumented, recovered)
+		// 主页和签发URL不需要认证
+		public = chain(logged, recovered)
+	)
+
+	mux := http.NewServeMux()
+	handle := func(pattern string, c middleware, h http.HandlerFunc) {
+		mux.Handle(pattern, c(pattern, h))
+	}
+	handle("/", chain(public, compressed), indexHandler)
+	handle("/stream/sse", stream, sseHandler)
+	handle("/stream/text", stream, textStreamHandler)
+	handle("/stream/json", stream, jsonStreamHandler)
+	handle("/stream/pipeline", stream, pipelineHandler) // 通道解耦示例
+	handle("/stream/ws", socket, wsHandler)
+	handle("/stream/broadcast", stream, broadcastHandler)
+	handle("/stream/broadcast/stats", plain, broadcastStatsHandler)
+	handle("/publish/", plain, publishHandler)
+	handle("/subscribe/", stream, subscribeHandler)
+	handle("/topics", plain, topicsHandler)
+	handle("/v1/chat/completions", stream, chatCompletionsHandler)
+	handle("/streams", plain, streamsHandler)
+	handle("/streams/", plain, streamsHandler)
+	handle("/sessions", plain, sessionsHandler)
+	handle(sessionsRoute, stream, sessionsHandler)
+	handle("/config", plain, configHandler)
+	handle("/metrics", plain, metricsHandler)
+	handle("/auth/sign", public, signHandler)
+	return mux
+}
+
+// recovered 捕获处理器的 panic 并记录堆栈
+// 响应头还没发出时返回 500；SSE 流已经开始时先执行处理器用 onPanic 登记的收尾函数
+// （刷新编码器缓冲的数据），再追加 error、done 和 usage 信封让客户端知道流异常结束；
+// 其他已经开始的响应无法补救，中止连接使客户端看到不完整的响应
+func recovered(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rw := &responseWriter{ResponseWriter: w}
+		rc := &recovery{}
+		r = r.WithContext(context.WithValue(r.Context(), recoveryKey{}, rc))
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			if v == http.ErrAbortHandler { // 处理器主动中止，交给 net/http 处理
+				panic(v)
+			}
+			handlerPanics.With(route).Inc()
+			logger := loggerFrom(r.Context())
+			logger.Error("处理器panic", "panic", v, "stack", string(debug.Stack()))
+
+			switch {
+			case rw.hijacked:
+			case rw.status == 0:
+				http.Error(w, "internal server error", http.StatusInternalServerError)
+			case strings.HasPrefix(w.Header().Get("Content-Type"), sse.ContentType):
+				rc.runHooks(logger)
+				st := rc.stream
+				if st == nil {
+					st = newEventStream(r.Context(), 0)
+				}
+				recs := []format.Record{record(st.Error("internal_error", "internal server error", 0))}
+				for _, rec := range append(recs, endRecords(st, event.ReasonError, "internal server error")...) {
+					sse.Encode(w, format.SSEEvent(rec))
+				}
+				http.NewResponseController(w).Flush()
+			default:
+				panic(http.ErrAbortHandler)
+			}
+		}()
+		h.ServeHTTP(rw, r)
+	})
+}
+
+// recovery 是 recovered 放进 Context 的状态，只在处理器所在的 goroutine 中使用
+type recovery struct {
+	hooks  []func()      // panic 后、写出错误之前执行
+	stream *event.Stream // 处理器的信封序列，错误信封接着它编号
+}
+
+type recoveryKey struct{}
+
+// onPanic 登记处理器 panic 时、recovered 写出错误之前执行的函数，
+// 例如刷新 flush.Writer 缓冲的数据，避免错误事件插到未发出的数据之前；不经过 recovered 的请求忽略
+func onPanic(ctx context.Context, fn func()) {
+	if rc, ok := ctx.Value(recoveryKey{}).(*recovery); ok {
+		rc.hooks = append(rc.hooks, fn)
+	}
+}
+
+// runHooks 依次执行登记的函数，其中一个再次 panic 不影响其余的和随后的错误信封
+func (rc *recovery) runHooks(logger *slog.Logger) {
+	for _, fn := range rc.hooks {
+		func() {
+			defer func() {
+				if v := recover(); v != nil {
+					logger.Error("panic收尾函数失败", "panic", v)
+				}
+			}()
+			fn()
+		}()
+	}
+}
+
+// streaming 确认响应可以逐块刷新，并设置流式响应共同的响应头
+// 💡 X-Accel-Buffering: no 让 nginx 等反向代理不缓冲响应
+func streaming(_ string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !canFlush(w) {
+			http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+			return
+		}
+		header := w.Header()
+		header.Set("Cache-Control", "no-cache")
+		header.Set("X-Accel-Buffering", "no")
+		h.ServeHTTP(w, r)
+	})
+}
+
+// canFlush 沿 Unwrap 链找到最底层的 ResponseWriter，判断它是否支持 http.Flusher
+// 中间的包装都会转发 Flush，只看它们自己实现了 Flush 并不能说明底层可以刷新
+func canFlush(w http.ResponseWriter) bool {
+	for {
+		u, ok := w.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			_, ok := w.(http.Flusher)
+			return ok
+		}
+		w = u.Unwrap()
+	}
+}
+
+// responseWriter 记录状态码、写入的字节数和首字节时间，供访问日志和 recovered 使用
+type responseWriter struct {
+	http.ResponseWriter
+	status    int // 0 表示响应头还没发出
+	bytes     int64
+	firstByte time.Time
+	hijacked  bool
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	if rw.status == 0 && code >= 200 { // 1xx 之后还会有最终的状态码
+		rw.status = code
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseWriter) Write(p []byte) (int, error) {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	if rw.firstByte.IsZero() {
+		rw.firstByte = time.Now()
+	}
+	n, err := rw.ResponseWriter.Write(p)
+	rw.bytes += int64(n)
+	return n, err
+}
+
+// Flush 实现 http.Flusher，刷新时响应头随之发出
+func (rw *responseWriter) Flush() {
+	if rw.status == 0 {
+		rw.status = http.StatusOK
+	}
+	http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+// Hijack 让 WebSocket 升级可以穿过包装
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(rw.ResponseWriter).Hijack()
+	if err == nil {
+		rw.hijacked = true
+		rw.status = http.StatusSwitchingProtocols
+	}
+	return conn, brw, err
+}
+
+// Unwrap 供 http.ResponseController 访问底层 ResponseWriter
+func (rw *responseWriter) Unwrap() http.ResponseWriter { return rw.ResponseWriter }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-learning/advanced/StreamingOutput/event"
+	"go-learning/advanced/StreamingOutput/flush"
+	"go-learning/advanced/StreamingOutput/format"
+	"go-learning/advanced/StreamingOutput/sse"
+)
+
+// TestChainOrder 第一个中间件在最外层
+func TestChainOrder(t *testing.T) {
+	var order []string
+	mark := func(name string) middleware {
+		return func(route string, h http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				order = append(order, name+":"+route)
+				h.ServeHTTP(w, r)
+			})
+		}
+	}
+	h := chain(mark("a"), chain(mark("b"), mark("c")))("/x", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/x", nil))
+	if got := strings.Join(order, " "); got != "a:/x b:/x c:/x" {
+		t.Fatalf("order = %q", got)
+	}
+}
+
+// TestRecoveredBeforeHeaders 响应头还没发出时返回 500
+func TestRecoveredBeforeHeaders(t *testing.T) {
+	h := recovered("/panic", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	}))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/panic", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Fatalf("status = %d, want 500", rec.Code)
+	}
+}
+
+// TestRecoveredSSE SSE流已经开始时先刷新编码器缓冲的数据，再接着信封序列追加 error、done 和 usage
+func TestRecoveredSSE(t *testing.T) {
+	h := chain(recovered, streaming)("/panic", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// 合并窗口远长于测试，不执行 onPanic 登记的收尾函数时 delta 会留在缓冲区里
+		enc, _ := newEncoder(w, r, format.SSE, format.Options{Flush: flush.Policy{Mode: flush.Latency, Window: time.Hour}})
+		w.WriteHeader(http.StatusOK) // 响应头已经发出
+		st := newEventStream(r.Context(), 0)
+		encode(enc, record(st.Heartbeat()), record(st.Delta("first")))
+		panic("boom")
+	}))
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("X-Accel-Buffering") != "no" {
+		t.Fatalf("status %d, preamble headers missing: %v", resp.StatusCode, resp.Header)
+	}
+	var got []string
+	dec := sse.NewDecoder(resp.Body)
+	for {
+		ev, err := dec.Next()
+		if err != nil {
+			break
+		}
+		var env event.Envelope
+		json.Unmarshal([]byte(ev.Data), &env)
+		switch {
+		case env.Error != nil:
+			got = append(got, ev.Event+":"+env.Error.Code)
+		case env.Done != nil:
+			got = append(got, ev.Event+":"+env.Done.Reason)
+		default:
+			got = append(got, ev.Event)
+		}
+	}
+	want := "heartbeat delta error:internal_error done:error usage"
+	if strings.Join(got, " ") != want {
+		t.Fatalf("events = %q, want %q", strings.Join(got, " "), want)
+	}
+}
+
+// TestStreamingUnsupported 底层不支持刷新时拒绝流式请求，即使中间的包装实现了 Flush
+func TestStreamingUnsupported(t *testing.T) {
+	called := false
+	h := chain(recovered, streaming)("/s", http.HandlerFunc(func(http.ResponseWriter, *http.Request) { called = true }))
+	rec := &noFlushWriter{httptest.NewRecorder()}
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/s", nil))
+	if called || rec.rec.Code != http.StatusInternalServerError {
+		t.Fatalf("called = %v, status = %d", called, rec.rec.Code)
+	}
+}
+
+// noFlushWriter 只实现 http.ResponseWriter
+type noFlushWriter struct{ rec *httptest.ResponseRecorder }
+
+func (w *noFlushWriter) Header() http.Header         { return w.rec.Header() }
+func (w *noFlushWriter) Write(p []byte) (int, error) { return w.rec.Write(p) }
+func (w *noFlushWriter) WriteHeader(code int)        { w.rec.WriteHeader(code) }
+
+// TestAccessLog 请求结束后输出带状态码和字节数的访问日志
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger, err := newLogger(&buf, "json", slog.LevelInfo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := slog.Default()
+	slog.SetDefault(logger)
+	defer slog.SetDefault(saved)
+	savedCfg := cfg.AccessLog
+	cfg.AccessLog = true
+	defer func() { cfg.AccessLog = savedCfg }()
+
+	h := logged("/teapot", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		io.WriteString(w, "short and stout")
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/teapot?x=1", nil))
+
+	var line map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &line); err != nil {
+		t.Fatalf("log line %q: %v", buf.String(), err)
+	}
+	if line["msg"] != "请求完成" || line["method"] != "POST" || line["path"] != "/teapot" ||
+		line["status"] != float64(http.StatusTeapot) || line["bytes"] != float64(15) || line["ttfb"] == nil {
+		t.Fatalf("access log = %v", line)
+	}
+}
+
+func TestInList(t *testing.T) {
+	list := "/stream/sse, /v1/chat/completions"
+	for route, want := range map[string]bool{"/stream/sse": true, "/v1/chat/completions": true, "/stream/text": false, "/": false} {
+		if got := inList(list, route); got != want {
+			t.Errorf("inList(%q) = %v, want %v", route, got, want)
+		}
+	}
+	if inList("", "/") {
+		t.Error("empty list excludes nothing")
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"go-learning/advanced/StreamingOutput/metrics"
+)
+
+// ============ Prometheus 指标：/metrics ============
+//
+// 流式路由的中间件链中都有 instrumented，自动统计：
+//   - 当前流数量、已发送的消息数（每写出一个信封/SSE事件算一条，见 sentMessage）
+//   - 首字节时间（TTFB）、相邻两次 Flush 之间的间隔、Flush 次数
+//   - 客户端断开：before_first_byte（还没收到任何数据）/ mid_stream（传输中）
+//
+// token 数在各处理器发送token时统计；WebSocket 连接被 Hijack 后由 wsHandler 自己统计。
+
+var (
+	promRegistry = metrics.NewRegistry()
+
+	activeStreamsGauge = promRegistry.Gauge("streaming_active_streams",
+		"Number of in-flight streams.", "route")
+	messagesSent = promRegistry.Counter("streaming_messages_sent_total",
+		"Messages (envelopes, SSE events, WebSocket frames) sent to clients.", "route")
+	flushes = promRegistry.Counter("streaming_flushes_total",
+		"Flushes of streaming responses; several messages may share one flush.", "route")
+	tokensSent = promRegistry.Counter("streaming_tokens_sent_total",
+		"Generated tokens sent to clients.", "route")
+	clientDisconnects = promRegistry.Counter("streaming_client_disconnects_total",
+		"Streams ended early because the client went away, by stage.", "route", "stage")
+	ttfbSeconds = promRegistry.Histogram("streaming_time_to_first_byte_seconds",
+		"Time from request start to the first byte written.", nil, "route")
+	interChunkSeconds = promRegistry.Histogram("streaming_inter_chunk_seconds",
+		"Time between consecutive flushes of a stream.", nil, "route")
+	handlerPanics = promRegistry.Counter("streaming_handler_panics_total",
+		"Handler panics caught by the recover middleware.", "route")
+)
+
+// 断开阶段
+const (
+	stageBeforeFirstByte = "before_first_byte"
+	stageMidStream       = "mid_stream"
+	stageIdle            = "idle" // WebSocket 连接上没有进行中的生成
+)
+
+func init() {
+	promRegistry.GaugeFunc("streaming_pipeline_queue_depth",
+		"Tokens buffered between producers and consumers across running pipelines.",
+		func() float64 { return float64(livePipelines.depth()) })
+	promRegistry.GaugeFunc("streaming_pipeline_queue_capacity",
+		"Total buffer capacity of running pipelines.",
+		func() float64 { return float64(livePipelines.capacity()) })
+	promRegistry.GaugeFunc("streaming_pipelines_running",
+		"Pipelines whose producer goroutine is still running.",
+		func() float64 { return float64(livePipelines.len()) })
+}
+
+// metricsHandler 处理 /metrics
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	promRegistry.ServeHTTP(w, r)
+}
+
+// instrumented 为流式路由记录指标
+func instrumented(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mw := &meteredWriter{ResponseWriter: w, route: route, start: time.Now()}
+		active := activeStreamsGauge.With(route)
+		active.Inc()
+		defer active.Dec()
+
+		ctx := context.WithValue(r.Context(), sentCounterKey{}, messagesSent.With(route))
+		h.ServeHTTP(mw, r.WithContext(ctx))
+
+		// 请求的 Context 被取消而服务器没有在强制关闭，说明是客户端先离开
+		if mw.hijacked || r.Context().Err() == nil || streams.baseCtx.Err() != nil {
+			return
+		}
+		stage := stageMidStream
+		if mw.first.IsZero() {
+			stage = stageBeforeFirstByte
+		}
+		clientDisconnects.With(route, stage).Inc()
+	})
+}
+
+// sentCounterKey 是 ctx 中路由的 messagesSent 计数器
+type sentCounterKey struct{}
+
+// sentMessage 统计写给客户端的一条消息；flush 策略会把多条消息合并到一次 Flush，所以由写出消息的一方统计
+// ctx 不属于 instrumented 的路由（如被限流的请求）时不统计
+func sentMessage(ctx context.Context) {
+	if c, ok := ctx.Value(sentCounterKey{}).(*metrics.Counter); ok {
+		c.Inc()
+	}
+}
+
+// meteredWriter 在写入和 Flush 时记录 TTFB、块间隔与 Flush 次数
+type meteredWriter struct {
+	http.ResponseWriter
+	route     string
+	start     time.Time
+	first     time.Time // 第一次写入的时间
+	lastFlush time.Time
+	hijacked  bool
+}
+
+func (mw *meteredWriter) Write(p []byte) (int, error) {
+	if mw.first.IsZero() {
+		mw.first = time.Now()
+		ttfbSeconds.With(mw.route).ObserveDuration(mw.first.Sub(mw.start))
+	}
+	return mw.ResponseWriter.Write(p)
+}
+
+// Flush 实现 http.Flusher
+func (mw *meteredWriter) Flush() {
+	if f, ok := mw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+	now := time.Now()
+	if !mw.lastFlush.IsZero() {
+		interChunkSeconds.With(mw.route).ObserveDuration(now.Sub(mw.lastFlush))
+	}
+	mw.lastFlush = now
+	flushes.With(mw.route).Inc()
+}
+
+// Hijack 让 WebSocket 升级可以穿过指标包装
+func (mw *meteredWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hj, ok := mw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, brw, err := hj.Hijack()
+	if err == nil {
+		mw.hijacked = true
+	}
+	return conn, brw, err
+}
+
+// Unwrap 供 http.ResponseController 访问底层 ResponseWriter
+func (mw *meteredWriter) Unwrap() http.ResponseWriter { return mw.ResponseWriter }
+
+// pipelineSet 记录生产者仍在运行的 Pipeline，用于统计队列深度
+// 生产者退出后即移除：之后剩余的缓冲token由消费者自行取完，不再计入
+type pipelineSet struct {
+	mu sync.Mutex
+	m  map[*Pipeline]struct{}
+}
+
+var livePipelines = &pipelineSet{m: make(map[*Pipeline]struct{})}
+
+func (s *pipelineSet) add(p *Pipeline) {
+	s.mu.Lock()
+	s.m[p] = struct{}{}
+	s.mu.Unlock()
+}
+
+func (s *pipelineSet) remove(p *Pipeline) {
+	s.mu.Lock()
+	delete(s.m, p)
+	s.mu.Unlock()
+}
+
+func (s *pipelineSet) len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.m)
+}
+
+func (s *pipelineSet) depth() (n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for p := range s.m {
+		n += len(p.tokens)
+	}
+	return n
+}
+
+func (s *pipelineSet) capacity() (n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for p := range s.m {
+		n += cap(p.tokens)
+	}
+	return n
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go-learning/advanced/StreamingOutput/generator"
+	"go-learning/advanced/StreamingOutput/sse"
+)
+
+// ============ OpenAI 兼容接口：/v1/chat/completions ============
+//
+// 让现有的 LLM 客户端（OpenAI SDK 等）把本服务当作本地替身做集成测试：
+//   - stream: true  以SSE发送 chat.completion.chunk，最后以 data: [DONE] 结束；服务器关闭时以 server_shutting_down 错误数据结束
+//   - stream: false 生成完毕后一次性返回 chat.completion
+//
+// 背后与 pipelineHandler 使用同一个生产者 generateWithPipeline。
+// model 与已注册的生成器同名时使用该生成器，否则使用默认生成器。
+
+type chatMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+type chatCompletionRequest struct {
+	Model         string        `json:"model"`
+	Messages      []chatMessage `json:"messages"`
+	Stream        bool          `json:"stream"`
+	MaxTokens     int           `json:"max_tokens"`
+	Temperature   float64       `json:"temperature"`
+	StreamOptions *struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+}
+
+type chatUsage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type chatDelta struct {
+	Role    string `json:"role,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+type chatChoice struct {
+	Index        int        `json:"index"`
+	Message      *chatDelta `json:"message,omitempty"`
+	Delta        *chatDelta `json:"delta,omitempty"`
+	FinishReason *string    `json:"finish_reason"`
+}
+
+type chatCompletion struct {
+	ID      string       `json:"id"`
+	Object  string       `json:"object"`
+	Created int64        `json:"created"`
+	Model   string       `json:"model"`
+	Choices []chatChoice `json:"choices"`
+	Usage   *chatUsage   `json:"usage,omitempty"`
+}
+
+type openAIError struct {
+	Error struct {
+		Message string `json:"message"`
+		Type    string `json:"type"`
+	} `json:"error"`
+}
+
+// chatCompletionsHandler 处理 POST /v1/chat/completions
+func chatCompletionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeOpenAIError(w, http.StatusMethodNotAllowed, "invalid_request_error", "only POST is supported")
+		return
+	}
+
+	var req chatCompletionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(&req); err != nil {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "invalid JSON body: "+err.Error())
+		return
+	}
+	prompt, ok := lastUserMessage(req.Messages)
+	if !ok {
+		writeOpenAIError(w, http.StatusBadRequest, "invalid_request_error", "messages must contain a user message")
+		return
+	}
+
+	// model 用于选择生成器时不再透传给生成器，由生成器使用自己的默认模型
+	opts := generator.Options{Model: req.Model, MaxTokens: req.MaxTokens, Temperature: req.Temperature}
+	gen, err := generators.Get(req.Model)
+	if err == nil {
+		opts.Model = ""
+	} else {
+		gen, _ = generators.Get(cfg.Generator)
+	}
+	if req.Model == "" {
+		req.Model = cfg.Generator
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	loggerFrom(ctx).Info("客户端连接", "model", req.Model, "stream", req.Stream)
+	streamFrom(ctx).setPrompt(prompt)
+
+	pipe := generateWithPipeline(ctx, gen, prompt, opts)
+
+	base := chatCompletion{
+		ID:      newCompletionID(),
+		Created: time.Now().Unix(),
+		Model:   req.Model,
+	}
+	if req.Stream {
+		streamChatCompletion(ctx, w, r, &req, base, pipe, prompt)
+		return
+	}
+
+	// 非流式：收集全部token后一次性返回
+	var content strings.Builder
+	count := 0
+	for token := range pipe.Tokens() {
+		content.WriteString(token)
+		count++
+	}
+	if err := pipe.Err(); err != nil {
+		if reason, ok := stopReason(ctx); ok {
+			// 410 而不是 5xx/409：OpenAI SDK 会自动重试后者
+			writeOpenAIError(w, http.StatusGone, "stream_stopped", "stream stopped: "+reason)
+			return
+		}
+		if ctx.Err() == nil {
+			writeOpenAIError(w, http.StatusBadGateway, "upstream_error", err.Error())
+		}
+		return
+	}
+
+	reason := finishReason(req.MaxTokens, count)
+	base.Object = "chat.completion"
+	base.Choices = []chatChoice{{
+		Message:      &chatDelta{Role: "assistant", Content: content.String()},
+		FinishReason: &reason,
+	}}
+	base.Usage = newUsage(prompt, count)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(base)
+}
+
+// streamChatCompletion 以SSE发送 chat.completion.chunk
+func streamChatCompletion(ctx context.Context, w http.ResponseWriter, r *http.Request,
+	req *chatCompletionRequest, base chatCompletion, pipe *Pipeline, prompt string) {
+	sw, err := sse.NewWriter(w, r)
+	if err != nil {
+		writeOpenAIError(w, http.StatusInternalServerError, "server_error", "streaming unsupported")
+		return
+	}
+
+	base.Object = "chat.completion.chunk"
+	// data 是一条SSE消息，写出后统计
+	sendData := func(data string) {
+		if sw.Data(data) == nil {
+			sentMessage(ctx)
+		}
+	}
+	send := func(choices []chatChoice, usage *chatUsage) {
+		chunk := base
+		chunk.Choices = choices
+		chunk.Usage = usage
+		data, _ := json.Marshal(chunk)
+		sendData(string(data))
+	}
+	// 响应头已发送，错误只能通过一条错误数据告知客户端
+	sendError := func(typ, msg string) {
+		var e openAIError
+		e.Error.Message = msg
+		e.Error.Type = typ
+		data, _ := json.Marshal(e)
+		sendData(string(data))
+	}
+
+	// 第一个chunk只携带角色
+	send([]chatChoice{{Delta: &chatDelta{Role: "assistant"}}}, nil)
+
+	count := 0
+	tokens := pipe.Tokens()
+	for {
+		select {
+		case <-ctx.Done():
+			if reason, ok := stopReason(ctx); ok {
+				sendError("stream_stopped", "stream stopped: "+reason)
+				loggerFrom(ctx).Info("流被停止", "tokens", count, "reason", reason)
+				return
+			}
+			loggerFrom(ctx).Warn("客户端断开连接", "tokens", count)
+			return
+
+		case <-draining():
+			// 与其他SSE接口一样立即通知客户端，而不是等到关闭超时被切断；OpenAI SDK 不会续传，由调用方重试
+			sendError("server_shutting_down", errShuttingDown.Error())
+			loggerFrom(ctx).Info("服务器关闭，通知客户端", "tokens", count)
+			return
+
+		case token, ok := <-tokens:
+			if !ok {
+				if ctx.Err() != nil {
+					tokens = nil // 生产者因取消而停止，交给 ctx.Done 分支处理
+					continue
+				}
+				if err := pipe.Err(); err != nil {
+					sendError("upstream_error", err.Error())
+					return
+				}
+
+				reason := finishReason(req.MaxTokens, count)
+				send([]chatChoice{{Delta: &chatDelta{}, FinishReason: &reason}}, nil)
+				if req.StreamOptions != nil && req.StreamOptions.IncludeUsage {
+					send([]chatChoice{}, newUsage(prompt, count))
+				}
+				sendData("[DONE]")
+				loggerFrom(ctx).Info("传输完成", "tokens", count)
+				return
+			}
+
+			count++
+			send([]chatChoice{{Delta: &chatDelta{Content: token}}}, nil)
+			sentToken(ctx, "/v1/chat/completions")
+		}
+	}
+}
+
+// lastUserMessage 取最后一条 user 消息的文本，content 可以是字符串或 [{type:"text",text:"..."}] 数组
+func lastUserMessage(msgs []chatMessage) (string, bool) {
+	for i := len(msgs) - 1; i >= 0; i-- {
+		if msgs[i].Role != "user" {
+			continue
+		}
+		var text string
+		if err := json.Unmarshal(msgs[i].Content, &text); err == nil {
+			return text, true
+		}
+		var parts []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		}
+		if err := json.Unmarshal(msgs[i].Content, &parts); err == nil {
+			var b strings.Builder
+			for _, p := range parts {
+				if p.Type == "text" {
+					b.WriteString(p.Text)
+				}
+			}
+			return b.String(), true
+		}
+	}
+	return "", false
+}
+
+// finishReason 达到 max_tokens 时为 length，否则为 stop
+func finishReason(maxTokens, count int) string {
+	if maxTokens > 0 && count >= maxTokens {
+		return "length"
+	}
+	return "stop"
+}
+
+// newUsage 统计用量，prompt_tokens 按字符数粗略估算
+func newUsage(prompt string, completion int) *chatUsage {
+	p := utf8.RuneCountInString(prompt)
+	return &chatUsage{PromptTokens: p, CompletionTokens: completion, TotalTokens: p + completion}
+}
+
+func newCompletionID() string {
+	b := make([]byte, 12)
+	rand.Read(b)
+	return "chatcmpl-" + hex.EncodeToString(b)
+}
+
+func writeOpenAIError(w http.ResponseWriter, status int, typ, msg string) {
+	var e openAIError
+	e.Error.Message = msg
+	e.Error.Type = typ
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(e)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestChatCompletions(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(chatCompletionsHandler))
+	defer srv.Close()
+
+	body := `{"model":"echo","max_tokens":3,"messages":[{"role":"user","content":"hi"}]}`
+	resp, err := http.Post(srv.URL, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var got chatCompletion
+	if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Object != "chat.completion" || len(got.Choices) != 1 {
+		t.Fatalf("unexpected response: %+v", got)
+	}
+	if c := got.Choices[0]; c.Message.Content != "你好！我" || *c.FinishReason != "length" {
+		t.Errorf("choice = %q / %q", c.Message.Content, *c.FinishReason)
+	}
+	if got.Usage == nil || got.Usage.CompletionTokens != 3 {
+		t.Errorf("usage = %+v", got.Usage)
+	}
+}
+
+func TestChatCompletionsStream(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(chatCompletionsHandler))
+	defer srv.Close()
+
+	body := `{"model":"echo","stream":true,"max_tokens":2,"messages":[{"role":"user","content":"hi"}]}`
+	resp, err := http.Post(srv.URL, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var content strings.Builder
+	done := false
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if data == "[DONE]" {
+			done = true
+			break
+		}
+		var chunk chatCompletion
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			t.Fatal(err)
+		}
+		if chunk.Object != "chat.completion.chunk" {
+			t.Errorf("object = %q", chunk.Object)
+		}
+		for _, c := range chunk.Choices {
+			content.WriteString(c.Delta.Content)
+		}
+	}
+	if !done {
+		t.Error("没有收到 data: [DONE]")
+	}
+	if content.String() != "你好！" {
+		t.Errorf("content = %q", content.String())
+	}
+}
+
+// TestChatCompletionsStreamDrain 开始关闭后 stream:true 的客户端立即收到错误数据，而不是等到关闭超时被切断
+func TestChatCompletionsStreamDrain(t *testing.T) {
+	saved := streams
+	streams = newDrainer()
+	defer func() { streams = saved }()
+
+	srv := httptest.NewServer(http.HandlerFunc(chatCompletionsHandler))
+	defer srv.Close()
+
+	body := `{"model":"echo","stream":true,"messages":[{"role":"user","content":"hi"}]}`
+	resp, err := http.Post(srv.URL, "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	if !scanner.Scan() {
+		t.Fatal("no first chunk")
+	}
+	streams.begin()
+
+	var last string
+	for scanner.Scan() {
+		if data, ok := strings.CutPrefix(scanner.Text(), "data: "); ok {
+			last = data
+		}
+	}
+	if !strings.Contains(last, `"type":"server_shutting_down"`) {
+		t.Fatalf("last data = %q, want server_shutting_down error", last)
+	}
+}
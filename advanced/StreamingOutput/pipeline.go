@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go-learning/advanced/StreamingOutput/generator"
+)
+
+// ============ 通道解耦：生产与传输分离示例 ============
+
+// generators 保存所有可按名称选择的生成器，pipelineHandler 通过 ?generator= 选择
+var generators = generator.NewRegistry()
+
+// setupGenerators 根据配置注册生成器，并检查默认生成器是否存在
+// echo 不依赖外部资源，总是可用
+func setupGenerators() error {
+	generators.Register("echo", generator.Echo{Delay: cfg.TokenDelay})
+	if cfg.ReplayFile != "" {
+		generators.Register("replay", generator.Replay{Path: cfg.ReplayFile, Speed: cfg.ReplaySpeed})
+	}
+	if cfg.Upstream != "" {
+		generators.Register("openai", generator.OpenAI{
+			BaseURL: cfg.Upstream,
+			Model:   cfg.UpstreamModel,
+			APIKey:  cfg.UpstreamKey,
+		})
+	}
+	_, err := generators.Get(cfg.Generator)
+	return err
+}
+
+// pipelineParams 从查询参数中读取提示词和生成器（?prompt=...&generator=...）
+func pipelineParams(r *http.Request) (string, generator.Generator, error) {
+	q := r.URL.Query()
+	prompt := q.Get("prompt")
+	if prompt == "" {
+		prompt = "通道解耦示例"
+	}
+
+	name := q.Get("generator")
+	if name == "" {
+		name = cfg.Generator
+	}
+	gen, err := generators.Get(name)
+	if err != nil {
+		return "", nil, fmt.Errorf("%w（可选: %v）", err, generators.Names())
+	}
+	return prompt, gen, nil
+}
+
+// Pipeline 是一次生成过程的句柄：消费者从 Tokens() 读取，通过 Err() 得知生产者为何停止
+type Pipeline struct {
+	tokens <-chan string
+	done   chan struct{}
+	err    error
+}
+
+// Tokens 返回只读的token通道，生产者退出时关闭
+func (p *Pipeline) Tokens() <-chan string { return p.tokens }
+
+// Done 在生产者goroutine退出后关闭
+func (p *Pipeline) Done() <-chan struct{} { return p.done }
+
+// Err 等待生产者退出并返回停止原因：
+// nil 表示正常生成完毕，context.Canceled / context.DeadlineExceeded 表示被取消，
+// 其他错误来自生成器本身（如上游接口失败）
+func (p *Pipeline) Err() error {
+	<-p.done
+	return p.err
+}
+
+// generateWithPipeline 在独立goroutine中运行生成器，模拟大模型逐token生成
+// 💡 关键点：返回只读通道 (<-chan string)，调用者只能接收数据
+// 💡 生成器每次发送都同时监听 ctx.Done()，消费者离开后生产者立即退出，不会阻塞在 ch <- token 上泄漏
+func generateWithPipeline(ctx context.Context, gen generator.Generator, prompt string, opts generator.Options) *Pipeline {
+	ch := make(chan string, cfg.PipelineBuffer) // 带缓冲的通道，生产者不会因为消费者慢而阻塞
+	p := &Pipeline{tokens: ch, done: make(chan struct{})}
+	livePipelines.add(p)
+
+	// 在独立的 goroutine 中生成数据（生产者）
+	go func() {
+		defer close(p.done)
+		defer close(ch) // 确保生成完成后关闭通道
+		defer livePipelines.remove(p)
+		logger := loggerFrom(ctx).With("component", "producer")
+		logger.Debug("开始生成", "prompt", prompt)
+
+		p.err = gen.Generate(ctx, prompt, opts, ch)
+		if p.err != nil {
+			logger.Warn("生成停止", "err", p.err)
+			return
+		}
+		logger.Debug("生成完成，通道已关闭")
+	}()
+
+	return p // 立即返回，不等待生成完成
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"go-learning/advanced/StreamingOutput/generator"
+)
+
+func TestMain(m *testing.M) {
+	slog.SetDefault(slog.New(slog.NewTextHandler(io.Discard, nil))) // 处理器日志太多，测试时丢弃
+	if err := setupGenerators(); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+// waitGoroutines 等待goroutine数量回落到 want 以下，超时返回最后一次的数量
+func waitGoroutines(want int, timeout time.Duration) int {
+	deadline := time.Now().Add(timeout)
+	for {
+		n := runtime.NumGoroutine()
+		if n <= want || time.Now().After(deadline) {
+			return n
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestGenerateWithPipelineComplete(t *testing.T) {
+	pipe := generateWithPipeline(context.Background(), generator.Echo{}, "测试", generator.Options{Delay: time.Millisecond})
+
+	count := 0
+	for range pipe.Tokens() {
+		count++
+	}
+	if count == 0 {
+		t.Fatal("没有收到任何token")
+	}
+	if err := pipe.Err(); err != nil {
+		t.Errorf("Err() = %v, want nil", err)
+	}
+}
+
+func TestGenerateWithPipelineCancel(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	pipe := generateWithPipeline(ctx, generator.Echo{}, "测试", generator.Options{Delay: 10 * time.Millisecond})
+	<-pipe.Tokens() // 读一个token后不再消费，缓冲区会被填满
+	cancel()
+
+	select {
+	case <-pipe.Done():
+	case <-time.After(time.Second):
+		t.Fatal("取消后生产者没有退出")
+	}
+	if err := pipe.Err(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Err() = %v, want context.Canceled", err)
+	}
+	if n := waitGoroutines(before, time.Second); n > before {
+		t.Errorf("goroutine泄漏: before=%d after=%d", before, n)
+	}
+}
+
+// TestPipelineHandlerDisconnect 模拟客户端在流中途断开，验证没有goroutine残留
+func TestPipelineHandlerDisconnect(t *testing.T) {
+	before := runtime.NumGoroutine()
+
+	srv := httptest.NewServer(http.HandlerFunc(pipelineHandler))
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	resp, err := client.Get(srv.URL + "?prompt=disconnect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 读到第一个token后断开
+	br := bufio.NewReader(resp.Body)
+	for i := 0; i < 4; i++ {
+		if _, err := br.ReadString('\n'); err != nil {
+			t.Fatal(err)
+		}
+	}
+	resp.Body.Close()
+
+	srv.Close() // 等待所有处理器返回
+	client.CloseIdleConnections()
+
+	if n := waitGoroutines(before, 2*time.Second); n > before {
+		buf := make([]byte, 1<<16)
+		t.Errorf("goroutine泄漏: before=%d after=%d\n%s", before, n, buf[:runtime.Stack(buf, true)])
+	}
+}
@@ -0,0 +1,244 @@
+// Package pubsub 实现基于主题的发布/订阅：通配符订阅，并为迟到的订阅者保留每个主题最近 N 条消息
+//
+// 每个订阅模式对应一个 hub.Hub，同一模式的订阅者共享它，
+// 因此慢消费者策略与 /stream/broadcast 相同。
+// 配置 Store 后消息会先持久化再分发，重启后序号连续，断线重连可回放更早的消息。
+package pubsub
+
+import (
+	"errors"
+	"sort"
+	"sync"
+
+	"go-learning/advanced/StreamingOutput/hub"
+)
+
+// Item 是发布到某个主题的一条消息
+type Item[T any] struct {
+	Seq   uint64 // 全局递增的序号，可用作SSE的 id
+	Topic string
+	Value T
+}
+
+// Options 配置 Broker
+type Options struct {
+	Retain    int         // 每个主题保留的最近消息数，0 表示不保留
+	MaxReplay int         // 断线重连时最多从存储回放的消息数（按序号跨度计算），<=0 时为 1000
+	Hub       hub.Options // 每个订阅者的缓冲区与慢消费者策略
+}
+
+// ErrResumeTooOld 表示重连时的 since 太旧：对应的消息已被存储删除，或需要回放的消息超过 MaxReplay
+// 客户端应当不带 Last-Event-ID 重新订阅
+var ErrResumeTooOld = errors.New("pubsub: resume point is outside the replay window")
+
+// errReplayDone 用于提前结束存储回放
+var errReplayDone = errors.New("pubsub: replay done")
+
+// Broker 是主题消息的中转站，可并发使用
+type Broker[T any] struct {
+	opts Options
+
+	store Store[T] // 可选的持久化存储
+
+	mu       sync.Mutex
+	seq      uint64
+	retained map[string][]Item[T]         // 主题 -> 最近的消息（按序号递增）
+	patterns map[string]*hub.Hub[Item[T]] // 订阅模式 -> 该模式的订阅者
+}
+
+// New 创建 Broker
+func New[T any](opts Options) *Broker[T] {
+	if opts.MaxReplay <= 0 {
+		opts.MaxReplay = 1000
+	}
+	return &Broker[T]{
+		opts:     opts,
+		retained: make(map[string][]Item[T]),
+		patterns: make(map[string]*hub.Hub[Item[T]]),
+	}
+}
+
+// Store 持久化已发布的消息，由它分配序号
+type Store[T any] interface {
+	// Append 持久化一条消息并返回它的序号
+	Append(topic string, v T) (uint64, error)
+	// Replay 依次回调序号大于 since 的消息，fn 返回错误时停止
+	Replay(since uint64, fn func(Item[T]) error) error
+	// FirstSeq 返回存储中仍保留的第一条消息的序号，为空时返回0
+	FirstSeq() uint64
+}
+
+// NewDurable 创建带持久化存储的 Broker，并从存储中恢复序号和每个主题的保留消息
+func NewDurable[T any](opts Options, store Store[T]) (*Broker[T], error) {
+	b := New[T](opts)
+	b.store = store
+	err := store.Replay(0, func(it Item[T]) error {
+		b.seq = it.Seq
+		b.retain(it)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// Publish 发布一条消息，返回它的序号和收到它的订阅者数
+func (b *Broker[T]) Publish(topic string, v T) (Item[T], int, error) {
+	if err := ValidTopic(topic); err != nil {
+		return Item[T]{}, 0, err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.store != nil {
+		// 先写入存储再分发，保证客户端收到的每条消息在重启后都能回放
+		seq, err := b.store.Append(topic, v)
+		if err != nil {
+			return Item[T]{}, 0, err
+		}
+		b.seq = seq
+	} else {
+		b.seq++
+	}
+	item := Item[T]{Seq: b.seq, Topic: topic, Value: v}
+	b.retain(item)
+
+	delivered := 0
+	for pattern, h := range b.patterns {
+		if Match(pattern, topic) {
+			h.Publish(item)
+			delivered += h.Len()
+		}
+	}
+	return item, delivered, nil
+}
+
+// retain 把消息加入主题的保留列表，调用者需持有锁
+func (b *Broker[T]) retain(item Item[T]) {
+	if b.opts.Retain <= 0 {
+		return
+	}
+	kept := append(b.retained[item.Topic], item)
+	if len(kept) > b.opts.Retain {
+		kept = append(kept[:0:0], kept[len(kept)-b.opts.Retain:]...)
+	}
+	b.retained[item.Topic] = kept
+}
+
+// Subscription 是一个模式订阅
+type Subscription[T any] struct {
+	*hub.Subscriber[Item[T]]
+	Pattern string
+	Backlog []Item[T] // 订阅时已保留的、序号大于 since 的匹配消息，按序号排列
+}
+
+// Subscribe 订阅匹配 pattern 的主题
+// since 为客户端已收到的最大序号（Last-Event-ID），Backlog 中只包含比它新的消息。
+// 配置了存储时断线重连从存储回放，since 超出回放窗口时返回 ErrResumeTooOld。
+func (b *Broker[T]) Subscribe(pattern string, since uint64) (*Subscription[T], error) {
+	if err := ValidPattern(pattern); err != nil {
+		return nil, err
+	}
+
+	// 确定回放的终点与加入订阅在同一把锁内完成，保证既不重复也不遗漏：
+	// 序号不大于 upto 的消息来自 Backlog，之后的消息来自订阅
+	b.mu.Lock()
+	upto := b.seq
+	fromStore := b.store != nil && since > 0 && since < upto
+	var backlog []Item[T]
+	if fromStore {
+		if upto-since > uint64(b.opts.MaxReplay) || since+1 < b.store.FirstSeq() {
+			b.mu.Unlock()
+			return nil, ErrResumeTooOld
+		}
+	} else {
+		backlog = b.retainedSince(pattern, since)
+	}
+	h, ok := b.patterns[pattern]
+	if !ok {
+		h = hub.New[Item[T]](b.opts.Hub)
+		b.patterns[pattern] = h
+	}
+	sub := &Subscription[T]{Subscriber: h.Subscribe(), Pattern: pattern, Backlog: backlog}
+	b.mu.Unlock()
+
+	// 在锁外从存储回放，不阻塞发布；条数受 MaxReplay 限制
+	if fromStore {
+		var err error
+		if sub.Backlog, err = b.replay(pattern, since, upto); err != nil {
+			b.Unsubscribe(sub)
+			return nil, err
+		}
+	}
+	return sub, nil
+}
+
+// replay 从存储读取序号在 (since, upto] 之间、匹配 pattern 的消息
+func (b *Broker[T]) replay(pattern string, since, upto uint64) ([]Item[T], error) {
+	var backlog []Item[T]
+	err := b.store.Replay(since, func(it Item[T]) error {
+		if it.Seq > upto {
+			return errReplayDone
+		}
+		if Match(pattern, it.Topic) {
+			backlog = append(backlog, it)
+		}
+		return nil
+	})
+	if err == errReplayDone {
+		err = nil
+	}
+	return backlog, err
+}
+
+// retainedSince 返回内存中保留的、序号大于 since 的匹配消息，调用者需持有锁
+func (b *Broker[T]) retainedSince(pattern string, since uint64) []Item[T] {
+	var backlog []Item[T]
+	for topic, items := range b.retained {
+		if !Match(pattern, topic) {
+			continue
+		}
+		for _, it := range items {
+			if it.Seq > since {
+				backlog = append(backlog, it)
+			}
+		}
+	}
+	sort.Slice(backlog, func(i, j int) bool { return backlog[i].Seq < backlog[j].Seq })
+	return backlog
+}
+
+// Unsubscribe 取消订阅，模式没有订阅者时一并删除
+func (b *Broker[T]) Unsubscribe(s *Subscription[T]) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	h, ok := b.patterns[s.Pattern]
+	if !ok {
+		return
+	}
+	h.Unsubscribe(s.Subscriber)
+	if h.Len() == 0 {
+		h.Close()
+		delete(b.patterns, s.Pattern)
+	}
+}
+
+// TopicStats 是单个主题的统计
+type TopicStats struct {
+	Topic    string `json:"topic"`
+	Retained int    `json:"retained"`
+	LastSeq  uint64 `json:"last_seq"`
+}
+
+// Topics 返回所有有保留消息的主题
+func (b *Broker[T]) Topics() []TopicStats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	out := make([]TopicStats, 0, len(b.retained))
+	for topic, items := range b.retained {
+		out = append(out, TopicStats{Topic: topic, Retained: len(items), LastSeq: items[len(items)-1].Seq})
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i].Topic < out[j].Topic })
+	return out
+}
@@ -0,0 +1,129 @@
+package pubsub
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, topic string
+		want           bool
+	}{
+		{"orders.created", "orders.created", true},
+		{"orders.*", "orders.created", true},
+		{"orders.*", "orders.eu.created", false},
+		{"orders.*.created", "orders.eu.created", true},
+		{"orders.**", "orders.eu.created", true},
+		{"orders.**", "orders", false},
+		{"**", "anything.at.all", true},
+		{"orders", "orders.created", false},
+	}
+	for _, tt := range tests {
+		if got := Match(tt.pattern, tt.topic); got != tt.want {
+			t.Errorf("Match(%q, %q) = %v, want %v", tt.pattern, tt.topic, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "a..b", "a.*", "a b"} {
+		if ValidTopic(bad) == nil {
+			t.Errorf("ValidTopic(%q) 应当失败", bad)
+		}
+	}
+	if ValidPattern("a.**.b") == nil {
+		t.Error("** 只能出现在末尾")
+	}
+}
+
+func TestRetentionForLateJoiners(t *testing.T) {
+	b := New[string](Options{Retain: 2})
+	b.Publish("news.sport", "s1")
+	b.Publish("news.tech", "t1")
+	b.Publish("news.sport", "s2")
+	b.Publish("news.sport", "s3") // s1 超出保留数被丢弃
+	b.Publish("weather", "w1")
+
+	sub, err := b.Subscribe("news.*", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Unsubscribe(sub)
+
+	var got []string
+	for _, it := range sub.Backlog {
+		got = append(got, it.Value)
+	}
+	if want := []string{"t1", "s2", "s3"}; !slices.Equal(got, want) {
+		t.Errorf("backlog = %v, want %v", got, want)
+	}
+
+	// Last-Event-ID 之后的消息才补发
+	resumed, _ := b.Subscribe("news.sport", 3)
+	defer b.Unsubscribe(resumed)
+	if len(resumed.Backlog) != 1 || resumed.Backlog[0].Value != "s3" {
+		t.Errorf("resumed backlog = %v", resumed.Backlog)
+	}
+
+	// 订阅之后的实时消息
+	_, n, _ := b.Publish("news.tech", "t2")
+	if n != 1 {
+		t.Errorf("delivered = %d, want 1", n)
+	}
+	if it := <-sub.C(); it.Value != "t2" || it.Topic != "news.tech" {
+		t.Errorf("live item = %+v", it)
+	}
+}
+
+// memStore 是测试用的内存存储，first 之前的消息视为已被删除
+type memStore struct {
+	items []Item[string]
+	first uint64
+}
+
+func (s *memStore) Append(topic, v string) (uint64, error) {
+	seq := uint64(len(s.items) + 1)
+	s.items = append(s.items, Item[string]{Seq: seq, Topic: topic, Value: v})
+	return seq, nil
+}
+
+func (s *memStore) Replay(since uint64, fn func(Item[string]) error) error {
+	for _, it := range s.items {
+		if it.Seq > since && it.Seq >= s.first {
+			if err := fn(it); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *memStore) FirstSeq() uint64 { return s.first }
+
+func TestDurableReplayWindow(t *testing.T) {
+	store := &memStore{first: 1}
+	b, err := NewDurable[string](Options{Retain: 1, MaxReplay: 5}, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 8; i++ {
+		b.Publish("news", fmt.Sprint(i))
+	}
+
+	// 从存储回放超出内存保留数的消息
+	sub, err := b.Subscribe("news", 4)
+	if err != nil || len(sub.Backlog) != 4 || sub.Backlog[0].Seq != 5 {
+		t.Fatalf("Subscribe(since=4) = %v, %v", sub, err)
+	}
+	b.Unsubscribe(sub)
+
+	// 超过 MaxReplay
+	if _, err := b.Subscribe("news", 2); err != ErrResumeTooOld {
+		t.Fatalf("Subscribe(since=2) err = %v", err)
+	}
+	// 早于存储中保留的第一条
+	store.first = 6
+	if _, err := b.Subscribe("news", 4); err != ErrResumeTooOld {
+		t.Fatalf("Subscribe(since=4) after retention err = %v", err)
+	}
+}
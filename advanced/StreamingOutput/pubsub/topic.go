@@ -0,0 +1,66 @@
+package pubsub
+
+import (
+	"errors"
+	"strings"
+)
+
+// 主题由点号分隔的段组成，如 orders.eu.created
+// 订阅时可以使用通配符：
+//   - *  匹配恰好一段：orders.*.created 匹配 orders.eu.created
+//   - ** 只能放在最后，匹配剩余的一段或多段：orders.** 匹配 orders.eu 和 orders.eu.created
+
+// ErrInvalidTopic 表示主题或订阅模式不合法
+var ErrInvalidTopic = errors.New("pubsub: invalid topic")
+
+// ValidTopic 检查发布用的主题：不能为空、不能含通配符，每段只允许字母、数字、- 和 _
+func ValidTopic(topic string) error {
+	for _, seg := range strings.Split(topic, ".") {
+		if !validSegment(seg) {
+			return ErrInvalidTopic
+		}
+	}
+	return nil
+}
+
+// ValidPattern 检查订阅模式，允许 * 和位于末尾的 **
+func ValidPattern(pattern string) error {
+	segs := strings.Split(pattern, ".")
+	for i, seg := range segs {
+		switch {
+		case seg == "*":
+		case seg == "**" && i == len(segs)-1:
+		case validSegment(seg):
+		default:
+			return ErrInvalidTopic
+		}
+	}
+	return nil
+}
+
+// Match 判断主题是否匹配订阅模式
+func Match(pattern, topic string) bool {
+	ps := strings.Split(pattern, ".")
+	ts := strings.Split(topic, ".")
+	for i, p := range ps {
+		if p == "**" {
+			return len(ts) > i
+		}
+		if i >= len(ts) || (p != "*" && p != ts[i]) {
+			return false
+		}
+	}
+	return len(ps) == len(ts)
+}
+
+func validSegment(seg string) bool {
+	if seg == "" {
+		return false
+	}
+	for _, c := range seg {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,165 @@
+// Package ratelimit 按客户端限制请求速率和同时进行的流数量
+//
+// 每个客户端（IP 或 API Key）有一个令牌桶：每秒补充 Rate 个令牌，最多存 Burst 个，
+// 每个请求消耗一个；另外同一客户端同时进行的流不能超过 MaxStreams。
+//
+//	l := ratelimit.New(ratelimit.Options{Rate: 2, Burst: 10, MaxStreams: 4})
+//	release, retry, err := l.Admit(key)
+//	if err != nil { /* 429，retry 之后再试 */ }
+//	defer release()
+//
+// 长时间空闲（没有进行中的流且令牌桶已满）的客户端会被定期清理，内存不会随客户端数无限增长。
+package ratelimit
+
+import (
+	"errors"
+	"math"
+	"sync"
+	"time"
+)
+
+var (
+	// ErrRateLimited 表示令牌桶已空
+	ErrRateLimited = errors.New("ratelimit: too many requests")
+	// ErrTooManyStreams 表示同时进行的流已达上限
+	ErrTooManyStreams = errors.New("ratelimit: too many concurrent streams")
+)
+
+// Options 配置限流器，零值字段表示不限制
+type Options struct {
+	Rate       float64       // 每秒补充的令牌数，<=0 时不限制速率
+	Burst      int           // 令牌桶容量，<=0 时为 1
+	MaxStreams int           // 每个客户端同时进行的流数，<=0 时不限制
+	StreamWait time.Duration // 流数达到上限时建议的重试间隔，<=0 时为 1s
+
+	Now func() time.Time // 测试用，nil 时为 time.Now
+}
+
+// Limiter 是按 key 区分的令牌桶 + 并发计数器，可以并发使用
+type Limiter struct {
+	opts Options
+
+	mu        sync.Mutex
+	clients   map[string]*client
+	lastSweep time.Time
+}
+
+type client struct {
+	tokens float64
+	last   time.Time // 上次补充令牌的时间
+	active int       // 进行中的流
+}
+
+// sweepInterval 是清理空闲客户端的最小间隔
+const sweepInterval = time.Minute
+
+// New 创建限流器
+func New(opts Options) *Limiter {
+	if opts.Burst <= 0 {
+		opts.Burst = 1
+	}
+	if opts.StreamWait <= 0 {
+		opts.StreamWait = time.Second
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return &Limiter{opts: opts, clients: make(map[string]*client), lastSweep: opts.Now()}
+}
+
+// Allow 消耗 key 的一个令牌；令牌不足时返回 ErrRateLimited 和下一个令牌到来前的等待时间
+func (l *Limiter) Allow(key string) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.allow(l.get(key))
+}
+
+// Admit 消耗一个令牌并占用一个流名额，成功时返回释放名额的函数（可以重复调用）
+// 被拒绝时返回 ErrRateLimited 或 ErrTooManyStreams，以及建议的重试间隔
+func (l *Limiter) Admit(key string) (release func(), retryAfter time.Duration, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	c := l.get(key)
+	if l.opts.MaxStreams > 0 && c.active >= l.opts.MaxStreams {
+		return nil, l.opts.StreamWait, ErrTooManyStreams
+	}
+	if retry, err := l.allow(c); err != nil {
+		return nil, retry, err
+	}
+
+	c.active++
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			c.active--
+			l.mu.Unlock()
+		})
+	}, 0, nil
+}
+
+// Active 返回 key 当前进行中的流数量
+func (l *Limiter) Active(key string) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if c, ok := l.clients[key]; ok {
+		return c.active
+	}
+	return 0
+}
+
+// Len 返回正在跟踪的客户端数
+func (l *Limiter) Len() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return len(l.clients)
+}
+
+// get 返回 key 对应的客户端并补充令牌，需持有锁
+func (l *Limiter) get(key string) *client {
+	now := l.opts.Now()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	c, ok := l.clients[key]
+	if !ok {
+		c = &client{tokens: float64(l.opts.Burst), last: now}
+		l.clients[key] = c
+		return c
+	}
+	if l.opts.Rate > 0 {
+		elapsed := now.Sub(c.last).Seconds()
+		c.tokens = math.Min(float64(l.opts.Burst), c.tokens+elapsed*l.opts.Rate)
+	}
+	c.last = now
+	return c
+}
+
+// allow 从客户端的令牌桶中取一个令牌，需持有锁
+func (l *Limiter) allow(c *client) (time.Duration, error) {
+	if l.opts.Rate <= 0 {
+		return 0, nil
+	}
+	if c.tokens < 1 {
+		wait := time.Duration((1 - c.tokens) / l.opts.Rate * float64(time.Second))
+		return wait, ErrRateLimited
+	}
+	c.tokens--
+	return 0, nil
+}
+
+// sweep 删除没有进行中的流且令牌桶已经补满的客户端，需持有锁
+func (l *Limiter) sweep(now time.Time) {
+	l.lastSweep = now
+	for key, c := range l.clients {
+		if c.active > 0 {
+			continue
+		}
+		full := l.opts.Rate <= 0 || c.tokens+now.Sub(c.last).Seconds()*l.opts.Rate >= float64(l.opts.Burst)
+		if full {
+			delete(l.clients, key)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeClock 是可以手动推进的时钟
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) Now() time.Time          { return c.t }
+func (c *fakeClock) Advance(d time.Duration) { c.t = c.t.Add(d) }
+
+func TestTokenBucket(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	l := New(Options{Rate: 2, Burst: 3, Now: clock.Now})
+
+	for i := 0; i < 3; i++ {
+		if _, err := l.Allow("a"); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	retry, err := l.Allow("a")
+	if !errors.Is(err, ErrRateLimited) || retry != 500*time.Millisecond {
+		t.Fatalf("4th request = %v, %v; want ErrRateLimited after 500ms", retry, err)
+	}
+
+	// 其他客户端不受影响
+	if _, err := l.Allow("b"); err != nil {
+		t.Fatalf("other client: %v", err)
+	}
+
+	clock.Advance(500 * time.Millisecond)
+	if _, err := l.Allow("a"); err != nil {
+		t.Fatalf("after refill: %v", err)
+	}
+}
+
+func TestMaxStreams(t *testing.T) {
+	l := New(Options{MaxStreams: 2})
+
+	r1, _, err := l.Admit("a")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := l.Admit("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, retry, err := l.Admit("a"); !errors.Is(err, ErrTooManyStreams) || retry != time.Second {
+		t.Fatalf("3rd stream = %v, %v; want ErrTooManyStreams", retry, err)
+	}
+
+	r1()
+	r1() // 重复释放无效
+	if got := l.Active("a"); got != 1 {
+		t.Fatalf("active = %d, want 1", got)
+	}
+	if _, _, err := l.Admit("a"); err != nil {
+		t.Fatalf("after release: %v", err)
+	}
+}
+
+func TestSweepIdleClients(t *testing.T) {
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	l := New(Options{Rate: 1, Burst: 1, MaxStreams: 1, Now: clock.Now})
+
+	l.Allow("idle")
+	release, _, _ := l.Admit("busy")
+	defer release()
+
+	clock.Advance(2 * sweepInterval)
+	l.Allow("new")
+	if got := l.Len(); got != 2 {
+		t.Fatalf("clients after sweep = %d, want 2 (busy + new)", got)
+	}
+}
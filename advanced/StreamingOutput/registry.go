@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go-learning/advanced/StreamingOutput/event"
+	"go-learning/advanced/StreamingOutput/format"
+	"go-learning/advanced/StreamingOutput/sse"
+)
+
+// ============ 流注册表：查看和停止正在进行的流 ============
+//
+// 流式路由上，每个请求都会被分配一个流ID，
+// 通过 X-Stream-ID 响应头返回（/stream/pipeline 的第一条事件或第一行也会带上）。
+//
+//	GET    /streams                  列出正在进行的流：路由、提示词、已持续时间、已发送的token数
+//	DELETE /streams/{id}?reason=...  在服务端取消该流
+//
+// 被停止的流会告诉客户端结束原因后再关闭：SSE 发送 event: stopped，
+// 文本流写出一行说明，JSON 数组以 aborted 状态的 trailer 结束。
+//
+// 每个流记录发起它的客户端（见 clientKey：认证通过的身份，未配置认证时为 IP），
+// 两个接口都只能看到和停止调用者自己的流；-auth-admins 中的管理员可以看到和停止所有流。
+
+const streamIDHeader = "X-Stream-ID"
+
+// liveStream 是注册表中的一个流
+type liveStream struct {
+	id      string
+	route   string
+	owner   string // 发起流的客户端，见 clientKey
+	remote  string
+	started time.Time
+	cancel  context.CancelCauseFunc
+
+	prompt atomic.Value // string，由处理器解析参数后设置
+	tokens atomic.Int64
+}
+
+// setPrompt 记录流的提示词，s 为 nil 时什么也不做
+func (s *liveStream) setPrompt(prompt string) {
+	if s != nil {
+		s.prompt.Store(prompt)
+	}
+}
+
+// streamView 是 GET /streams 返回的一项
+type streamView struct {
+	ID         string    `json:"id"`
+	Route      string    `json:"route"`
+	Owner      string    `json:"owner"`
+	Prompt     string    `json:"prompt,omitempty"`
+	Remote     string    `json:"remote"`
+	Started    time.Time `json:"started"`
+	AgeSeconds float64   `json:"age_seconds"`
+	Tokens     int64     `json:"tokens"`
+}
+
+func (s *liveStream) view(now time.Time) streamView {
+	prompt, _ := s.prompt.Load().(string)
+	return streamView{
+		ID:         s.id,
+		Route:      s.route,
+		Owner:      s.owner,
+		Prompt:     prompt,
+		Remote:     s.remote,
+		Started:    s.started,
+		AgeSeconds: now.Sub(s.started).Seconds(),
+		Tokens:     s.tokens.Load(),
+	}
+}
+
+// stopError 是被 DELETE /streams/{id} 取消的流的 Context cause
+type stopError struct{ reason string }
+
+func (e *stopError) Error() string { return "stream stopped: " + e.reason }
+
+// streamRegistry 保存所有正在进行的流
+type streamRegistry struct {
+	mu      sync.Mutex
+	streams map[string]*liveStream
+}
+
+// liveStreams 是本进程的流注册表
+var liveStreams = &streamRegistry{streams: make(map[string]*liveStream)}
+
+func (reg *streamRegistry) add(s *liveStream) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	reg.streams[s.id] = s
+}
+
+func (reg *streamRegistry) remove(id string) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	delete(reg.streams, id)
+}
+
+// live 报告流是否仍在进行
+func (reg *streamRegistry) live(id string) bool {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	_, ok := reg.streams[id]
+	return ok
+}
+
+// list 按开始时间返回 owner 的流，owner 为空时返回所有流
+func (reg *streamRegistry) list(owner string) []streamView {
+	now := time.Now()
+	reg.mu.Lock()
+	views := make([]streamView, 0, len(reg.streams))
+	for _, s := range reg.streams {
+		if owner == "" || s.owner == owner {
+			views = append(views, s.view(now))
+		}
+	}
+	reg.mu.Unlock()
+	sort.Slice(views, func(i, j int) bool { return views[i].Started.Before(views[j].Started) })
+	return views
+}
+
+// stop 取消 owner 的流，owner 为空时可以取消任何流；流不存在或属于别人时返回 false
+func (reg *streamRegistry) stop(id, owner, reason string) (streamView, bool) {
+	reg.mu.Lock()
+	s, ok := reg.streams[id]
+	reg.mu.Unlock()
+	if !ok || (owner != "" && s.owner != owner) {
+		return streamView{}, false
+	}
+	s.cancel(&stopError{reason: reason})
+	return s.view(time.Now()), true
+}
+
+type liveStreamKey struct{}
+
+// registered 为流式路由上的每个请求分配流ID并登记到注册表，请求结束时移除
+func registered(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithCancelCause(r.Context())
+		defer cancel(nil)
+
+		s := &liveStream{id: newID(6), route: route, owner: clientKey(r), remote: r.RemoteAddr, started: time.Now(), cancel: cancel}
+		liveStreams.add(s)
+		defer liveStreams.remove(s.id)
+
+		w.Header().Set(streamIDHeader, s.id)
+		ctx = context.WithValue(ctx, liveStreamKey{}, s)
+		ctx = withLogger(ctx, loggerFrom(ctx).With("stream_id", s.id))
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// streamFrom 返回 ctx 所属的流，不在注册表中的请求返回 nil
+func streamFrom(ctx context.Context) *liveStream {
+	s, _ := ctx.Value(liveStreamKey{}).(*liveStream)
+	return s
+}
+
+// streamID 返回 ctx 所属流的ID，没有时为空
+func streamID(ctx context.Context) string {
+	if s := streamFrom(ctx); s != nil {
+		return s.id
+	}
+	return ""
+}
+
+// sentToken 统计发送给客户端的一个token：路由的指标和流自己的计数
+func sentToken(ctx context.Context, route string) {
+	tokensSent.With(route).Inc()
+	if s := streamFrom(ctx); s != nil {
+		s.tokens.Add(1)
+	}
+}
+
+// stopReason 在 ctx 因 DELETE /streams/{id} 被取消时返回停止原因
+func stopReason(ctx context.Context) (string, bool) {
+	if e, ok := context.Cause(ctx).(*stopError); ok {
+		return e.reason, true
+	}
+	return "", false
+}
+
+// stoppedRecords 告诉客户端流在服务端被停止，不带 retry：客户端不应自动重连继续
+func stoppedRecords(st *event.Stream, reason string) []format.Record {
+	return endRecords(st, event.ReasonStopped, reason)
+}
+
+// sendStopped 向SSE客户端发送 stoppedRecords
+func sendStopped(ctx context.Context, sw *sse.Writer, st *event.Stream, reason string) {
+	sendRecords(ctx, sw, stoppedRecords(st, reason)...)
+}
+
+// streamsHandler 处理 GET /streams 和 DELETE /streams/{id}
+func streamsHandler(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/streams"), "/")
+	// 管理员不按客户端过滤；其他客户端的流对非管理员来说不存在，停止时同样返回 404
+	owner := clientKey(r)
+	if isAdmin(r.Context()) {
+		owner = ""
+	}
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(liveStreams.list(owner))
+
+	case id != "" && r.Method == http.MethodDelete:
+		reason := r.URL.Query().Get("reason")
+		if reason == "" {
+			reason = "stopped by operator"
+		}
+		view, ok := liveStreams.stop(id, owner, reason)
+		if !ok {
+			http.Error(w, "stream not found", http.StatusNotFound)
+			return
+		}
+		loggerFrom(r.Context()).Info("停止流", "stream_id", id, "route", view.Route, "owner", view.Owner, "reason", reason)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(view)
+
+	case id == "":
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	default:
+		w.Header().Set("Allow", http.MethodDelete)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"go-learning/advanced/StreamingOutput/auth"
+	"go-learning/advanced/StreamingOutput/sse"
+)
+
+// TestStopStream 流ID通过响应头和第一条心跳返回，DELETE 后客户端收到 reason 为 stopped 的 done 和 usage
+func TestStopStream(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.Handle("/stream/pipeline", registered("/stream/pipeline", http.HandlerFunc(pipelineHandler)))
+	mux.HandleFunc("/streams", streamsHandler)
+	mux.HandleFunc("/streams/", streamsHandler)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	req, _ := http.NewRequest("GET", srv.URL+"/stream/pipeline?prompt=一个很长很长的提示词", nil)
+	req.Header.Set("Accept", sse.ContentType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	id := resp.Header.Get(streamIDHeader)
+
+	dec := sse.NewDecoder(resp.Body)
+	first, err := dec.Next()
+	if err != nil || first.Event != "heartbeat" || !strings.Contains(first.Data, id) || id == "" {
+		t.Fatalf("first event = %+v, %v; header id %q", first, err, id)
+	}
+	// token 发送后才计数，收到第二个token时第一个一定已经计入
+	for i := 0; i < 2; i++ {
+		if ev, err := dec.Next(); err != nil || ev.Event != "delta" {
+			t.Fatalf("token event = %+v, %v", ev, err)
+		}
+	}
+
+	var list []streamView
+	getJSON(t, "GET", srv.URL+"/streams", http.StatusOK, &list)
+	if len(list) != 1 || list[0].ID != id || list[0].Route != "/stream/pipeline" || list[0].Prompt != "一个很长很长的提示词" || list[0].Tokens < 1 {
+		t.Fatalf("GET /streams = %+v", list)
+	}
+
+	getJSON(t, "DELETE", srv.URL+"/streams/nope", http.StatusNotFound, nil)
+	getJSON(t, "DELETE", srv.URL+"/streams/"+id+"?reason=too+slow", http.StatusOK, nil)
+
+	for {
+		ev, err := dec.Next()
+		if err != nil {
+			t.Fatalf("stream ended without done event: %v", err)
+		}
+		if ev.Event == "done" {
+			if !strings.Contains(ev.Data, `"reason":"stopped","detail":"too slow"`) {
+				t.Fatalf("done data = %q", ev.Data)
+			}
+			break
+		}
+	}
+	if ev, err := dec.Next(); err != nil || ev.Event != "usage" {
+		t.Fatalf("after done = %+v, %v; want usage", ev, err)
+	}
+	resp.Body.Close()
+	srv.Close() // 等待处理器返回
+
+	getList := liveStreams.list("")
+	if len(getList) != 0 {
+		t.Fatalf("registry not empty after stream ended: %+v", getList)
+	}
+}
+
+func getJSON(t *testing.T, method, url string, status int, v any) {
+	t.Helper()
+	req, _ := http.NewRequest(method, url, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != status {
+		t.Fatalf("%s %s = %d, want %d", method, url, resp.StatusCode, status)
+	}
+	if v != nil {
+		if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestStreamsOwnership 非管理员只能看到和停止自己的流，管理员可以看到和停止所有流
+func TestStreamsOwnership(t *testing.T) {
+	keys, _ := auth.ParseKeys([]byte("ta alice\ntb bob\ntr root\n"))
+	withAuth(t, &auth.Authenticator{Keys: keys})
+	savedAdmins := admins
+	admins = map[string]bool{"root": true}
+	t.Cleanup(func() { admins = savedAdmins })
+
+	stopped := map[string]bool{}
+	for _, owner := range []string{"alice", "bob"} {
+		id := "own-" + owner
+		liveStreams.add(&liveStream{id: id, route: "/stream/sse", owner: "principal:" + owner, started: time.Now(),
+			cancel: func(error) { stopped[id] = true }})
+		t.Cleanup(func() { liveStreams.remove(id) })
+	}
+
+	h := authenticated(http.HandlerFunc(streamsHandler))
+	do := func(method, path, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("Authorization", "Bearer "+token)
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+		return rec
+	}
+	ids := func(token string) string {
+		var list []streamView
+		json.NewDecoder(do("GET", "/streams", token).Body).Decode(&list)
+		var out []string
+		for _, v := range list {
+			out = append(out, v.ID)
+		}
+		sort.Strings(out)
+		return strings.Join(out, ",")
+	}
+
+	if got := ids("ta"); got != "own-alice" {
+		t.Fatalf("alice sees %q", got)
+	}
+	if got := ids("tr"); got != "own-alice,own-bob" {
+		t.Fatalf("admin sees %q", got)
+	}
+	if rec := do("DELETE", "/streams/own-bob", "ta"); rec.Code != http.StatusNotFound || stopped["own-bob"] {
+		t.Fatalf("alice stopping bob's stream = %d", rec.Code)
+	}
+	if rec := do("DELETE", "/streams/own-alice", "ta"); rec.Code != http.StatusOK || !stopped["own-alice"] {
+		t.Fatalf("alice stopping her stream = %d", rec.Code)
+	}
+	if rec := do("DELETE", "/streams/own-bob", "tr"); rec.Code != http.StatusOK || !stopped["own-bob"] {
+		t.Fatalf("admin stopping bob's stream = %d", rec.Code)
+	}
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-learning/advanced/StreamingOutput/auth"
+)
+
+// ============ 认证与 CORS ============
+//
+// 配置了 -auth-keys（Bearer Token 密钥文件）或 -auth-secret（签名URL密钥）之后，
+// 除主页外的所有路由都需要认证，两者都没配置时保持公开。
+//
+// EventSource 不能设置请求头，浏览器端先由后端（持有 Bearer Token）调用
+//
+//	POST /auth/sign {"url":"/stream/pipeline?prompt=hi","ttl":"10m"}
+//
+// 换取一个带 exp、sub 和 sig 的签名URL，再交给 EventSource 使用。
+// 使用签名URL的请求以签发者（sub）的身份认证，限流、流的查看和停止、会话回放都归属于这个身份。
+//
+// CORS 按路由配置（-cors-origins），例如：
+//
+//	/stream/sse=*;/v1/chat/completions=https://app.example.com,https://admin.example.com;*=https://app.example.com
+//
+// 路由为 * 的条目作为其他路由的默认值；没有匹配的路由不返回任何 CORS 头。
+
+// authn 是当前生效的认证配置，setupAuth 根据配置初始化
+var authn = &auth.Authenticator{}
+
+// corsPolicy 是路由 -> 允许的来源，setupAuth 根据配置初始化
+var corsPolicy map[string][]string
+
+// admins 是 -auth-admins 中的身份，setupAuth 根据配置初始化
+var admins map[string]bool
+
+// setupAuth 加载密钥文件和CORS配置
+func setupAuth() error {
+	policy, err := parseCORSOrigins(cfg.CORSOrigins)
+	if err != nil {
+		return err
+	}
+	corsPolicy = policy
+
+	admins = make(map[string]bool)
+	for _, name := range strings.Split(cfg.AuthAdmins, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			admins[name] = true
+		}
+	}
+
+	a := &auth.Authenticator{Signer: auth.Signer{Secret: []byte(cfg.AuthSecret)}}
+	if cfg.AuthKeys != "" {
+		if a.Keys, err = auth.LoadKeys(cfg.AuthKeys); err != nil {
+			return fmt.Errorf("auth-keys: %w", err)
+		}
+	}
+	authn = a
+	return nil
+}
+
+type principalKey struct{}
+
+// principalFrom 返回认证通过的身份，未配置认证或 ctx 不属于请求时返回 false
+func principalFrom(ctx context.Context) (string, bool) {
+	p, ok := ctx.Value(principalKey{}).(string)
+	return p, ok
+}
+
+// isAdmin 报告请求的身份是否在 -auth-admins 中，只有通过 Bearer Token 认证的名称可以是管理员
+func isAdmin(ctx context.Context) bool {
+	principal, ok := principalFrom(ctx)
+	return ok && admins[principal]
+}
+
+// authenticated 要求请求带有效的 Bearer Token 或签名URL，并把身份放进 ctx
+func authenticated(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !authn.Enabled() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		principal, err := authn.Authenticate(r)
+		if err != nil {
+			loggerFrom(r.Context()).Warn("认证失败", "err", err)
+			challenge := `Bearer realm="streaming"`
+			if !errors.Is(err, auth.ErrMissing) {
+				challenge += `, error="invalid_token"`
+			}
+			w.Header().Set("WWW-Authenticate", challenge)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		logger := requestedLevel(loggerFrom(r.Context()).With("principal", principal), r)
+		ctx := context.WithValue(withLogger(r.Context(), logger), principalKey{}, principal)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// signRequest 是 POST /auth/sign 的请求体
+type signRequest struct {
+	URL string `json:"url"` // 要签名的路径和查询参数，例如 /stream/sse?x=1
+	TTL string `json:"ttl"` // 有效期，例如 10m，为空或超过 -signed-url-ttl 时使用 -signed-url-ttl
+}
+
+// signHandler 处理 POST /auth/sign：只接受 Bearer Token，签名URL不能用来签发新的签名URL
+func signHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	if len(authn.Signer.Secret) == 0 {
+		http.Error(w, "signed urls are disabled (set -auth-secret)", http.StatusNotFound)
+		return
+	}
+	token, _ := auth.BearerToken(r)
+	principal, ok := authn.Keys.Lookup(token)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="streaming"`)
+		http.Error(w, "a valid bearer token is required", http.StatusUnauthorized)
+		return
+	}
+
+	var req signRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<16)).Decode(&req); err != nil {
+		http.Error(w, "invalid JSON body: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil || u.IsAbs() || !strings.HasPrefix(u.Path, "/") {
+		http.Error(w, "url must be an absolute path such as /stream/sse?x=1", http.StatusBadRequest)
+		return
+	}
+	ttl := cfg.SignedURLTTL
+	if req.TTL != "" {
+		d, err := time.ParseDuration(req.TTL)
+		if err != nil || d <= 0 {
+			http.Error(w, "invalid ttl: "+req.TTL, http.StatusBadRequest)
+			return
+		}
+		ttl = min(d, cfg.SignedURLTTL)
+	}
+
+	expires := time.Now().Add(ttl)
+	authn.Signer.Sign(u, principal, expires) // 使用签名URL的请求以签发者的身份认证
+	loggerFrom(r.Context()).Info("签发签名URL", "principal", principal, "path", u.Path, "ttl", ttl)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"url":     u.String(),
+		"expires": expires.UTC().Format(time.RFC3339),
+	})
+}
+
+// parseCORSOrigins 解析 route=origin,origin;route=origin 格式的CORS配置
+func parseCORSOrigins(s string) (map[string][]string, error) {
+	policy := make(map[string][]string)
+	for _, entry := range strings.Split(s, ";") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		route, origins, ok := strings.Cut(entry, "=")
+		route = strings.TrimSpace(route)
+		if !ok || route == "" {
+			return nil, fmt.Errorf("cors-origins: %q should be route=origin[,origin]", entry)
+		}
+		for _, o := range strings.Split(origins, ",") {
+			o = strings.TrimSpace(o)
+			if o == "" {
+				continue
+			}
+			if o != "*" {
+				if u, err := url.Parse(o); err != nil || u.Scheme == "" || u.Host == "" || u.Path != "" {
+					return nil, fmt.Errorf("cors-origins: %q is not an origin like https://example.com", o)
+				}
+			}
+			policy[route] = append(policy[route], o)
+		}
+	}
+	return policy, nil
+}
+
+// allowedOrigin 返回 route 上允许 origin 时应写入 Access-Control-Allow-Origin 的值
+func allowedOrigin(route, origin string) (string, bool) {
+	origins, ok := corsPolicy[route]
+	if !ok {
+		origins = corsPolicy["*"]
+	}
+	for _, o := range origins {
+		if o == "*" {
+			return "*", true
+		}
+		if strings.EqualFold(o, origin) {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+// corsMaxAge 是预检结果的缓存时间
+const corsMaxAge = 10 * time.Minute
+
+// withCORS 按路由配置添加CORS响应头，并直接应答预检请求（预检不带认证信息，必须在认证之前处理）
+func withCORS(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		allow, ok := allowedOrigin(route, origin)
+		if ok {
+			w.Header().Set("Access-Control-Allow-Origin", allow)
+			w.Header().Set("Access-Control-Expose-Headers", "X-Request-ID, X-Stream-ID, X-Session-ID, Retry-After")
+		}
+
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			if ok {
+				w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+				w.Header().Set("Access-Control-Allow-Headers",
+					"Authorization, Content-Type, Last-Event-ID, X-Request-ID, X-Log-Level")
+				w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(corsMaxAge.Seconds())))
+			}
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
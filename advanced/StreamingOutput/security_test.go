@@ -0,0 +1,103 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-learning/advanced/StreamingOutput/auth"
+)
+
+// withAuth 在测试期间替换认证配置
+func withAuth(t *testing.T, a *auth.Authenticator) {
+	saved := authn
+	authn = a
+	t.Cleanup(func() { authn = saved })
+}
+
+// TestSignedURLRoundTrip 后端用 Bearer Token 换取签名URL，再用它以后端的身份访问流式接口
+func TestSignedURLRoundTrip(t *testing.T) {
+	keys, _ := auth.ParseKeys([]byte("backend-token backend\n"))
+	withAuth(t, &auth.Authenticator{Keys: keys, Signer: auth.Signer{Secret: []byte("s3cret")}})
+
+	var principal string
+	ok := authenticated(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ = principalFrom(r.Context())
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	rec := httptest.NewRecorder()
+	ok.ServeHTTP(rec, httptest.NewRequest("GET", "/stream/sse", nil))
+	if rec.Code != http.StatusUnauthorized || !strings.HasPrefix(rec.Header().Get("WWW-Authenticate"), "Bearer") {
+		t.Fatalf("anonymous request = %d %q", rec.Code, rec.Header().Get("WWW-Authenticate"))
+	}
+
+	req := httptest.NewRequest("POST", "/auth/sign", strings.NewReader(`{"url":"/stream/sse?x=1","ttl":"1m"}`))
+	req.Header.Set("Authorization", "Bearer backend-token")
+	rec = httptest.NewRecorder()
+	signHandler(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("sign = %d %s", rec.Code, rec.Body.String())
+	}
+	var signed struct{ URL string }
+	json.Unmarshal(rec.Body.Bytes(), &signed)
+
+	rec = httptest.NewRecorder()
+	ok.ServeHTTP(rec, httptest.NewRequest("GET", signed.URL, nil))
+	if rec.Code != http.StatusNoContent {
+		t.Fatalf("signed request %s = %d %s", signed.URL, rec.Code, rec.Body.String())
+	}
+	if principal != "backend" {
+		t.Fatalf("signed request principal = %q, want the signer backend", principal)
+	}
+
+	rec = httptest.NewRecorder()
+	ok.ServeHTTP(rec, httptest.NewRequest("GET", strings.Replace(signed.URL, "x=1", "x=2", 1), nil))
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("tampered signed request = %d", rec.Code)
+	}
+}
+
+func TestCORSPerRoute(t *testing.T) {
+	policy, err := parseCORSOrigins("/a=https://app.example.com; *=*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := corsPolicy
+	corsPolicy = policy
+	defer func() { corsPolicy = saved }()
+
+	h := func(route string) http.Handler {
+		return withCORS(route, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	}
+	tests := []struct {
+		route, origin, method string
+		want                  string
+	}{
+		{"/a", "https://app.example.com", "GET", "https://app.example.com"},
+		{"/a", "https://evil.example.com", "GET", ""},
+		{"/b", "https://evil.example.com", "GET", "*"},
+		{"/a", "https://app.example.com", "OPTIONS", "https://app.example.com"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(tt.method, tt.route, nil)
+		req.Header.Set("Origin", tt.origin)
+		if tt.method == "OPTIONS" {
+			req.Header.Set("Access-Control-Request-Method", "GET")
+		}
+		rec := httptest.NewRecorder()
+		h(tt.route).ServeHTTP(rec, req)
+		if got := rec.Header().Get("Access-Control-Allow-Origin"); got != tt.want {
+			t.Errorf("%s %s from %s: ACAO = %q, want %q", tt.method, tt.route, tt.origin, got, tt.want)
+		}
+		if tt.method == "OPTIONS" && (rec.Code != http.StatusNoContent || rec.Header().Get("Access-Control-Allow-Headers") == "") {
+			t.Errorf("preflight = %d %v", rec.Code, rec.Header())
+		}
+	}
+
+	if _, err := parseCORSOrigins("/a=example.com"); err == nil {
+		t.Error("origin without scheme accepted")
+	}
+}
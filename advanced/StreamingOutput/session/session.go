@@ -0,0 +1,470 @@
+// Package session 录制流式响应并按原始时序逐字节回放，用于复现与时序有关的前端问题
+//
+// 会话文件为 JSON Lines：第一行是请求和响应头，之后每次 Write / Flush 一行，最后一行标记结束：
+//
+//	{"version":1,"method":"GET","url":"/stream/pipeline?prompt=hi","status":200,"header":{...},"started":"..."}
+//	{"at_us":1520,"text":"你好"}
+//	{"at_us":1533,"flush":true}
+//	{"at_us":2048,"data":"/w=="}                      不是合法 UTF-8 的块以 base64 保存
+//	{"at_us":9001,"end":true,"trailer":{...}}
+//
+// at_us 是距离响应开始的微秒数。回放时按 at_us（可按倍速缩放）写出同样的字节，
+// 并在同样的位置刷新，客户端看到的分块与录制时一致。
+package session
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// Version 是会话文件的格式版本
+const Version = 1
+
+// Ext 是会话文件的扩展名
+const Ext = ".jsonl"
+
+// ErrNotFound 表示会话不存在
+var ErrNotFound = errors.New("session: not found")
+
+// Meta 是会话文件的第一行
+type Meta struct {
+	Version int         `json:"version"`
+	Method  string      `json:"method"`
+	URL     string      `json:"url"`
+	Owner   string      `json:"owner,omitempty"` // 发起请求的客户端，见 Options.Owner
+	Status  int         `json:"status"`
+	Header  http.Header `json:"header"`
+	Started time.Time   `json:"started"`
+}
+
+// Frame 是一次写入、一次刷新或结束标记
+type Frame struct {
+	At      time.Duration // 距离响应开始的时间
+	Data    []byte        // 写入的字节，Flush / End 时为空
+	Flush   bool
+	End     bool
+	Trailer http.Header // End 时的 trailer
+}
+
+// frameJSON 是 Frame 在文件中的形式：文本块直接保存，便于阅读
+type frameJSON struct {
+	AtUS    int64       `json:"at_us"`
+	Text    *string     `json:"text,omitempty"`
+	Data    []byte      `json:"data,omitempty"`
+	Flush   bool        `json:"flush,omitempty"`
+	End     bool        `json:"end,omitempty"`
+	Trailer http.Header `json:"trailer,omitempty"`
+}
+
+func (f Frame) MarshalJSON() ([]byte, error) {
+	j := frameJSON{AtUS: f.At.Microseconds(), Flush: f.Flush, End: f.End, Trailer: f.Trailer}
+	if len(f.Data) > 0 {
+		if utf8.Valid(f.Data) {
+			s := string(f.Data)
+			j.Text = &s
+		} else {
+			j.Data = f.Data
+		}
+	}
+	return json.Marshal(j)
+}
+
+func (f *Frame) UnmarshalJSON(b []byte) error {
+	var j frameJSON
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*f = Frame{At: time.Duration(j.AtUS) * time.Microsecond, Data: j.Data, Flush: j.Flush, End: j.End, Trailer: j.Trailer}
+	if j.Text != nil {
+		f.Data = []byte(*j.Text)
+	}
+	return nil
+}
+
+// Session 是读入内存的会话
+type Session struct {
+	Meta   Meta
+	Frames []Frame
+}
+
+// Complete 报告会话是否有结束标记；没有说明录制时处理器没有正常返回（例如进程崩溃）
+func (s *Session) Complete() bool {
+	return len(s.Frames) > 0 && s.Frames[len(s.Frames)-1].End
+}
+
+// ============ 录制 ============
+
+// Options 配置录制中间件
+type Options struct {
+	Dir        string                       // 会话文件目录
+	Name       func(r *http.Request) string // 会话名称（不含扩展名），为空时随机生成
+	Owner      func(r *http.Request) string // 会话的所有者，写入 Meta.Owner，nil 时为空
+	StripQuery []string                     // 录制前从 URL 中删除的查询参数，如签名URL的 sig 和 exp
+	Skip       func(r *http.Request) bool   // 返回 true 的请求不录制
+	Now        func() time.Time             // 测试时替换时钟，nil 时为 time.Now
+	Log        func(name string, err error) // 录制失败时调用，nil 时忽略
+}
+
+// Handler 把 next 的每个响应录制到 Dir 中，响应头 X-Session-ID 返回会话名称
+// 录制的是 next 写出的原始字节，应放在压缩等改变字节的中间件之内
+func Handler(next http.Handler, opts Options) http.Handler {
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if opts.Skip != nil && opts.Skip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		name := ""
+		if opts.Name != nil {
+			name = opts.Name(r)
+		}
+		if !ValidName(name) {
+			name = opts.Now().UTC().Format("20060102T150405.000000000")
+			name = strings.ReplaceAll(name, ".", "-")
+		}
+		f, err := os.Create(filepath.Join(opts.Dir, name+Ext))
+		if err != nil {
+			if opts.Log != nil {
+				opts.Log(name, err)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Set("X-Session-ID", name)
+		meta := Meta{Version: Version, Method: r.Method, URL: stripQuery(r.URL, opts.StripQuery)}
+		if opts.Owner != nil {
+			meta.Owner = opts.Owner(r)
+		}
+		rw := &recordingWriter{
+			ResponseWriter: w,
+			now:            opts.Now,
+			start:          opts.Now(),
+			file:           f,
+			buf:            bufio.NewWriter(f),
+			meta:           meta,
+		}
+		defer func() {
+			if err := rw.finish(); err != nil && opts.Log != nil {
+				opts.Log(name, err)
+			}
+		}()
+		next.ServeHTTP(rw, r)
+	})
+}
+
+// stripQuery 返回删除了 params 之后的路径和查询参数
+func stripQuery(u *url.URL, params []string) string {
+	q := u.Query()
+	found := false
+	for _, p := range params {
+		if q.Has(p) {
+			q.Del(p)
+			found = true
+		}
+	}
+	if !found {
+		return u.RequestURI()
+	}
+	stripped := *u
+	stripped.RawQuery = q.Encode()
+	return stripped.RequestURI()
+}
+
+// recordingWriter 在写给客户端的同时把每次 Write / Flush 记录到会话文件
+type recordingWriter struct {
+	http.ResponseWriter
+	now   func() time.Time
+	start time.Time
+
+	mu      sync.Mutex // 处理器和它的刷新定时器可能并发调用 Write / Flush
+	file    *os.File
+	buf     *bufio.Writer
+	meta    Meta
+	started bool // 是否已写出 Meta
+	err     error
+}
+
+// begin 在响应头发出时记录 Meta
+func (rw *recordingWriter) begin(status int) {
+	if rw.started {
+		return
+	}
+	rw.started = true
+	rw.meta.Status = status
+	rw.meta.Header = rw.ResponseWriter.Header().Clone()
+	rw.meta.Started = rw.start
+	rw.encode(rw.meta)
+}
+
+func (rw *recordingWriter) encode(v any) {
+	if rw.err != nil {
+		return
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		rw.err = err
+		return
+	}
+	rw.buf.Write(data)
+	rw.err = rw.buf.WriteByte('\n')
+}
+
+func (rw *recordingWriter) frame(f Frame) {
+	f.At = rw.now().Sub(rw.start)
+	rw.encode(f)
+}
+
+func (rw *recordingWriter) WriteHeader(status int) {
+	rw.mu.Lock()
+	rw.begin(status)
+	rw.mu.Unlock()
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *recordingWriter) Write(p []byte) (int, error) {
+	rw.mu.Lock()
+	rw.begin(http.StatusOK)
+	rw.frame(Frame{Data: p})
+	rw.mu.Unlock()
+	return rw.ResponseWriter.Write(p)
+}
+
+func (rw *recordingWriter) Flush() {
+	rw.mu.Lock()
+	rw.begin(http.StatusOK)
+	rw.frame(Frame{Flush: true})
+	rw.mu.Unlock()
+	http.NewResponseController(rw.ResponseWriter).Flush()
+}
+
+// Unwrap 让 http.ResponseController 找到底层的 ResponseWriter
+func (rw *recordingWriter) Unwrap() http.ResponseWriter { return rw.ResponseWriter }
+
+// finish 记录 trailer 和结束标记并关闭文件
+func (rw *recordingWriter) finish() error {
+	rw.mu.Lock()
+	defer rw.mu.Unlock()
+	rw.begin(http.StatusOK)
+	end := Frame{End: true}
+	h := rw.ResponseWriter.Header()
+	for _, k := range h.Values("Trailer") {
+		for _, name := range strings.Split(k, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if v := h.Values(name); len(v) > 0 {
+				if end.Trailer == nil {
+					end.Trailer = http.Header{}
+				}
+				end.Trailer[name] = v
+			}
+		}
+	}
+	rw.frame(end)
+	if err := rw.buf.Flush(); err != nil && rw.err == nil {
+		rw.err = err
+	}
+	if err := rw.file.Close(); err != nil && rw.err == nil {
+		rw.err = err
+	}
+	return rw.err
+}
+
+// ============ 读取与回放 ============
+
+// ValidName 只接受字母、数字、- 和 _，避免路径穿越
+func ValidName(name string) bool {
+	if name == "" || len(name) > 128 {
+		return false
+	}
+	return !strings.ContainsFunc(name, func(c rune) bool {
+		return !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_')
+	})
+}
+
+// Load 读取 dir 中名为 name 的会话
+func Load(dir, name string) (*Session, error) {
+	if !ValidName(name) {
+		return nil, ErrNotFound
+	}
+	f, err := os.Open(filepath.Join(dir, name+Ext))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return Read(f)
+}
+
+// Read 从 r 读取会话
+func Read(r io.Reader) (*Session, error) {
+	dec := json.NewDecoder(r)
+	var s Session
+	if err := dec.Decode(&s.Meta); err != nil {
+		return nil, fmt.Errorf("session: read header: %w", err)
+	}
+	if s.Meta.Version != Version {
+		return nil, fmt.Errorf("session: unsupported version %d", s.Meta.Version)
+	}
+	for {
+		var f Frame
+		err := dec.Decode(&f)
+		if err == io.EOF {
+			return &s, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("session: read frame %d: %w", len(s.Frames)+1, err)
+		}
+		s.Frames = append(s.Frames, f)
+	}
+}
+
+// Info 描述目录中的一个会话
+type Info struct {
+	Name     string    `json:"name"`
+	Owner    string    `json:"owner,omitempty"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+}
+
+// List 按名称返回 dir 中的所有会话，所有者取自每个会话文件的第一行
+func List(dir string) ([]Info, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var infos []Info
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), Ext)
+		if !ok || e.IsDir() || !ValidName(name) {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue // 刚被删除
+		}
+		infos = append(infos, Info{Name: name, Owner: readOwner(filepath.Join(dir, e.Name())), Size: fi.Size(), Modified: fi.ModTime()})
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Name < infos[j].Name })
+	return infos, nil
+}
+
+// readOwner 只读取会话文件的第一行，返回其中的所有者
+func readOwner(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	var meta Meta
+	json.NewDecoder(f).Decode(&meta)
+	return meta.Owner
+}
+
+// Retention 是会话文件的保留策略，零值字段表示不限制
+type Retention struct {
+	MaxCount int           // 最多保留的会话数
+	MaxBytes int64         // 所有会话文件的总大小上限
+	MaxAge   time.Duration // 会话文件（按最后修改时间）的最长保留时间
+}
+
+// Prune 按保留策略从旧到新删除 dir 中的会话，返回删除的数量
+func Prune(dir string, keep Retention, now time.Time) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	var files []os.FileInfo
+	var total int64
+	for _, e := range entries {
+		name, ok := strings.CutSuffix(e.Name(), Ext)
+		if !ok || e.IsDir() || !ValidName(name) {
+			continue
+		}
+		if fi, err := e.Info(); err == nil {
+			files = append(files, fi)
+			total += fi.Size()
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+
+	removed := 0
+	for _, fi := range files {
+		tooMany := keep.MaxCount > 0 && len(files)-removed > keep.MaxCount
+		tooBig := keep.MaxBytes > 0 && total > keep.MaxBytes
+		tooOld := keep.MaxAge > 0 && now.Sub(fi.ModTime()) > keep.MaxAge
+		if !tooMany && !tooBig && !tooOld {
+			break
+		}
+		if err := os.Remove(filepath.Join(dir, fi.Name())); err != nil && !os.IsNotExist(err) {
+			return removed, err
+		}
+		total -= fi.Size()
+		removed++
+	}
+	return removed, nil
+}
+
+// Replay 把会话写到 w：同样的状态码、响应头、字节、刷新位置和 trailer
+// speed 为回放倍速（2 表示两倍速），<=0 时不等待，立即写出全部内容。
+// 已经由外层中间件设置的响应头（如 X-Request-ID）不会被覆盖。
+func (s *Session) Replay(ctx context.Context, w http.ResponseWriter, speed float64) error {
+	h := w.Header()
+	for k, v := range s.Meta.Header {
+		if _, ok := h[k]; ok || k == "Content-Length" || k == "Date" {
+			continue
+		}
+		h[k] = v
+	}
+	rc := http.NewResponseController(w)
+	w.WriteHeader(s.Meta.Status)
+
+	// 每一帧都相对开始时间计算等待时长，不会因为写入耗时而累积误差
+	start := time.Now()
+	timer := time.NewTimer(0)
+	defer timer.Stop()
+	<-timer.C
+	for _, f := range s.Frames {
+		if speed > 0 {
+			if wait := time.Duration(float64(f.At)/speed) - time.Since(start); wait > 0 {
+				timer.Reset(wait)
+				select {
+				case <-timer.C:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+			}
+		}
+		switch {
+		case f.End:
+			for k, v := range f.Trailer {
+				h[k] = v
+			}
+		case f.Flush:
+			if err := rc.Flush(); err != nil {
+				return err
+			}
+		default:
+			if _, err := w.Write(f.Data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
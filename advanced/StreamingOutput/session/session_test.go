@@ -0,0 +1,168 @@
+package session
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeClock 每次读取前进 10ms
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) Now() time.Time {
+	c.t = c.t.Add(10 * time.Millisecond)
+	return c.t
+}
+
+// streamHandler 写出两个块（第二块是无效 UTF-8）并设置 trailer
+func streamHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Trailer", "X-Stream-Status")
+	io.WriteString(w, "你好")
+	w.(http.Flusher).Flush()
+	w.Write([]byte{0xff, '\n'})
+	w.(http.Flusher).Flush()
+	w.Header().Set("X-Stream-Status", "complete")
+}
+
+func record(t *testing.T, dir string) {
+	t.Helper()
+	clock := &fakeClock{t: time.Unix(0, 0)}
+	h := Handler(http.HandlerFunc(streamHandler), Options{
+		Dir:        dir,
+		Name:       func(r *http.Request) string { return "s1" },
+		Owner:      func(r *http.Request) string { return "alice" },
+		StripQuery: []string{"sig", "exp"},
+		Now:        clock.Now,
+	})
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest("GET", "/stream?x=1&exp=99&sig=secret", nil))
+	if rec.Header().Get("X-Session-ID") != "s1" || rec.Body.String() != "你好\xff\n" {
+		t.Fatalf("recorded response = %q, header %v", rec.Body.String(), rec.Header())
+	}
+}
+
+func TestRecordAndLoad(t *testing.T) {
+	dir := t.TempDir()
+	record(t, dir)
+
+	s, err := Load(dir, "s1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Meta.URL != "/stream?x=1" || s.Meta.Owner != "alice" || s.Meta.Status != 200 || s.Meta.Header.Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Fatalf("meta = %+v", s.Meta)
+	}
+	want := []Frame{
+		{At: 10 * time.Millisecond, Data: []byte("你好")},
+		{At: 20 * time.Millisecond, Flush: true},
+		{At: 30 * time.Millisecond, Data: []byte{0xff, '\n'}},
+		{At: 40 * time.Millisecond, Flush: true},
+		{At: 50 * time.Millisecond, End: true, Trailer: http.Header{"X-Stream-Status": {"complete"}}},
+	}
+	if len(s.Frames) != len(want) {
+		t.Fatalf("frames = %+v", s.Frames)
+	}
+	for i, f := range s.Frames {
+		w := want[i]
+		if f.At != w.At || string(f.Data) != string(w.Data) || f.Flush != w.Flush || f.End != w.End || f.Trailer.Get("X-Stream-Status") != w.Trailer.Get("X-Stream-Status") {
+			t.Errorf("frame %d = %+v, want %+v", i, f, w)
+		}
+	}
+	if !s.Complete() {
+		t.Error("session should be complete")
+	}
+
+	if infos, err := List(dir); err != nil || len(infos) != 1 || infos[0].Name != "s1" || infos[0].Owner != "alice" || infos[0].Size == 0 {
+		t.Errorf("List = %+v, %v", infos, err)
+	}
+	if _, err := Load(dir, "../s1"); err != ErrNotFound {
+		t.Errorf("Load with path traversal = %v, want ErrNotFound", err)
+	}
+}
+
+// flushRecorder 记录每次刷新时已写出的内容
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+	flushed []string
+}
+
+func (r *flushRecorder) Flush() {
+	r.flushed = append(r.flushed, r.Body.String())
+	r.ResponseRecorder.Flush()
+}
+
+func TestReplay(t *testing.T) {
+	dir := t.TempDir()
+	record(t, dir)
+	s, _ := Load(dir, "s1")
+
+	rec := &flushRecorder{ResponseRecorder: httptest.NewRecorder()}
+	rec.Header().Set("X-Session-ID", "replay") // 外层中间件设置的响应头保留
+	start := time.Now()
+	if err := s.Replay(context.Background(), rec, 1); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("replay took %v, want at least the recorded 50ms", elapsed)
+	}
+	if strings.Join(rec.flushed, "|") != "你好|你好\xff\n" {
+		t.Errorf("flush boundaries = %q", rec.flushed)
+	}
+	resp := rec.Result()
+	if resp.Header.Get("X-Session-ID") != "replay" || resp.Header.Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Errorf("header = %v", resp.Header)
+	}
+	if resp.Trailer.Get("X-Stream-Status") != "complete" {
+		t.Errorf("trailer = %v", resp.Trailer)
+	}
+
+	// 倍速为 0 时立即写完；取消的 Context 中止等待
+	if err := s.Replay(context.Background(), httptest.NewRecorder(), 0); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := s.Replay(ctx, httptest.NewRecorder(), 1); err != context.Canceled {
+		t.Errorf("Replay with canceled ctx = %v", err)
+	}
+}
+
+func TestPrune(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for i, name := range []string{"a", "b", "c", "d"} {
+		path := filepath.Join(dir, name+Ext)
+		os.WriteFile(path, []byte(strings.Repeat("x", 10)), 0o644)
+		mtime := now.Add(time.Duration(i-4) * time.Hour) // a 最旧，d 最新
+		os.Chtimes(path, mtime, mtime)
+	}
+	os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o644) // 不是会话文件，不受影响
+
+	names := func() string {
+		infos, _ := List(dir)
+		var out []string
+		for _, info := range infos {
+			out = append(out, info.Name)
+		}
+		return strings.Join(out, ",")
+	}
+	if n, err := Prune(dir, Retention{MaxAge: 3*time.Hour + time.Minute}, now); n != 1 || err != nil || names() != "b,c,d" {
+		t.Fatalf("prune by age = %d, %v; left %s", n, err, names())
+	}
+	if n, err := Prune(dir, Retention{MaxBytes: 25}, now); n != 1 || err != nil || names() != "c,d" {
+		t.Fatalf("prune by size = %d, %v; left %s", n, err, names())
+	}
+	if n, err := Prune(dir, Retention{MaxCount: 1}, now); n != 1 || err != nil || names() != "d" {
+		t.Fatalf("prune by count = %d, %v; left %s", n, err, names())
+	}
+	if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-learning/advanced/StreamingOutput/auth"
+	"go-learning/advanced/StreamingOutput/session"
+)
+
+// ============ 会话录制与回放 ============
+//
+// 配置 -record-dir 后，流式路由的每个响应都被录制为一个会话文件，
+// 名称即流ID，通过 X-Session-ID 响应头返回。前端遇到与时序有关的问题时，
+// 用同一个会话反复回放即可稳定复现：
+//
+//	GET /sessions                 列出已录制的会话
+//	GET /sessions/{id}?speed=2    按原始时序（或倍速）逐字节回放，speed=0 立即写出全部内容
+//
+// 录制的是压缩之前的字节，回放时仍按客户端的 Accept-Encoding 压缩。
+// 会话记录发起请求的客户端（见 clientKey），只有它自己和 -auth-admins 中的管理员可以列出和回放；
+// 签名URL的 sig、exp 和 sub 参数不会写入会话文件。
+// 会话按 -record-max-sessions / -record-max-bytes / -record-max-age 定期清理。
+
+const sessionsRoute = "/sessions/"
+
+// setupSessions 创建录制目录并开始按保留策略清理
+func setupSessions() error {
+	if cfg.RecordDir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(cfg.RecordDir, 0o755); err != nil {
+		return err
+	}
+	go pruneSessions()
+	return nil
+}
+
+// pruneSessions 启动时和之后每分钟按保留策略删除旧会话
+func pruneSessions() {
+	logger := slog.With("component", "session")
+	keep := session.Retention{MaxCount: cfg.RecordMaxSessions, MaxBytes: cfg.RecordMaxBytes, MaxAge: cfg.RecordMaxAge}
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		removed, err := session.Prune(cfg.RecordDir, keep, time.Now())
+		if err != nil {
+			logger.Warn("清理会话失败", "err", err)
+		}
+		if removed > 0 {
+			logger.Info("清理会话", "removed", removed)
+		}
+		<-ticker.C
+	}
+}
+
+// recorded 在配置了录制目录时录制路由的响应，回放路由本身不录制
+func recorded(route string, h http.Handler) http.Handler {
+	if cfg.RecordDir == "" || route == sessionsRoute {
+		return h
+	}
+	return session.Handler(h, session.Options{
+		Dir:        cfg.RecordDir,
+		Name:       func(r *http.Request) string { return streamID(r.Context()) },
+		Owner:      clientKey,
+		StripQuery: []string{auth.ParamSignature, auth.ParamExpires, auth.ParamSubject},
+		Log: func(name string, err error) {
+			slog.Warn("录制失败", "component", "session", "session", name, "err", err)
+		},
+	})
+}
+
+// sessionsHandler 处理 GET /sessions 和 GET /sessions/{id}
+func sessionsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if cfg.RecordDir == "" {
+		http.Error(w, "session recording is disabled (-record-dir)", http.StatusNotFound)
+		return
+	}
+
+	// 管理员可以看到所有会话；其他客户端的会话对非管理员来说不存在
+	owner := clientKey(r)
+	admin := isAdmin(r.Context())
+
+	id := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, "/sessions"), "/")
+	if id == "" {
+		infos, err := session.List(cfg.RecordDir)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		names := []string{}
+		for _, info := range infos {
+			if admin || info.Owner == owner {
+				names = append(names, info.Name)
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(names)
+		return
+	}
+
+	speed := 1.0
+	if s := r.URL.Query().Get("speed"); s != "" {
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil || v < 0 {
+			http.Error(w, "speed must be a non-negative number", http.StatusBadRequest)
+			return
+		}
+		speed = v
+	}
+
+	s, err := session.Load(cfg.RecordDir, id)
+	if err == nil && !admin && s.Meta.Owner != owner {
+		err = session.ErrNotFound
+	}
+	if errors.Is(err, session.ErrNotFound) {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	ctx := r.Context()
+	logger := loggerFrom(ctx).With("session", id)
+	logger.Info("回放会话", "url", s.Meta.URL, "frames", len(s.Frames), "speed", speed, "complete", s.Complete())
+	if err := s.Replay(ctx, w, speed); err != nil {
+		logger.Warn("回放中断", "err", err)
+	}
+}
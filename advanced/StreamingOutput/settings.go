@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	"go-learning/advanced/StreamingOutput/config"
+	"go-learning/advanced/StreamingOutput/eventlog"
+	"go-learning/advanced/StreamingOutput/flush"
+	"go-learning/advanced/StreamingOutput/hub"
+)
+
+// ============ 服务器配置 ============
+//
+// 优先级：默认值 < 配置文件（-config 或 STREAM_CONFIG）< 环境变量（STREAM_*）< 命令行参数
+// 例如调整延迟场景而不用重新编译：
+//
+//	STREAM_SSE_DELAY=200ms go run . -token-delay 20ms
+//
+// 当前生效的值可以通过 /config 查看。
+
+// Config 是服务器的全部可调参数
+type Config struct {
+	Addr string `config:"addr" usage:"监听地址"`
+
+	// 各个演示处理器的消息数量与延迟
+	SSECount   int           `config:"sse-count" usage:"/stream/sse 发送的消息数"`
+	SSEDelay   time.Duration `config:"sse-delay" usage:"/stream/sse 的消息间隔"`
+	TextDelay  time.Duration `config:"text-delay" usage:"/stream/text 的块间隔"`
+	JSONCount  int           `config:"json-count" usage:"/stream/json 发送的消息数"`
+	JSONDelay  time.Duration `config:"json-delay" usage:"/stream/json 的消息间隔"`
+	TokenDelay time.Duration `config:"token-delay" usage:"echo 生成器每个token的生成延迟"`
+	SendDelay  time.Duration `config:"send-delay" usage:"pipeline 消费者每个token的模拟传输延迟"`
+
+	// 刷新合并
+	FlushPolicy string `config:"flush-policy" usage:"/stream/pipeline 的刷新策略: immediate / bytes:N / latency:D / adaptive[:D]（单个请求可用 ?flush= 覆盖）"`
+
+	// 生产者
+	PipelineBuffer int     `config:"pipeline-buffer" usage:"generateWithPipeline 通道的缓冲区大小"`
+	Generator      string  `config:"generator" usage:"默认生成器: echo / replay / openai"`
+	ReplayFile     string  `config:"replay-file" usage:"replay 生成器使用的转录文件（JSON Lines）"`
+	ReplaySpeed    float64 `config:"replay-speed" usage:"replay 生成器的回放倍速"`
+	Upstream       string  `config:"upstream" usage:"openai 生成器代理的上游地址"`
+	UpstreamModel  string  `config:"upstream-model" usage:"openai 生成器默认使用的模型名"`
+	UpstreamKey    string  `config:"upstream-key" usage:"openai 生成器的 API Key（可选）" secret:"true"`
+
+	// 广播与发布/订阅
+	BroadcastBuffer int    `config:"broadcast-buffer" usage:"广播/订阅中每个订阅者的缓冲区大小"`
+	BroadcastPolicy string `config:"broadcast-policy" usage:"慢消费者策略: drop-oldest / drop-newest / disconnect"`
+	TopicRetain     int    `config:"topic-retain" usage:"每个主题为迟到的订阅者保留的最近消息数"`
+
+	// 持久化事件日志
+	EventLog         string        `config:"event-log" usage:"事件日志目录，为空时 /publish 的消息只保存在内存中，SSE 流也不写入流日志"`
+	EventLogSync     string        `config:"event-log-sync" usage:"刷盘策略: interval / always / never"`
+	EventLogSegment  int64         `config:"event-log-segment" usage:"单个段文件的最大字节数"`
+	EventLogMaxBytes int64         `config:"event-log-max-bytes" usage:"事件日志的总大小上限，0 表示不限制"`
+	EventLogMaxAge   time.Duration `config:"event-log-max-age" usage:"事件的最长保留时间，0 表示不限制"`
+	EventLogReplay   int           `config:"event-log-replay" usage:"断线重连时最多从事件日志回放的消息数，超出时拒绝续传"`
+
+	// 限流
+	RateLimit           float64 `config:"rate-limit" usage:"每个客户端每秒允许的流式请求数，0 表示不限制"`
+	RateBurst           int     `config:"rate-burst" usage:"令牌桶容量，允许的突发请求数，0 时为 1"`
+	MaxStreamsPerClient int     `config:"max-streams-per-client" usage:"每个客户端同时进行的流数，0 表示不限制"`
+
+	// 认证与CORS
+	AuthKeys     string        `config:"auth-keys" usage:"Bearer Token 密钥文件（每行: token [名称]），为空时不接受 Bearer Token"`
+	AuthSecret   string        `config:"auth-secret" usage:"签名URL的 HMAC 密钥，为空时不接受签名URL" secret:"true"`
+	AuthAdmins   string        `config:"auth-admins" usage:"管理员身份（-auth-keys 中的名称），逗号分隔；可以查看和停止其他客户端的流"`
+	SignedURLTTL time.Duration `config:"signed-url-ttl" usage:"/auth/sign 签发的URL的最长有效期"`
+	CORSOrigins  string        `config:"cors-origins" usage:"按路由允许的来源: route=origin,origin;route=*（* 路由为默认值）"`
+
+	// 日志
+	LogFormat string `config:"log-format" usage:"日志格式: text / json"`
+	LogLevel  string `config:"log-level" usage:"日志级别: debug / info / warn / error（通过认证的请求可用 X-Log-Level 头调低）"`
+	AccessLog bool   `config:"access-log" usage:"每个请求结束时输出一行访问日志（状态码、字节数、耗时）"`
+
+	// 优雅关闭
+	ShutdownTimeout time.Duration `config:"shutdown-timeout" usage:"收到SIGINT/SIGTERM后等待流结束的最长时间"`
+	ShutdownRetry   time.Duration `config:"shutdown-retry" usage:"关闭时建议SSE客户端重连的间隔"`
+
+	// 录制
+	RecordDir         string        `config:"record-dir" usage:"把流式响应录制为会话文件的目录，为空时不录制；通过 /sessions/{id} 回放"`
+	RecordMaxSessions int           `config:"record-max-sessions" usage:"最多保留的会话数，0 表示不限制"`
+	RecordMaxBytes    int64         `config:"record-max-bytes" usage:"会话文件的总大小上限，0 表示不限制"`
+	RecordMaxAge      time.Duration `config:"record-max-age" usage:"会话的最长保留时间，0 表示不限制"`
+
+	// 压缩
+	Compress        bool   `config:"compress" usage:"按 Accept-Encoding 对流式响应进行 gzip/deflate 压缩"`
+	CompressMinSize int    `config:"compress-min-size" usage:"小于该字节数的非流式响应不压缩"`
+	CompressExclude string `config:"compress-exclude" usage:"不压缩的路由，逗号分隔，如 /stream/sse,/v1/chat/completions"`
+}
+
+// defaultConfig 返回与原先写死的常量一致的默认配置
+func defaultConfig() Config {
+	return Config{
+		Addr:              ":8080",
+		SSECount:          10,
+		SSEDelay:          1 * time.Second,
+		TextDelay:         500 * time.Millisecond,
+		JSONCount:         5,
+		JSONDelay:         1 * time.Second,
+		TokenDelay:        100 * time.Millisecond,
+		SendDelay:         50 * time.Millisecond,
+		FlushPolicy:       "immediate",
+		PipelineBuffer:    5,
+		Generator:         "echo",
+		ReplaySpeed:       1,
+		Upstream:          "http://localhost:11434",
+		BroadcastBuffer:   16,
+		BroadcastPolicy:   "drop-oldest",
+		TopicRetain:       10,
+		EventLogSync:      "interval",
+		EventLogSegment:   4 << 20,
+		EventLogMaxBytes:  256 << 20,
+		EventLogMaxAge:    24 * time.Hour,
+		EventLogReplay:    1000,
+		RecordMaxSessions: 1000,
+		RecordMaxBytes:    256 << 20,
+		RecordMaxAge:      24 * time.Hour,
+		SignedURLTTL:      time.Hour,
+		CORSOrigins:       "/stream/sse=*;/subscribe/=*",
+		LogFormat:         "text",
+		LogLevel:          "info",
+		AccessLog:         true,
+		ShutdownTimeout:   10 * time.Second,
+		ShutdownRetry:     5 * time.Second,
+		Compress:          true,
+		CompressMinSize:   256,
+	}
+}
+
+// cfg 是当前生效的配置，main 启动时加载，测试中使用默认值
+var cfg = defaultConfig()
+
+// cfgFields 记录每一项的来源，供 /config 输出
+var cfgFields []config.Field
+
+// Validate 检查配置是否合法，返回所有问题
+func (c *Config) Validate() error {
+	var errs []error
+	positive := []struct {
+		key string
+		v   int
+	}{
+		{"sse-count", c.SSECount},
+		{"json-count", c.JSONCount},
+		{"pipeline-buffer", c.PipelineBuffer},
+		{"broadcast-buffer", c.BroadcastBuffer},
+		{"compress-min-size", c.CompressMinSize},
+	}
+	for _, p := range positive {
+		if p.v <= 0 {
+			errs = append(errs, fmt.Errorf("%s must be > 0, got %d", p.key, p.v))
+		}
+	}
+	durations := []struct {
+		key string
+		d   time.Duration
+	}{
+		{"sse-delay", c.SSEDelay},
+		{"text-delay", c.TextDelay},
+		{"json-delay", c.JSONDelay},
+		{"token-delay", c.TokenDelay},
+		{"send-delay", c.SendDelay},
+		{"event-log-max-age", c.EventLogMaxAge},
+		{"record-max-age", c.RecordMaxAge},
+		{"shutdown-timeout", c.ShutdownTimeout},
+		{"shutdown-retry", c.ShutdownRetry},
+		{"signed-url-ttl", c.SignedURLTTL},
+	}
+	for _, d := range durations {
+		if d.d < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative, got %v", d.key, d.d))
+		}
+	}
+	if c.Addr == "" {
+		errs = append(errs, errors.New("addr must not be empty"))
+	}
+	if c.ReplaySpeed <= 0 {
+		errs = append(errs, fmt.Errorf("replay-speed must be > 0, got %v", c.ReplaySpeed))
+	}
+	if c.RateLimit < 0 {
+		errs = append(errs, fmt.Errorf("rate-limit must not be negative, got %v", c.RateLimit))
+	}
+	if c.RateBurst < 0 {
+		errs = append(errs, fmt.Errorf("rate-burst must not be negative, got %d", c.RateBurst))
+	}
+	if c.MaxStreamsPerClient < 0 {
+		errs = append(errs, fmt.Errorf("max-streams-per-client must not be negative, got %d", c.MaxStreamsPerClient))
+	}
+	if c.RecordMaxSessions < 0 || c.RecordMaxBytes < 0 {
+		errs = append(errs, fmt.Errorf("record-max-sessions and record-max-bytes must not be negative, got %d and %d",
+			c.RecordMaxSessions, c.RecordMaxBytes))
+	}
+	if c.TopicRetain < 0 {
+		errs = append(errs, fmt.Errorf("topic-retain must not be negative, got %d", c.TopicRetain))
+	}
+	if c.EventLogReplay <= 0 {
+		errs = append(errs, fmt.Errorf("event-log-replay must be > 0, got %d", c.EventLogReplay))
+	}
+	if _, err := hub.ParsePolicy(c.BroadcastPolicy); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := flush.ParsePolicy(c.FlushPolicy); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := eventlog.ParseSyncPolicy(c.EventLogSync); err != nil {
+		errs = append(errs, err)
+	}
+	if _, err := parseCORSOrigins(c.CORSOrigins); err != nil {
+		errs = append(errs, err)
+	}
+	if c.LogFormat != "text" && c.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("log-format must be text or json, got %q", c.LogFormat))
+	}
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+// loadConfig 从命令行参数、环境变量和配置文件加载配置并校验
+func loadConfig(args []string) error {
+	c := defaultConfig()
+	loader := config.Loader{EnvPrefix: "STREAM_", LookupEnv: os.LookupEnv, ReadFile: os.ReadFile}
+	fields, err := loader.Load(&c, flag.CommandLine, args)
+	if err != nil {
+		return err
+	}
+	if err := c.Validate(); err != nil {
+		return fmt.Errorf("invalid config:\n%w", err)
+	}
+	cfg, cfgFields = c, fields
+	return nil
+}
+
+// configHandler 处理 /config：输出当前生效的配置及每一项的来源（密钥已隐藏）
+func configHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(cfgFields)
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"go-learning/advanced/StreamingOutput/event"
+	"go-learning/advanced/StreamingOutput/format"
+	"go-learning/advanced/StreamingOutput/sse"
+)
+
+// ============ 优雅关闭：排空正在进行的流 ============
+//
+// 收到 SIGINT/SIGTERM 后：
+//  1. 停止接受新连接和新的流（新请求返回 503）
+//  2. SSE 客户端收到 event: shutdown 及 retry 提示后断开，EventSource 会按提示重连
+//  3. 文本 / JSON 流继续输出，直到完成或到达 shutdown-timeout
+//  4. 超时后取消所有请求的 Context，仍未结束的流计为 aborted
+//
+// 最后报告多少流被正常排空（drained）、多少被强制中止（aborted）。
+
+// drainer 跟踪所有正在进行的流
+type drainer struct {
+	shutdown chan struct{} // 开始关闭时关闭
+	once     sync.Once
+
+	baseCtx context.Context    // 作为 http.Server 的 BaseContext，所有请求的 Context 都派生自它
+	abort   context.CancelFunc // 超时后调用，强制结束剩余的流
+
+	mu      sync.Mutex
+	active  map[string]int // 路由 -> 当前流数量
+	forced  bool
+	drained int
+	aborted int
+	wg      sync.WaitGroup
+}
+
+func newDrainer() *drainer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &drainer{
+		shutdown: make(chan struct{}),
+		baseCtx:  ctx,
+		abort:    cancel,
+		active:   make(map[string]int),
+	}
+}
+
+// streams 跟踪本进程中所有的流
+var streams = newDrainer()
+
+// draining 返回在开始关闭时关闭的通道，流式处理器在 select 中监听它
+func draining() <-chan struct{} {
+	return streams.shutdown
+}
+
+// tracked 把处理器登记为流：关闭期间拒绝新的流，结束时计入排空/中止统计
+func (d *drainer) tracked(route string, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		select {
+		case <-d.shutdown:
+			d.mu.Unlock()
+			w.Header().Set("Retry-After", fmt.Sprint(int(cfg.ShutdownRetry.Seconds())))
+			http.Error(w, "server is shutting down", http.StatusServiceUnavailable)
+			return
+		default:
+		}
+		d.active[route]++
+		d.wg.Add(1)
+		d.mu.Unlock()
+
+		defer func() {
+			d.mu.Lock()
+			d.active[route]--
+			select {
+			case <-d.shutdown:
+				if d.forced {
+					d.aborted++
+				} else {
+					d.drained++
+				}
+			default:
+			}
+			d.mu.Unlock()
+			d.wg.Done()
+		}()
+
+		h.ServeHTTP(w, r)
+	})
+}
+
+// begin 开始关闭，通知所有流
+func (d *drainer) begin() {
+	d.once.Do(func() {
+		d.mu.Lock()
+		close(d.shutdown)
+		d.mu.Unlock()
+	})
+}
+
+// wait 等待所有流结束，超过 timeout 后强制取消剩余的流再等待 grace
+// 返回排空数、中止数和在 grace 之后仍未结束的流数
+func (d *drainer) wait(timeout, grace time.Duration) (drained, aborted, remaining int) {
+	done := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		d.mu.Lock()
+		d.forced = true
+		d.mu.Unlock()
+		d.abort()
+		select {
+		case <-done:
+		case <-time.After(grace):
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, n := range d.active {
+		remaining += n
+	}
+	return d.drained, d.aborted, remaining
+}
+
+// activeStreams 返回每个路由当前的流数量
+func (d *drainer) activeStreams() map[string]int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	out := make(map[string]int, len(d.active))
+	for route, n := range d.active {
+		if n > 0 {
+			out[route] = n
+		}
+	}
+	return out
+}
+
+// errShuttingDown 是因服务器关闭而中止的流的结束原因
+var errShuttingDown = errors.New("server shutting down")
+
+// shutdownRecords 通知客户端服务器即将关闭，以 done(shutdown) 和 usage 结束流
+// error 的 retry 字段提示 EventSource 多久后重连；这几条记录都不带 id，
+// 客户端重连时的 Last-Event-ID 仍是最后一条 delta
+func shutdownRecords(st *event.Stream) []format.Record {
+	rec := record(st.Error("shutting_down", errShuttingDown.Error(), cfg.ShutdownRetry))
+	rec.Text = "" // text 格式只输出 done 的说明
+	rec.Retry = cfg.ShutdownRetry
+	return append([]format.Record{rec}, endRecords(st, event.ReasonShutdown, "")...)
+}
+
+// sendShutdown 向SSE客户端发送 shutdownRecords
+func sendShutdown(ctx context.Context, sw *sse.Writer, st *event.Stream) {
+	sendRecords(ctx, sw, shutdownRecords(st)...)
+}
+
+// serve 启动服务器并在收到 SIGINT/SIGTERM 时优雅关闭
+func serve(handler http.Handler) error {
+	server := &http.Server{
+		Addr:        cfg.Addr,
+		Handler:     handler,
+		BaseContext: func(net.Listener) context.Context { return streams.baseCtx },
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- server.ListenAndServe()
+	}()
+
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+
+	select {
+	case err := <-errCh:
+		return err
+	case sig := <-sigChan:
+		slog.Info("收到信号，开始排空流", "component", "shutdown", "signal", sig.String(), "active", streams.activeStreams())
+	}
+
+	// 1. 通知所有流，停止接受新的流
+	streams.begin()
+
+	// 2. 关闭监听，Shutdown 会等待普通请求结束（不包括被 Hijack 的 WebSocket）
+	ctx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	go server.Shutdown(ctx)
+
+	// 3. 等待所有流结束，超时后强制中止
+	drained, aborted, remaining := streams.wait(cfg.ShutdownTimeout, time.Second)
+	slog.Info("关闭完成", "component", "shutdown", "drained", drained, "aborted", aborted, "remaining", remaining)
+	server.Close()
+	return nil
+}
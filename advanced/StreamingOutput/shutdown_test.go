@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-learning/advanced/StreamingOutput/hub"
+)
+
+// TestDrainSSE 开始关闭后，SSE客户端收到带 retry 的 shutting_down 错误和 done/usage，新的流返回 503
+func TestDrainSSE(t *testing.T) {
+	saved := streams
+	streams = newDrainer()
+	defer func() { streams = saved }()
+
+	// 使用自己的 broadcaster，测试结束时停止它的生产者，不影响全局实例
+	gen, _ := generators.Get("echo")
+	b := newBroadcaster(gen, hub.Options{Buffer: 16})
+	t.Cleanup(b.stop)
+
+	srv := httptest.NewServer(streams.tracked("/stream/broadcast", http.HandlerFunc(b.serve)))
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	br := bufio.NewReader(resp.Body)
+	if line, _ := br.ReadString('\n'); !strings.HasPrefix(line, ":") {
+		t.Fatalf("first line = %q, want joined comment", line)
+	}
+
+	streams.begin()
+
+	var got []string
+	for {
+		line, err := br.ReadString('\n')
+		if err != nil {
+			break
+		}
+		got = append(got, strings.TrimSpace(line))
+	}
+	if !contains(got, "event: error") || !contains(got, "retry: 5000") || !containsPrefix(got, `data: {"v":1,"seq":`) ||
+		!strings.Contains(strings.Join(got, "\n"), `"done":{"reason":"shutdown"}`) || !contains(got, "event: usage") {
+		t.Fatalf("stream tail = %q, want shutting_down error with retry, then done and usage", got)
+	}
+
+	drained, aborted, remaining := streams.wait(time.Second, 0)
+	if drained != 1 || aborted != 0 || remaining != 0 {
+		t.Fatalf("wait = %d/%d/%d, want 1/0/0", drained, aborted, remaining)
+	}
+
+	resp2, err := http.Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp2.Body.Close()
+	if resp2.StatusCode != http.StatusServiceUnavailable || resp2.Header.Get("Retry-After") != "5" {
+		t.Fatalf("new stream during shutdown = %d Retry-After %q", resp2.StatusCode, resp2.Header.Get("Retry-After"))
+	}
+}
+
+// TestDrainOnlySSE 开始关闭后，/stream/sse 只中止SSE流，NDJSON流继续输出直到完成
+func TestDrainOnlySSE(t *testing.T) {
+	saved := streams
+	streams = newDrainer()
+	defer func() { streams = saved }()
+	streams.begin()
+
+	for target, want := range map[string]string{
+		"/stream/sse":               `"done":{"reason":"shutdown"}`,
+		"/stream/sse?format=ndjson": `"done":{"reason":"complete"}`,
+	} {
+		rec := httptest.NewRecorder()
+		sseStream{count: 2}.serve(rec, httptest.NewRequest("GET", target, nil))
+		if body := rec.Body.String(); !strings.Contains(body, want) {
+			t.Errorf("%s during shutdown = %q, want %s", target, body, want)
+		}
+	}
+}
+
+func containsPrefix(lines []string, prefix string) bool {
+	for _, l := range lines {
+		if strings.HasPrefix(l, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(lines []string, want string) bool {
+	for _, l := range lines {
+		if l == want {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,140 @@
+package sse
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrLineTooLong 表示一行超过了 MaxLineSize
+var ErrLineTooLong = errors.New("sse: line too long")
+
+// MaxLineSize 是 Decoder 接受的最长一行（字节）
+const MaxLineSize = 1 << 20
+
+// Decoder 按规范解析 SSE 事件流
+//
+//   - 行结束符可以是 \r\n、\r 或 \n，流开头的 UTF-8 BOM 会被跳过
+//   - 以冒号开头的行是注释，被忽略
+//   - 多行 data 以 \n 拼接；data 为空的事件不会分发（但其中的 id / retry 仍然生效）
+//   - id 在事件之间保持，直到被新的 id 覆盖；包含 NUL 的 id 被忽略
+//   - retry 只接受十进制数字，通过 Retry() 取得
+//
+// 返回的 Event.Event 为空表示默认的 message 事件，与 Writer 的约定一致。
+type Decoder struct {
+	r       *bufio.Reader
+	started bool // 是否已经处理过 BOM
+	skipLF  bool // 上一行以 \r 结束，下一个字节若是 \n 属于同一个行结束符
+
+	lastID string
+	retry  time.Duration
+}
+
+// NewDecoder 创建从 r 读取事件的 Decoder
+func NewDecoder(r io.Reader) *Decoder {
+	return &Decoder{r: bufio.NewReader(r)}
+}
+
+// LastEventID 返回最近一次设置的 id，重连时作为 Last-Event-ID 发送
+func (d *Decoder) LastEventID() string { return d.lastID }
+
+// SetLastEventID 设置 last event ID 的初始值
+// 重连时传入上一个连接最后的 id，与 EventSource 一样在连接之间保留它：
+// 新连接在收到 id 字段之前断开时，LastEventID 仍是这个值，没有 id 的事件也带着它
+func (d *Decoder) SetLastEventID(id string) { d.lastID = id }
+
+// Retry 返回服务器最近一次建议的重连间隔，没有收到过 retry 字段时为 0
+func (d *Decoder) Retry() time.Duration { return d.retry }
+
+// Next 读取下一条事件；流结束时返回 io.EOF，结尾不完整的事件按规范丢弃
+func (d *Decoder) Next() (Event, error) {
+	var (
+		ev      Event
+		data    strings.Builder
+		hasData bool
+	)
+	for {
+		line, err := d.readLine()
+		if err != nil {
+			return Event{}, err // 规范：流结束时未以空行结束的事件被丢弃
+		}
+
+		if len(line) == 0 {
+			// 空行：分发事件
+			if !hasData {
+				ev.Event = ""
+				continue
+			}
+			ev.ID = d.lastID
+			ev.Data = data.String()
+			return ev, nil
+		}
+		if line[0] == ':' {
+			continue // 注释
+		}
+
+		field, value := line, ""
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			field, value = line[:i], line[i+1:]
+			value = strings.TrimPrefix(value, " ")
+		}
+		switch field {
+		case "event":
+			ev.Event = value
+		case "data":
+			if hasData {
+				data.WriteByte('\n')
+			}
+			data.WriteString(value)
+			hasData = true
+		case "id":
+			if !strings.ContainsRune(value, 0) {
+				d.lastID = value
+			}
+		case "retry":
+			if ms, err := strconv.ParseUint(value, 10, 63); err == nil {
+				d.retry = time.Duration(ms) * time.Millisecond
+			}
+		}
+	}
+}
+
+// readLine 读取一行（不含行结束符），流在行中间结束时返回已读内容和 io.EOF
+func (d *Decoder) readLine() (string, error) {
+	if !d.started {
+		d.started = true
+		if b, err := d.r.Peek(3); err == nil && bytes.Equal(b, []byte("\xEF\xBB\xBF")) {
+			d.r.Discard(3)
+		}
+	}
+
+	var line []byte
+	for {
+		c, err := d.r.ReadByte()
+		if err != nil {
+			return string(line), err
+		}
+		if d.skipLF {
+			d.skipLF = false
+			if c == '\n' {
+				continue
+			}
+		}
+		switch c {
+		case '\n':
+			return string(line), nil
+		case '\r':
+			// 不等待下一个字节：只以 \r 结束的流也能立即分发事件
+			d.skipLF = true
+			return string(line), nil
+		}
+		if len(line) >= MaxLineSize {
+			return "", ErrLineTooLong
+		}
+		line = append(line, c)
+	}
+}
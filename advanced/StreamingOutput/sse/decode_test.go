@@ -0,0 +1,67 @@
+package sse
+
+import (
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+)
+
+func decodeAll(t *testing.T, r io.Reader) ([]Event, *Decoder) {
+	t.Helper()
+	d := NewDecoder(r)
+	var events []Event
+	for {
+		ev, err := d.Next()
+		if err == io.EOF {
+			return events, d
+		}
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		events = append(events, ev)
+	}
+}
+
+func TestDecoder(t *testing.T) {
+	stream := "\xEF\xBB\xBF: 注释\n" +
+		"id: 1\r\nevent: token\r\ndata: 第一行\r\ndata:第二行\r\n\r\n" + // CRLF，冒号后可以没有空格
+		"data: 继承 id\r\r" + // 只有 CR
+		"retry: 2500\nid\n\n" + // 没有 data 的事件不分发，但 id 和 retry 生效
+		"retry: 1x\ndata\n\n" + // 非法 retry 被忽略；没有冒号时值为空
+		"id: a\x00b\ndata: nul\n\n" + // 含 NUL 的 id 被忽略
+		"data: 不完整"
+
+	events, d := decodeAll(t, strings.NewReader(stream))
+	want := []Event{
+		{ID: "1", Event: "token", Data: "第一行\n第二行"},
+		{ID: "1", Data: "继承 id"},
+		{Data: ""},
+		{Data: "nul"},
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Fatalf("events =\n%#v\nwant\n%#v", events, want)
+	}
+	if d.Retry() != 2500*time.Millisecond {
+		t.Fatalf("Retry = %v", d.Retry())
+	}
+}
+
+// TestDecoderRoundTrip Encode 的输出逐字节到达时也能还原
+func TestDecoderRoundTrip(t *testing.T) {
+	in := []Event{
+		{ID: "7", Event: "done", Data: "a\nb"},
+		{Data: "x", Retry: time.Second},
+	}
+	var b strings.Builder
+	for _, ev := range in {
+		Encode(&b, ev)
+	}
+	events, d := decodeAll(t, iotest.OneByteReader(strings.NewReader(b.String())))
+	want := []Event{in[0], {ID: "7", Data: "x"}}
+	if !reflect.DeepEqual(events, want) || d.Retry() != time.Second {
+		t.Fatalf("events = %#v retry %v", events, d.Retry())
+	}
+}
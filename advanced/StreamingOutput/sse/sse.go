@@ -0,0 +1,148 @@
+// Package sse 提供 Server-Sent Events 的服务端写入器（Writer）和客户端解析器（Decoder）
+//
+// 协议格式参考 https://html.spec.whatwg.org/multipage/server-sent-events.html
+// 每个事件由若干 "字段: 值" 行组成，以一个空行结束：
+//
+//	id: 3
+//	event: token
+//	data: 第一行
+//	data: 第二行
+//
+// 浏览器的 EventSource 断线重连时会通过 Last-Event-ID 请求头带上最后收到的 id，
+// 服务端据此从断点继续发送，而不是从第 1 条消息重新开始。
+package sse
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ContentType 是 SSE 响应的 MIME 类型
+const ContentType = "text/event-stream"
+
+// ErrStreamingUnsupported 表示 ResponseWriter 不支持 http.Flusher，无法流式输出
+var ErrStreamingUnsupported = errors.New("sse: streaming unsupported")
+
+// ErrInvalidField 表示 id / event 字段中包含换行等非法字符
+var ErrInvalidField = errors.New("sse: field must not contain newline or NUL")
+
+// Event 表示一条 SSE 事件，零值字段不会被写出
+type Event struct {
+	ID    string        // id: 字段，客户端重连时会通过 Last-Event-ID 回传
+	Event string        // event: 字段，为空时客户端触发默认的 message 事件
+	Data  string        // data: 字段，包含换行时会拆成多行 data
+	Retry time.Duration // retry: 字段，建议客户端的重连间隔
+}
+
+// Writer 封装 http.ResponseWriter 与 http.Flusher，每次写入事件后立即刷新
+type Writer struct {
+	w           http.ResponseWriter
+	flusher     http.Flusher
+	lastEventID string
+}
+
+// NewWriter 设置 SSE 响应头并返回 Writer
+// 💡 注意：调用后响应头尚未发送，第一次写入事件时才会发出
+func NewWriter(w http.ResponseWriter, r *http.Request) (*Writer, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return nil, ErrStreamingUnsupported
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", ContentType)
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+
+	return &Writer{
+		w:           w,
+		flusher:     flusher,
+		lastEventID: r.Header.Get("Last-Event-ID"),
+	}, nil
+}
+
+// Accepts 判断客户端是否请求 SSE 格式（Accept 头中包含 text/event-stream）
+func Accepts(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), ContentType)
+}
+
+// LastEventID 返回客户端重连时携带的 Last-Event-ID，首次连接时为空
+func (sw *Writer) LastEventID() string {
+	return sw.lastEventID
+}
+
+// Send 写出一条事件并刷新
+func (sw *Writer) Send(ev Event) error {
+	if err := Encode(sw.w, ev); err != nil {
+		return err
+	}
+	sw.flusher.Flush()
+	return nil
+}
+
+// Data 发送只包含 data 字段的匿名事件
+func (sw *Writer) Data(data string) error {
+	return sw.Send(Event{Data: data})
+}
+
+// Comment 发送注释行（以冒号开头），客户端会忽略，常用作心跳保持连接
+func (sw *Writer) Comment(text string) error {
+	var b strings.Builder
+	for _, line := range splitLines(text) {
+		b.WriteString(": ")
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	b.WriteByte('\n')
+	if _, err := io.WriteString(sw.w, b.String()); err != nil {
+		return err
+	}
+	sw.flusher.Flush()
+	return nil
+}
+
+// Encode 按 SSE 格式把事件写入 w，不负责刷新
+func Encode(w io.Writer, ev Event) error {
+	if !validField(ev.ID) || !validField(ev.Event) {
+		return ErrInvalidField
+	}
+
+	var b strings.Builder
+	if ev.ID != "" {
+		fmt.Fprintf(&b, "id: %s\n", ev.ID)
+	}
+	if ev.Event != "" {
+		fmt.Fprintf(&b, "event: %s\n", ev.Event)
+	}
+	if ev.Retry > 0 {
+		fmt.Fprintf(&b, "retry: %d\n", ev.Retry.Milliseconds())
+	}
+	// 没有 data 字段的事件不会被客户端分发，因此 data 为空时也写一行 "data: "；
+	// 只有单独的 retry 指令（没有 id / event / data）不需要分发
+	if ev.Data != "" || ev.ID != "" || ev.Event != "" || ev.Retry <= 0 {
+		for _, line := range splitLines(ev.Data) {
+			b.WriteString("data: ")
+			b.WriteString(line)
+			b.WriteByte('\n')
+		}
+	}
+	b.WriteByte('\n')
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// splitLines 按 \r\n、\r、\n 拆分文本，与规范中的行结束符保持一致
+func splitLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.ReplaceAll(s, "\r", "\n")
+	return strings.Split(s, "\n")
+}
+
+func validField(s string) bool {
+	return !strings.ContainsAny(s, "\r\n\x00")
+}
@@ -0,0 +1,64 @@
+package sse
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncode(t *testing.T) {
+	tests := []struct {
+		name string
+		ev   Event
+		want string
+	}{
+		{"匿名事件", Event{Data: "hello"}, "data: hello\n\n"},
+		{"完整字段", Event{ID: "3", Event: "token", Data: "hi", Retry: 3 * time.Second},
+			"id: 3\nevent: token\nretry: 3000\ndata: hi\n\n"},
+		{"多行数据", Event{Data: "a\nb\r\nc"}, "data: a\ndata: b\ndata: c\n\n"},
+		{"空数据", Event{}, "data: \n\n"},
+		{"只有事件名", Event{Event: "x"}, "event: x\ndata: \n\n"},
+		{"只有ID", Event{ID: "5"}, "id: 5\ndata: \n\n"},
+		{"只有retry", Event{Retry: time.Second}, "retry: 1000\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			if err := Encode(&b, tt.ev); err != nil {
+				t.Fatal(err)
+			}
+			if b.String() != tt.want {
+				t.Errorf("Encode() = %q, want %q", b.String(), tt.want)
+			}
+		})
+	}
+
+	if err := Encode(&strings.Builder{}, Event{ID: "1\n2"}); err != ErrInvalidField {
+		t.Errorf("Encode() error = %v, want ErrInvalidField", err)
+	}
+}
+
+func TestWriterLastEventID(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("Last-Event-ID", "7")
+	rec := httptest.NewRecorder()
+
+	sw, err := NewWriter(rec, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sw.LastEventID() != "7" {
+		t.Errorf("LastEventID() = %q, want 7", sw.LastEventID())
+	}
+	if err := sw.Send(Event{ID: "8", Data: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != ContentType {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if !rec.Flushed {
+		t.Error("Send() 之后应当刷新")
+	}
+}
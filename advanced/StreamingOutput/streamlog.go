@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-learning/advanced/StreamingOutput/event"
+	"go-learning/advanced/StreamingOutput/eventlog"
+	"go-learning/advanced/StreamingOutput/format"
+)
+
+// ============ 流日志：SSE 流的信封写入事件日志，断线或重启后从日志续传 ============
+//
+// 启用 -event-log 后，/stream/pipeline 的 SSE 流把每个信封追加到 <event-log>/streams，
+// 主题为 "<客户端>/<流ID>"；delta 的 id 变为 "<流ID>@<日志序号>"。客户端带着这样的 Last-Event-ID 重连时：
+//  1. 从日志发送这个流在该序号之后的信封，读到 usage 说明流已结束
+//  2. 原来的流还在生成时（见下文）等待它写入新的信封
+//  3. 日志中的流没有结束、也不再生成时（如关闭超时被中止），按序号重新生成（仅限确定性的生成器）
+//
+// 客户端断开或服务器开始关闭后，生产者继续运行到生成完毕，把剩余的信封写入日志，
+// 因此非确定性的生成器（openai 等）也能续传；关闭超时会取消它，客户端连接期间的停止请求也会。
+
+// streamLog 保存 SSE 流的信封，未启用事件日志时为 nil
+var streamLog *eventlog.Log
+
+// streamLogPoll 是续传时等待原来的流写入新信封的间隔
+const streamLogPoll = 100 * time.Millisecond
+
+// openStreamLog 打开 <event-log>/streams，并定期执行保留策略
+// 每个流只有一个主题，不需要按主题压缩
+func openStreamLog() error {
+	elog, err := openEventLog(filepath.Join(cfg.EventLog, "streams"))
+	if err != nil {
+		return err
+	}
+	streamLog = elog
+	go func() {
+		logger := slog.With("component", "streamlog")
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+		for range ticker.C {
+			removed, err := elog.Retain(time.Now())
+			if err == eventlog.ErrClosed {
+				return
+			}
+			if err != nil {
+				logger.Warn("保留策略执行失败", "err", err)
+			} else if removed > 0 {
+				logger.Info("清理流日志", "segments_removed", removed)
+			}
+		}
+	}()
+	return nil
+}
+
+// durableID 是写入流日志的 delta 的SSE id
+func durableID(stream string, seq uint64) string {
+	return stream + "@" + strconv.FormatUint(seq, 10)
+}
+
+// parseDurableID 解析 durableID 生成的 Last-Event-ID
+func parseDurableID(id string) (stream string, seq uint64, ok bool) {
+	stream, s, found := strings.Cut(id, "@")
+	if !found || stream == "" {
+		return "", 0, false
+	}
+	seq, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || seq == 0 {
+		return "", 0, false
+	}
+	return stream, seq, true
+}
+
+// journal 把一个流的信封写入流日志
+// nil 的 journal 不写日志，各方法退化为 record / endRecords，处理器不需要区分
+type journal struct {
+	log    *eventlog.Log
+	owner  string
+	topic  string
+	logger *slog.Logger
+}
+
+// newJournal 为请求创建 journal，未启用事件日志或不是SSE（无法带着 Last-Event-ID 重连）时返回 nil
+func newJournal(r *http.Request, f format.Format) *journal {
+	if streamLog == nil || f != format.SSE {
+		return nil
+	}
+	owner := clientKey(r)
+	return &journal{
+		log:    streamLog,
+		owner:  owner,
+		topic:  owner + "/" + streamID(r.Context()),
+		logger: loggerFrom(r.Context()),
+	}
+}
+
+// record 把信封写入日志并转换为记录，delta 的 id 带上流ID和日志序号
+// 写入失败时只记录日志，id 仍是序号，客户端重连时按序号续传
+func (j *journal) record(env event.Envelope) format.Record {
+	rec := record(env)
+	if j == nil {
+		return rec
+	}
+	data, _ := json.Marshal(env)
+	logged, err := j.log.Append(j.topic, data)
+	if err != nil {
+		j.logger.Warn("写入流日志失败", "err", err)
+		return rec
+	}
+	if env.Type == event.Delta {
+		rec.ID = durableID(env.Stream, logged.Seq)
+	}
+	return rec
+}
+
+// end 生成并写入结束的 done 和 usage
+func (j *journal) end(st *event.Stream, reason, detail string) []format.Record {
+	var recs []format.Record
+	for _, env := range st.End(reason, detail) {
+		recs = append(recs, j.record(env))
+	}
+	return recs
+}
+
+// detach 返回生产者使用的 Context
+// 写日志的流在客户端断开后仍然有效，只有停止请求和关闭超时会取消它；不写日志的流随 ctx 取消
+func (j *journal) detach(ctx context.Context) (context.Context, context.CancelFunc) {
+	if j == nil {
+		return context.WithCancel(ctx)
+	}
+	genCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	stopped := context.AfterFunc(ctx, func() {
+		if _, ok := stopReason(ctx); ok {
+			cancel()
+		}
+	})
+	aborted := context.AfterFunc(streams.baseCtx, cancel)
+	return genCtx, func() {
+		stopped()
+		aborted()
+		cancel()
+	}
+}
+
+// rest 在客户端离开后把生产者剩余的输出写入日志，skip 是续传时还要跳过的token数
+// 生产者被取消时日志中的流没有结束，重连的客户端按序号续传
+func (j *journal) rest(genCtx context.Context, st *event.Stream, pipe *Pipeline, skip int) {
+	for token := range pipe.Tokens() {
+		if skip > 0 {
+			skip--
+			continue
+		}
+		j.record(st.Delta(token))
+	}
+	err := pipe.Err()
+	switch {
+	case genCtx.Err() != nil:
+		j.logger.Info("生产者被取消，流日志中的流未结束", "tokens", st.Tokens())
+	case err != nil:
+		j.record(st.Error("generation_failed", err.Error(), 0))
+		j.end(st, event.ReasonError, err.Error())
+	default:
+		j.end(st, event.ReasonComplete, "")
+		j.logger.Info("剩余输出已写入流日志", "tokens", st.Tokens())
+	}
+}
+
+// resume 向客户端发送流日志中 from 流在 since 之后的信封，原来的流仍在进行时等待新的信封
+// 返回最后发送的 delta 的序号（没有发送时为客户端最后收到的那条）以及流是否已经结束；
+// 客户端最后收到的信封不在日志中（已被清理，或属于其他客户端）时返回 errResumeUnsupported
+func (j *journal) resume(ctx context.Context, enc format.Encoder, from string, since uint64) (last uint64, complete bool, err error) {
+	topic := j.owner + "/" + from
+	cursor, found := since-1, false // 从客户端最后收到的那条开始读，确认它属于这个客户端并取得它的序号
+	for {
+		live := liveStreams.live(from) // 先于读取检查，读完之后才结束的流在下一轮读到结尾
+		var batch []eventlog.Record
+		if err := j.log.Read(cursor, func(rec eventlog.Record) error {
+			if rec.Topic == topic {
+				batch = append(batch, rec)
+			}
+			return nil
+		}); err != nil {
+			return last, false, err
+		}
+
+		for _, logged := range batch {
+			cursor = logged.Seq
+			var env event.Envelope
+			if err := json.Unmarshal(logged.Data, &env); err != nil {
+				return last, false, err
+			}
+			if logged.Seq == since {
+				found, last = true, env.Seq
+				continue
+			}
+			if !found {
+				break
+			}
+			rec := record(env)
+			if env.Type == event.Delta {
+				rec.ID = durableID(from, logged.Seq)
+				last = env.Seq
+			}
+			if err := encode(enc, rec); err != nil {
+				return last, false, err
+			}
+			if env.Type == event.Usage {
+				return last, true, nil
+			}
+		}
+		if !found {
+			return 0, false, errResumeUnsupported
+		}
+		if !live {
+			return last, false, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return last, false, ctx.Err()
+		case <-draining():
+			return last, false, errShuttingDown
+		case <-time.After(streamLogPoll):
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-learning/advanced/StreamingOutput/event"
+	"go-learning/advanced/StreamingOutput/eventlog"
+	"go-learning/advanced/StreamingOutput/generator"
+	"go-learning/advanced/StreamingOutput/sse"
+)
+
+// TestResumeFromStreamLog 非确定性的生成器在客户端断开后继续写入流日志，重连的客户端从日志收到剩余的token
+func TestResumeFromStreamLog(t *testing.T) {
+	elog, err := eventlog.Open(eventlog.Options{Dir: t.TempDir(), Sync: eventlog.SyncNever})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer elog.Close()
+	saved := streamLog
+	streamLog = elog
+	t.Cleanup(func() { streamLog = saved })
+
+	generators.Register("letters", generator.Func(func(ctx context.Context, prompt string, opts generator.Options, out chan<- string) error {
+		for _, token := range []string{"a", "b", "c", "d", "e"} {
+			if err := generator.Sleep(ctx, 20*time.Millisecond); err != nil {
+				return err
+			}
+			select {
+			case out <- token:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		return nil
+	}))
+
+	srv := httptest.NewServer(registered("/stream/pipeline", http.HandlerFunc(pipelineHandler)))
+	defer srv.Close()
+	get := func(lastID string) *http.Response {
+		req, _ := http.NewRequest("GET", srv.URL+"/stream/pipeline?generator=letters", nil)
+		req.Header.Set("Accept", sse.ContentType)
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp
+	}
+
+	// 收到两个token后断开
+	resp := get("")
+	dec := sse.NewDecoder(resp.Body)
+	var lastID string
+	for n := 0; n < 2; {
+		ev, err := dec.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ev.Event == "delta" {
+			lastID = ev.ID
+			n++
+		}
+	}
+	resp.Body.Close()
+	if _, _, ok := parseDurableID(lastID); !ok {
+		t.Fatalf("delta id %q is not a stream log id", lastID)
+	}
+
+	resp = get(lastID)
+	defer resp.Body.Close()
+	var got []string
+	dec = sse.NewDecoder(resp.Body)
+	for {
+		ev, err := dec.Next()
+		if err != nil {
+			break
+		}
+		var env event.Envelope
+		json.Unmarshal([]byte(ev.Data), &env)
+		switch env.Type {
+		case event.Delta:
+			got = append(got, env.Delta.Content)
+		case event.Done:
+			got = append(got, "done:"+env.Done.Reason)
+		}
+	}
+	if strings.Join(got, " ") != "c d e done:complete" {
+		t.Fatalf("resumed events = %q", got)
+	}
+
+	// 其他客户端不能用这个 id 续传
+	from, since, _ := parseDurableID(lastID)
+	if _, _, err := (&journal{log: elog, owner: "ip:192.0.2.1"}).resume(context.Background(), nil, from, since); err != errResumeUnsupported {
+		t.Fatalf("resume by another client = %v", err)
+	}
+}
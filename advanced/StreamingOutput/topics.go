@@ -0,0 +1,200 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-learning/advanced/StreamingOutput/event"
+	"go-learning/advanced/StreamingOutput/hub"
+	"go-learning/advanced/StreamingOutput/pubsub"
+	"go-learning/advanced/StreamingOutput/sse"
+)
+
+// ============ 基于主题的发布/订阅 ============
+//
+//	POST /publish/{topic}     发布消息，请求体为 Message JSON 或纯文本
+//	GET  /subscribe/{pattern} 以SSE订阅，pattern 支持 * 和 **（见 pubsub 包）
+//	GET  /topics              查看各主题保留的消息数
+//
+// 订阅时先补发保留的最近消息，携带 Last-Event-ID 时只补发更新的消息；
+// 启用 -event-log 后消息写入磁盘，重启后重连的客户端也能回放错过的消息。
+
+// Message 是发布到主题的消息
+type Message struct {
+	ID      int    `json:"id"`              // 订阅时填入消息在 Broker 中的序号
+	Topic   string `json:"topic,omitempty"` // 订阅时填入消息所在的主题
+	Content string `json:"content"`
+	Time    string `json:"time"` // RFC 3339（纳秒精度），发布者没有提供时为服务端收到的时间
+}
+
+var (
+	brokerOnce sync.Once
+	brokerInst *pubsub.Broker[Message]
+)
+
+// sharedBroker 返回全局的 Broker
+// 配置了事件日志时由 setupEventLog 预先创建，否则首次调用时创建只存内存的 Broker
+func sharedBroker() *pubsub.Broker[Message] {
+	brokerOnce.Do(func() {
+		if brokerInst != nil {
+			return
+		}
+		policy, _ := hub.ParsePolicy(cfg.BroadcastPolicy)
+		brokerInst = pubsub.New[Message](pubsub.Options{
+			Retain: cfg.TopicRetain,
+			Hub:    hub.Options{Buffer: cfg.BroadcastBuffer, Policy: policy},
+		})
+	})
+	return brokerInst
+}
+
+// publishHandler 处理 POST /publish/{topic}
+func publishHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	topic := strings.TrimPrefix(r.URL.Path, "/publish/")
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 64*1024))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	// 默认信封是 Message：JSON 请求体按 Message 解析，否则整个请求体作为 Content
+	var msg Message
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		if err := json.Unmarshal(body, &msg); err != nil {
+			http.Error(w, "invalid JSON: "+err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else {
+		msg.Content = string(body)
+	}
+	if msg.Time == "" {
+		msg.Time = time.Now().Format(time.RFC3339Nano)
+	}
+
+	broker := sharedBroker()
+	item, delivered, err := broker.Publish(topic, msg)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	loggerFrom(r.Context()).Info("发布消息", "topic", topic, "seq", item.Seq, "subscribers", delivered)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]any{
+		"id":          item.Seq,
+		"topic":       topic,
+		"subscribers": delivered,
+	})
+}
+
+// subscribeHandler 处理 GET /subscribe/{pattern}
+func subscribeHandler(w http.ResponseWriter, r *http.Request) {
+	pattern := strings.TrimPrefix(r.URL.Path, "/subscribe/")
+	if err := pubsub.ValidPattern(pattern); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sw, err := sse.NewWriter(w, r)
+	if err != nil {
+		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	since, _ := strconv.ParseUint(sw.LastEventID(), 10, 64)
+	broker := sharedBroker()
+	sub, err := broker.Subscribe(pattern, since)
+	if errors.Is(err, pubsub.ErrResumeTooOld) {
+		// 断线期间的消息已被保留策略或压缩删除，无法补齐：与 /stream/pipeline 一样以 resume_unsupported 结束，
+		// 客户端应当不带 Last-Event-ID 重新订阅
+		st := newEventStream(r.Context(), 0)
+		sendRecords(r.Context(), sw, record(st.Error("resume_unsupported", err.Error(), 0)))
+		sendRecords(r.Context(), sw, endRecords(st, event.ReasonError, "resume unsupported")...)
+		loggerFrom(r.Context()).Warn("续传点超出回放窗口", "pattern", pattern, "since", since)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer broker.Unsubscribe(sub)
+
+	ctx := r.Context()
+	logger := loggerFrom(r.Context()).With("pattern", pattern)
+	// 主题消息原样转发，st 只用于停止和关闭时的结束信封
+	st := newEventStream(ctx, int(since))
+	logger.Info("订阅", "backlog", len(sub.Backlog))
+	sw.Comment("subscribed " + pattern)
+
+	for _, item := range sub.Backlog {
+		if err := sendTopicItem(ctx, sw, item); err != nil {
+			return
+		}
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			if reason, ok := stopReason(ctx); ok {
+				sendStopped(ctx, sw, st, reason)
+				logger.Info("订阅被停止", "reason", reason)
+				return
+			}
+			logger.Info("取消订阅")
+			return
+
+		case <-draining():
+			sendShutdown(ctx, sw, st)
+			return
+
+		case item, ok := <-sub.C():
+			if !ok {
+				if errors.Is(sub.Err(), hub.ErrSlowConsumer) {
+					sendRecords(ctx, sw, record(st.Error("disconnected", sub.Err().Error(), 0)))
+					sendRecords(ctx, sw, endRecords(st, event.ReasonError, sub.Err().Error())...)
+				}
+				return
+			}
+			if err := sendTopicItem(ctx, sw, item); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// topicEvent 是主题消息的SSE事件名
+// 不用主题名作事件名：名为 error、done 等的主题会与同一个流上的结束信封混淆
+const topicEvent = "message"
+
+// sendTopicItem 把消息作为SSE事件发送：id 为序号，主题名在 data 的 topic 字段中
+func sendTopicItem(ctx context.Context, sw *sse.Writer, item pubsub.Item[Message]) error {
+	msg := item.Value
+	msg.ID = int(item.Seq)
+	msg.Topic = item.Topic
+	data, _ := json.Marshal(msg)
+	if err := sw.Send(sse.Event{ID: strconv.FormatUint(item.Seq, 10), Event: topicEvent, Data: string(data)}); err != nil {
+		return err
+	}
+	sentMessage(ctx)
+	return nil
+}
+
+// topicsHandler 处理 GET /topics
+func topicsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sharedBroker().Topics())
+}
@@ -0,0 +1,31 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"go-learning/advanced/StreamingOutput/pubsub"
+	"go-learning/advanced/StreamingOutput/sse"
+)
+
+// TestSendTopicItem 主题名放在 data 中，事件名固定，名为 done 的主题不会被当作结束信封
+func TestSendTopicItem(t *testing.T) {
+	rec := httptest.NewRecorder()
+	sw, err := sse.NewWriter(rec, httptest.NewRequest("GET", "/subscribe/done", nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendTopicItem(context.Background(), sw, pubsub.Item[Message]{Seq: 7, Topic: "done", Value: Message{Content: "hi"}})
+
+	ev, err := sse.NewDecoder(rec.Body).Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg Message
+	json.Unmarshal([]byte(ev.Data), &msg)
+	if ev.Event != "message" || ev.ID != "7" || msg.Topic != "done" || msg.ID != 7 || msg.Content != "hi" {
+		t.Fatalf("event = %+v, message = %+v", ev, msg)
+	}
+}
@@ -0,0 +1,165 @@
+// Package utf8chunk 保证流式文本在任意字节边界写入时，客户端收到的每一块都是完整的 UTF-8
+//
+// 中文字符占 3 个字节，emoji 占 4 个字节，按字节切分的生产者（或按字节数刷新的缓冲层）
+// 可能把一个字符拆到两次刷新中，浏览器逐块解码时就会显示成乱码。Writer 把块末尾
+// 不完整的 UTF-8 序列留到下一次写入，无效的字节替换为 U+FFFD。
+//
+// 开启 Graphemes 后还会保留末尾可能不完整的字素簇（grapheme cluster），
+// 例如 "👍" 后面可能还有肤色修饰符 "🏽"，"👨" 后面可能还有 "‍👩‍👧"，
+// 国旗由两个区域指示符组成。字素簇边界按 UAX #29 的简化规则判断：
+// CR LF、组合符号与变体选择符（Extend / SpacingMark）、ZWJ 连接的 emoji、区域指示符配对；
+// 没有处理韩文字母组合等规则。
+package utf8chunk
+
+import (
+	"io"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Options 配置 Writer
+type Options struct {
+	Graphemes bool // 同时保留末尾不完整的字素簇，会让最后一个字符延迟到下一次写入或 Flush
+}
+
+// Writer 包装 w，只向 w 写入完整的 UTF-8 字符（开启 Graphemes 时为完整的字素簇）
+type Writer struct {
+	w    io.Writer
+	opts Options
+
+	partial []byte // 末尾不完整的 UTF-8 序列
+	tail    []byte // 末尾可能不完整的字素簇（已经是合法 UTF-8）
+	prev    rune   // tail 中的最后一个字符，-1 表示没有
+	riOdd   bool   // 以 prev 结尾的连续区域指示符个数是否为奇数
+	out     []byte
+	err     error
+}
+
+// NewWriter 创建写入 w 的 Writer，结束时必须调用 Close 写出保留的内容
+func NewWriter(w io.Writer, opts Options) *Writer {
+	return &Writer{w: w, opts: opts, prev: -1}
+}
+
+// Write 写出 p 中所有完整的字符，不完整的部分留到下一次写入
+// 返回 len(p) 表示 p 已全部被接收，不代表已经写到 w
+func (cw *Writer) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+	buf := p
+	if len(cw.partial) > 0 {
+		buf = append(cw.partial, p...)
+	}
+	cw.out = cw.out[:0]
+	i := 0
+	for i < len(buf) {
+		if !utf8.FullRune(buf[i:]) {
+			break // 可能在下一次写入中补全
+		}
+		r, size := utf8.DecodeRune(buf[i:])
+		if r == utf8.RuneError && size == 1 {
+			cw.add(utf8.RuneError, []byte(string(utf8.RuneError)))
+		} else {
+			cw.add(r, buf[i:i+size])
+		}
+		i += size
+	}
+	cw.partial = append(cw.partial[:0], buf[i:]...)
+	return len(p), cw.emit()
+}
+
+// Flush 写出保留的字素簇（不完整的 UTF-8 序列仍然保留），再刷新 w（如果 w 支持）
+func (cw *Writer) Flush() error {
+	if cw.err != nil {
+		return cw.err
+	}
+	cw.out = append(cw.out[:0], cw.tail...)
+	cw.tail = cw.tail[:0]
+	if err := cw.emit(); err != nil {
+		return err
+	}
+	switch f := cw.w.(type) {
+	case interface{ Flush() error }:
+		cw.err = f.Flush()
+	case interface{ Flush() }:
+		f.Flush()
+	}
+	return cw.err
+}
+
+// Close 写出全部保留的内容，末尾不完整的 UTF-8 序列按无效字节替换为 U+FFFD
+// 不会关闭 w
+func (cw *Writer) Close() error {
+	if cw.err != nil {
+		return cw.err
+	}
+	cw.out = append(cw.out[:0], cw.tail...)
+	for range cw.partial {
+		cw.out = utf8.AppendRune(cw.out, utf8.RuneError)
+	}
+	cw.tail, cw.partial = cw.tail[:0], cw.partial[:0]
+	cw.prev, cw.riOdd = -1, false
+	return cw.emit()
+}
+
+// add 追加一个字符：在字素簇边界处把 tail 移到 out
+func (cw *Writer) add(r rune, b []byte) {
+	if !cw.opts.Graphemes {
+		cw.out = append(cw.out, b...)
+		return
+	}
+	if cw.prev < 0 || isBoundary(cw.prev, r, cw.riOdd) {
+		cw.out = append(cw.out, cw.tail...)
+		cw.tail = cw.tail[:0]
+	}
+	cw.tail = append(cw.tail, b...)
+	cw.riOdd = isRegionalIndicator(r) && !(isRegionalIndicator(cw.prev) && cw.riOdd)
+	cw.prev = r
+}
+
+func (cw *Writer) emit() error {
+	if len(cw.out) == 0 {
+		return nil
+	}
+	_, cw.err = cw.w.Write(cw.out)
+	return cw.err
+}
+
+const zwj = '\u200d' // ZERO WIDTH JOINER
+
+// isBoundary 判断 prev 与 r 之间是否是字素簇边界，riOdd 表示以 prev 结尾的区域指示符个数为奇数
+func isBoundary(prev, r rune, riOdd bool) bool {
+	switch {
+	case prev == '\r' && r == '\n': // GB3
+		return false
+	case isControl(prev) || isControl(r): // GB4, GB5
+		return true
+	case isExtend(r) || r == zwj || unicode.Is(unicode.Mc, r): // GB9, GB9a
+		return false
+	case prev == zwj && isPictographic(r): // GB11
+		return false
+	case isRegionalIndicator(prev) && isRegionalIndicator(r): // GB12, GB13
+		return !riOdd
+	}
+	return true
+}
+
+func isControl(r rune) bool {
+	return r == '\r' || r == '\n' || unicode.IsControl(r)
+}
+
+// isExtend 近似 Grapheme_Cluster_Break=Extend：组合符号、变体选择符、emoji 肤色修饰符和标签字符
+func isExtend(r rune) bool {
+	return unicode.In(r, unicode.Mn, unicode.Me, unicode.Variation_Selector) ||
+		(r >= 0x1F3FB && r <= 0x1F3FF) ||
+		(r >= 0xE0020 && r <= 0xE007F)
+}
+
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
+
+// isPictographic 近似 Extended_Pictographic
+func isPictographic(r rune) bool {
+	return unicode.Is(unicode.So, r) || (r >= 0x1F000 && r <= 0x1FAFF)
+}
@@ -0,0 +1,135 @@
+package utf8chunk
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// chunkRecorder 记录每一次写入
+type chunkRecorder struct{ chunks []string }
+
+func (c *chunkRecorder) Write(p []byte) (int, error) {
+	c.chunks = append(c.chunks, string(p))
+	return len(p), nil
+}
+
+func (c *chunkRecorder) String() string { return strings.Join(c.chunks, "") }
+
+// writeSplit 按 sizes 给出的长度把 s 切成若干块写入（长度循环使用，0 视为 1）
+func writeSplit(t testing.TB, s string, sizes []byte, opts Options) *chunkRecorder {
+	rec := &chunkRecorder{}
+	w := NewWriter(rec, opts)
+	for i := 0; len(s) > 0; i++ {
+		n := 1
+		if len(sizes) > 0 {
+			n = int(sizes[i%len(sizes)]%8) + 1
+		}
+		n = min(n, len(s))
+		if _, err := w.Write([]byte(s[:n])); err != nil {
+			t.Fatal(err)
+		}
+		s = s[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestSplitRunes(t *testing.T) {
+	rec := writeSplit(t, "hello世界", []byte{5, 1, 3}, Options{}) // 大小依次为 6 2 4
+	want := []string{"hello", "世", "界"}
+	if strings.Join(rec.chunks, "|") != strings.Join(want, "|") {
+		t.Fatalf("chunks = %q, want %q", rec.chunks, want)
+	}
+}
+
+func TestInvalidBytes(t *testing.T) {
+	tests := map[string]string{
+		"a\xffb":     "a�b",
+		"世\xe7\x95":  "世��", // 末尾被截断的字符
+		"\xe7\x95x界": "��x界",
+	}
+	for in, want := range tests {
+		if got := writeSplit(t, in, []byte{0}, Options{}).String(); got != want {
+			t.Errorf("%q -> %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestGraphemes(t *testing.T) {
+	tests := []struct {
+		name, text string
+		want       []string // 逐个字符写入时，每次写到下层的内容
+	}{
+		{"skin tone", "👍🏽!", []string{"👍🏽", "!"}},
+		{"zwj family", "👨‍👩‍👧a", []string{"👨‍👩‍👧", "a"}},
+		{"flags", "🇨🇳🇯🇵", []string{"🇨🇳", "🇯🇵"}},
+		{"combining", "éx", []string{"é", "x"}},
+		{"crlf", "a\r\nb", []string{"a", "\r\n", "b"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := &chunkRecorder{}
+			w := NewWriter(rec, Options{Graphemes: true})
+			for _, r := range tt.text {
+				w.Write([]byte(string(r)))
+			}
+			w.Close()
+			if strings.Join(rec.chunks, "|") != strings.Join(tt.want, "|") {
+				t.Errorf("chunks = %q, want %q", rec.chunks, tt.want)
+			}
+		})
+	}
+}
+
+func TestFlushReleasesTail(t *testing.T) {
+	rec := &chunkRecorder{}
+	w := NewWriter(rec, Options{Graphemes: true})
+	w.Write([]byte("好\xe4\xb8"))
+	if rec.String() != "" {
+		t.Fatalf("wrote %q before the cluster could be complete", rec.String())
+	}
+	w.Flush()
+	if rec.String() != "好" {
+		t.Fatalf("after Flush got %q, want the held cluster but not the partial rune", rec.String())
+	}
+	w.Write([]byte("\x96"))
+	w.Close()
+	if rec.String() != "好世" {
+		t.Fatalf("got %q", rec.String())
+	}
+}
+
+// FuzzChunks 对混合 ASCII / 中文 / emoji / 无效字节的文本做随机切分：
+// 输出与整体解码的结果一致，每一块都是合法的 UTF-8，开启 Graphemes 时块不会从组合字符开始（除非前面是控制字符）
+func FuzzChunks(f *testing.F) {
+	f.Add("hello世界", []byte{1, 2, 3})
+	f.Add("流式输出👍🏽 done", []byte{0})
+	f.Add("👨‍👩‍👧🇨🇳é", []byte{7, 3})
+	f.Add("坏\xff字节\xe4\xb8", []byte{2})
+	f.Fuzz(func(t *testing.T, s string, sizes []byte) {
+		want := string([]rune(s)) // 每个无效字节替换为一个 U+FFFD
+		for _, graphemes := range []bool{false, true} {
+			rec := writeSplit(t, s, sizes, Options{Graphemes: graphemes})
+			if got := rec.String(); got != want {
+				t.Fatalf("graphemes=%v: output %q, want %q", graphemes, got, want)
+			}
+			for i, c := range rec.chunks {
+				if !utf8.ValidString(c) {
+					t.Fatalf("chunk %d %q is not valid UTF-8", i, c)
+				}
+				if i == 0 || !graphemes {
+					continue
+				}
+				// 控制字符之后总是边界（GB4），组合字符可以单独成簇
+				r, _ := utf8.DecodeRuneInString(c)
+				prev, _ := utf8.DecodeLastRuneInString(rec.chunks[i-1])
+				if (isExtend(r) || r == zwj) && !isControl(prev) {
+					t.Fatalf("chunk %d %q starts inside a grapheme cluster", i, c)
+				}
+			}
+		}
+	})
+}
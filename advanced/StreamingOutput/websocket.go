@@ -0,0 +1,242 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"go-learning/advanced/StreamingOutput/event"
+	"go-learning/advanced/StreamingOutput/generator"
+	"go-learning/advanced/StreamingOutput/ws"
+)
+
+// ============ WebSocket 双向流：同一连接上发送 stop / 新提示词 ============
+//
+// 客户端 → 服务端（文本帧）：
+//
+//	{"type":"prompt","prompt":"你好","generator":"echo"}  开始新的生成（会取消正在进行的生成）
+//	{"type":"cancel"}                                     取消当前生成
+//	纯文本 "stop" / "cancel" 等价于 cancel，其他纯文本作为新的提示词
+//
+// 服务端 → 客户端（文本帧）：每帧一个事件信封（见 event 包），序号在整个连接内递增
+//
+//	heartbeat          开始一次新的生成，stream 为这次生成的流ID
+//	delta              生成的token
+//	done + usage       生成结束，reason 为 complete / cancelled / error / shutdown
+//	error              错误；code 为 rate_limited 时 retry_after_ms 毫秒后再试，
+//	                   为 shutting_down 时服务器即将关闭，随后以 1001 关闭连接
+
+// wsPingInterval 是服务端发送 ping 的间隔，超过两个间隔没有收到任何帧视为连接失效
+const wsPingInterval = 30 * time.Second
+
+type wsClientMessage struct {
+	Type      string `json:"type"`
+	Prompt    string `json:"prompt,omitempty"`
+	Generator string `json:"generator,omitempty"`
+}
+
+// wsHandler 处理 /stream/ws
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	logger := loggerFrom(r.Context())
+	// socket 路由不经过 withCORS，跨站来源按同一份 -cors-origins 配置检查
+	conn, err := ws.Upgrade(w, r, func(origin string) bool {
+		_, ok := allowedOrigin("/stream/ws", origin)
+		return ok
+	})
+	if err != nil {
+		logger.Warn("握手失败", "err", err)
+		return
+	}
+	defer conn.Close(ws.CloseGoingAway, "server closing")
+	logger.Info("客户端连接")
+
+	// Hijack 之后请求的 Context 在处理器返回前一直有效，优雅关闭超时时会被取消
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+
+	// 读goroutine：把客户端消息转交给主循环，连接断开时关闭 msgs
+	msgs := make(chan wsClientMessage)
+	go func() {
+		defer close(msgs)
+		for {
+			conn.SetReadDeadline(time.Now().Add(2 * wsPingInterval))
+			op, data, err := conn.ReadMessage()
+			if err != nil {
+				var ce *ws.CloseError
+				if errors.As(err, &ce) {
+					logger.Info("连接关闭", "code", ce.Code, "reason", ce.Reason)
+				} else {
+					logger.Warn("读取失败", "err", err)
+				}
+				return
+			}
+			if op != ws.OpText {
+				conn.Close(ws.CloseUnsupportedData, "text frames only")
+				return
+			}
+			select {
+			case msgs <- parseWSMessage(data):
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	// st 为整个连接编号，每次生成通过 Restart 换成新的流ID并重新统计用量
+	st := event.NewStream(event.Options{})
+	send := func(envs ...event.Envelope) error {
+		for _, env := range envs {
+			data, _ := json.Marshal(env)
+			messagesSent.With("/stream/ws").Inc()
+			if err := conn.WriteText(string(data)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	ping := time.NewTicker(wsPingInterval)
+	defer ping.Stop()
+
+	// cur 是当前正在进行的生成，nil 表示空闲
+	var cur *wsGeneration
+	defer func() {
+		if cur != nil {
+			cur.stop()
+		}
+	}()
+
+	for {
+		// 空闲时 tokens 为 nil，nil 通道在 select 中永远阻塞
+		var tokens <-chan string
+		if cur != nil {
+			tokens = cur.pipe.Tokens()
+		}
+
+		select {
+		case msg, ok := <-msgs:
+			if !ok {
+				// 读goroutine退出：客户端关闭或连接失效
+				stage := stageIdle
+				if cur != nil {
+					stage = stageMidStream
+				}
+				clientDisconnects.With("/stream/ws", stage).Inc()
+				return
+			}
+			switch msg.Type {
+			case "cancel":
+				if cur == nil {
+					continue
+				}
+				cur.stop()
+				send(st.End(event.ReasonCancelled, "")...)
+				cur.logger.Info("客户端取消生成", "tokens", st.Tokens())
+				cur = nil
+
+			case "prompt":
+				name := msg.Generator
+				if name == "" {
+					name = cfg.Generator
+				}
+				gen, err := generators.Get(name)
+				if err != nil {
+					send(st.Error("unknown_generator", err.Error(), 0))
+					continue
+				}
+				// 连接已建立，每个新提示词按同一个客户端的令牌桶限流
+				if retry, err := sharedLimiter().Allow(clientKey(r)); err != nil {
+					rejectedRequests.With("/stream/ws", "rate").Inc()
+					send(st.Error("rate_limited", err.Error(), retry))
+					continue
+				}
+				if cur != nil {
+					// 新提示词打断正在进行的生成
+					cur.stop()
+					send(st.End(event.ReasonCancelled, "")...)
+				}
+
+				// 同一连接上的每次生成是一个独立的流，有自己的流ID
+				id := newID(6)
+				st.Restart(id)
+				genCtx, genCancel := context.WithCancel(withStream(ctx, id))
+				cur = &wsGeneration{
+					pipe:   generateWithPipeline(genCtx, gen, msg.Prompt, generator.Options{}),
+					cancel: genCancel,
+					logger: loggerFrom(genCtx),
+				}
+				cur.logger.Info("开始生成", "generator", name, "prompt", msg.Prompt)
+				send(st.Heartbeat())
+
+			default:
+				send(st.Error("bad_request", "unknown message type: "+msg.Type, 0))
+			}
+
+		case token, ok := <-tokens:
+			if !ok {
+				if err := cur.pipe.Err(); err != nil {
+					send(st.Error("generation_failed", err.Error(), 0))
+					send(st.End(event.ReasonError, err.Error())...)
+				} else {
+					send(st.End(event.ReasonComplete, "")...)
+				}
+				cur.cancel()
+				cur = nil
+				continue
+			}
+			tokensSent.With("/stream/ws").Inc()
+			if err := send(st.Delta(token)); err != nil {
+				logger.Warn("发送失败", "err", err)
+				return
+			}
+
+		case <-ping.C:
+			if err := conn.Ping(nil); err != nil {
+				return
+			}
+
+		case <-draining():
+			// 通知客户端后以 1001 Going Away 关闭，客户端可稍后重连
+			send(st.Error("shutting_down", errShuttingDown.Error(), 0))
+			if cur != nil {
+				send(st.End(event.ReasonShutdown, "")...)
+			}
+			conn.Close(ws.CloseGoingAway, "server shutting down")
+			return
+
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// wsGeneration 是WebSocket连接上的一次生成
+type wsGeneration struct {
+	pipe   *Pipeline
+	cancel context.CancelFunc
+	logger *slog.Logger
+}
+
+// stop 取消生成并等待生产者退出
+func (g *wsGeneration) stop() {
+	g.cancel()
+	<-g.pipe.Done()
+}
+
+// parseWSMessage 解析客户端消息，非JSON的纯文本按 stop/cancel 或新提示词处理
+func parseWSMessage(data []byte) wsClientMessage {
+	var msg wsClientMessage
+	if err := json.Unmarshal(data, &msg); err == nil && msg.Type != "" {
+		return msg
+	}
+	text := strings.TrimSpace(string(data))
+	switch strings.ToLower(text) {
+	case "stop", "cancel":
+		return wsClientMessage{Type: "cancel"}
+	}
+	return wsClientMessage{Type: "prompt", Prompt: text}
+}
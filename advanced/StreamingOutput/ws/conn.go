@@ -0,0 +1,292 @@
+package ws
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// 帧操作码
+const (
+	OpContinuation = 0x0
+	OpText         = 0x1
+	OpBinary       = 0x2
+	OpClose        = 0x8
+	OpPing         = 0x9
+	OpPong         = 0xA
+)
+
+// 常用关闭码（RFC 6455 7.4.1）
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseUnsupportedData = 1003
+	CloseNoStatus        = 1005
+	CloseInvalidPayload  = 1007
+	ClosePolicyViolation = 1008
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+)
+
+// DefaultMaxMessageSize 是单条消息（合并分片后）的默认大小上限
+const DefaultMaxMessageSize = 1 << 20
+
+// CloseError 表示对端发来了 close 帧
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("ws: closed with code %d: %s", e.Code, e.Reason)
+}
+
+// ErrClosed 表示本端已经发送过 close 帧，连接不可再写
+var ErrClosed = errors.New("ws: connection closed")
+
+// Conn 是一条 WebSocket 连接
+// 读操作只能在一个goroutine中进行；写操作是并发安全的
+type Conn struct {
+	conn     net.Conn
+	br       *bufio.Reader
+	isServer bool // 服务端写出的帧不加掩码，读到的帧必须有掩码
+
+	// MaxMessageSize 限制单条消息大小，超过时以 1009 关闭连接
+	MaxMessageSize int64
+
+	writeMu sync.Mutex
+	closed  bool // 已发送 close 帧
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, isServer bool) *Conn {
+	if br == nil {
+		br = bufio.NewReader(conn)
+	}
+	return &Conn{conn: conn, br: br, isServer: isServer, MaxMessageSize: DefaultMaxMessageSize}
+}
+
+// RemoteAddr 返回对端地址
+func (c *Conn) RemoteAddr() net.Addr { return c.conn.RemoteAddr() }
+
+// SetReadDeadline 设置读超时，配合 ping 做心跳检测
+func (c *Conn) SetReadDeadline(t time.Time) error { return c.conn.SetReadDeadline(t) }
+
+// ReadMessage 读取下一条完整的数据消息（text 或 binary），自动处理控制帧：
+//   - ping：回复 pong
+//   - pong：忽略
+//   - close：回复 close 后返回 *CloseError
+func (c *Conn) ReadMessage() (opcode int, data []byte, err error) {
+	var msgOp int
+	var buf []byte
+	for {
+		fin, op, payload, err := c.readFrame()
+		if err != nil {
+			return 0, nil, err
+		}
+
+		switch op {
+		case OpPing:
+			if err := c.WriteMessage(OpPong, payload); err != nil && err != ErrClosed {
+				return 0, nil, err
+			}
+			continue
+		case OpPong:
+			continue
+		case OpClose:
+			return 0, nil, c.handleClose(payload)
+		case OpText, OpBinary:
+			if msgOp != 0 {
+				return 0, nil, c.fail(CloseProtocolError, "expected continuation frame")
+			}
+			msgOp = op
+		case OpContinuation:
+			if msgOp == 0 {
+				return 0, nil, c.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+		default:
+			return 0, nil, c.fail(CloseProtocolError, fmt.Sprintf("unknown opcode %d", op))
+		}
+
+		if int64(len(buf)+len(payload)) > c.MaxMessageSize {
+			return 0, nil, c.fail(CloseMessageTooBig, "message too big")
+		}
+		buf = append(buf, payload...)
+		if !fin {
+			continue
+		}
+		if msgOp == OpText && !utf8.Valid(buf) {
+			return 0, nil, c.fail(CloseInvalidPayload, "invalid UTF-8 in text message")
+		}
+		return msgOp, buf, nil
+	}
+}
+
+// readFrame 读取一帧并去掉掩码
+func (c *Conn) readFrame() (fin bool, op int, payload []byte, err error) {
+	var head [2]byte
+	if _, err = io.ReadFull(c.br, head[:]); err != nil {
+		return
+	}
+	fin = head[0]&0x80 != 0
+	if head[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(CloseProtocolError, "reserved bits set")
+	}
+	op = int(head[0] & 0x0F)
+	masked := head[1]&0x80 != 0
+	length := int64(head[1] & 0x7F)
+
+	if c.isServer && !masked {
+		return false, 0, nil, c.fail(CloseProtocolError, "client frames must be masked")
+	}
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err = io.ReadFull(c.br, ext[:]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint64(ext[:]))
+	}
+
+	// 控制帧不能分片且负载不超过125字节
+	if op >= OpClose && (!fin || length > 125) {
+		return false, 0, nil, c.fail(CloseProtocolError, "invalid control frame")
+	}
+	if length < 0 || length > c.MaxMessageSize {
+		return false, 0, nil, c.fail(CloseMessageTooBig, "frame too big")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, op, payload, nil
+}
+
+// handleClose 回复 close 帧并关闭底层连接
+func (c *Conn) handleClose(payload []byte) error {
+	ce := &CloseError{Code: CloseNoStatus}
+	if len(payload) >= 2 {
+		ce.Code = int(binary.BigEndian.Uint16(payload))
+		ce.Reason = string(payload[2:])
+	}
+	c.writeClose(ce.Code, "")
+	c.conn.Close()
+	return ce
+}
+
+// fail 以指定关闭码关闭连接并返回对应错误
+func (c *Conn) fail(code int, reason string) error {
+	c.writeClose(code, reason)
+	c.conn.Close()
+	return &CloseError{Code: code, Reason: reason}
+}
+
+// WriteText 发送一条文本消息
+func (c *Conn) WriteText(s string) error {
+	return c.WriteMessage(OpText, []byte(s))
+}
+
+// Ping 发送 ping 帧，对端应回复 pong
+func (c *Conn) Ping(data []byte) error {
+	return c.WriteMessage(OpPing, data)
+}
+
+// WriteMessage 以单帧发送一条消息
+func (c *Conn) WriteMessage(op int, data []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	return c.writeFrame(op, data)
+}
+
+// Close 发送 close 帧并关闭底层连接
+func (c *Conn) Close(code int, reason string) error {
+	c.writeClose(code, reason)
+	return c.conn.Close()
+}
+
+func (c *Conn) writeClose(code int, reason string) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return
+	}
+	c.closed = true
+
+	var payload []byte
+	if code != CloseNoStatus {
+		if len(reason) > 123 {
+			reason = reason[:123]
+		}
+		payload = make([]byte, 2+len(reason))
+		binary.BigEndian.PutUint16(payload, uint16(code))
+		copy(payload[2:], reason)
+	}
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.writeFrame(OpClose, payload)
+}
+
+// writeFrame 写出一个 FIN 帧，调用者需持有 writeMu
+func (c *Conn) writeFrame(op int, data []byte) error {
+	header := make([]byte, 0, 14)
+	header = append(header, 0x80|byte(op))
+
+	var maskBit byte
+	if !c.isServer {
+		maskBit = 0x80
+	}
+	switch n := len(data); {
+	case n <= 125:
+		header = append(header, maskBit|byte(n))
+	case n <= 0xFFFF:
+		header = append(header, maskBit|126)
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header = append(header, maskBit|127)
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	if !c.isServer {
+		// 客户端发出的帧必须加掩码
+		var mask [4]byte
+		rand.Read(mask[:])
+		header = append(header, mask[:]...)
+		masked := make([]byte, len(data))
+		for i := range data {
+			masked[i] = data[i] ^ mask[i%4]
+		}
+		data = masked
+	}
+
+	// 头部和负载合并为一次写入
+	_, err := c.conn.Write(append(header, data...))
+	return err
+}
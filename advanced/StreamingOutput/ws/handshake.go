@@ -0,0 +1,116 @@
+// Package ws 只用标准库实现 RFC 6455 WebSocket 的服务端握手与帧读写
+//
+// 支持的内容：
+//   - HTTP/1.1 Upgrade 握手（Sec-WebSocket-Key / Sec-WebSocket-Accept）
+//   - 文本帧、二进制帧及分片消息（continuation）
+//   - ping / pong 控制帧（收到 ping 自动回复 pong）
+//   - close 帧及关闭码（收到 close 自动回复并返回 *CloseError）
+//
+// 不支持扩展（permessage-deflate）和子协议协商。
+package ws
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// websocketGUID 是 RFC 6455 规定的固定 GUID，用于计算 Sec-WebSocket-Accept
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// ErrBadHandshake 表示请求不是合法的 WebSocket 握手
+var ErrBadHandshake = errors.New("ws: bad handshake")
+
+// ErrBadOrigin 表示请求的 Origin 不被允许
+var ErrBadOrigin = errors.New("ws: origin not allowed")
+
+// IsUpgrade 判断请求是否要求升级为 WebSocket
+func IsUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") &&
+		headerContains(r.Header, "Upgrade", "websocket")
+}
+
+// Upgrade 完成握手并接管底层连接
+// 握手失败时已向客户端写出错误响应，调用者直接返回即可。
+// 浏览器的 WebSocket 不受同源策略限制，会带上目标站点的 Cookie 等凭据，因此要检查 Origin：
+// 没有 Origin（非浏览器客户端）和与 Host 同源的请求总是允许，其他来源由 allowOrigin 判断，
+// allowOrigin 为 nil 时只允许同源；不允许时返回 403
+func Upgrade(w http.ResponseWriter, r *http.Request, allowOrigin func(origin string) bool) (*Conn, error) {
+	if r.Method != http.MethodGet || !IsUpgrade(r) {
+		http.Error(w, "websocket upgrade required", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	if !checkOrigin(r, allowOrigin) {
+		http.Error(w, "websocket origin not allowed", http.StatusForbidden)
+		return nil, ErrBadOrigin
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusUpgradeRequired)
+		return nil, ErrBadHandshake
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if decoded, err := base64.StdEncoding.DecodeString(key); err != nil || len(decoded) != 16 {
+		http.Error(w, "invalid Sec-WebSocket-Key", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "websocket unsupported", http.StatusInternalServerError)
+		return nil, errors.New("ws: response does not implement http.Hijacker")
+	}
+	netConn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	// 接管之后只能直接写原始的 HTTP 响应
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + AcceptKey(key) + "\r\n\r\n"
+	if _, err := brw.WriteString(resp); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	if err := brw.Flush(); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+
+	return newConn(netConn, brw.Reader, true), nil
+}
+
+// checkOrigin 判断请求的 Origin 是否允许升级
+func checkOrigin(r *http.Request, allowOrigin func(origin string) bool) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	return allowOrigin != nil && allowOrigin(origin)
+}
+
+// AcceptKey 计算 Sec-WebSocket-Accept：base64(sha1(key + GUID))
+func AcceptKey(key string) string {
+	h := sha1.Sum([]byte(key + websocketGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// headerContains 判断逗号分隔的头部值中是否包含 token（不区分大小写）
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, part := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
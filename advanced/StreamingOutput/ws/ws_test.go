@@ -0,0 +1,100 @@
+package ws
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAcceptKey(t *testing.T) {
+	// RFC 6455 1.3 中的示例
+	if got := AcceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("AcceptKey() = %q", got)
+	}
+}
+
+func TestConnRoundTrip(t *testing.T) {
+	a, b := net.Pipe()
+	server := newConn(a, nil, true)
+	client := newConn(b, nil, false)
+
+	go func() {
+		client.WriteText("你好")
+		client.Ping([]byte("p"))
+		client.WriteMessage(OpBinary, make([]byte, 70000)) // 64位长度
+		client.Close(CloseNormal, "bye")
+	}()
+
+	op, data, err := server.ReadMessage()
+	if err != nil || op != OpText || string(data) != "你好" {
+		t.Fatalf("ReadMessage() = %d %q %v", op, data, err)
+	}
+
+	// 服务端在读取下一条消息时会自动回复 pong，客户端需要读取它
+	go func() {
+		for {
+			if _, _, err := client.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	op, data, err = server.ReadMessage()
+	if err != nil || op != OpBinary || len(data) != 70000 {
+		t.Fatalf("ReadMessage() = %d len=%d %v", op, len(data), err)
+	}
+
+	_, _, err = server.ReadMessage()
+	var ce *CloseError
+	if !errors.As(err, &ce) || ce.Code != CloseNormal || ce.Reason != "bye" {
+		t.Errorf("ReadMessage() error = %v, want close 1000", err)
+	}
+}
+
+func TestUnmaskedClientFrame(t *testing.T) {
+	a, b := net.Pipe()
+	server := newConn(a, nil, true)
+	fake := newConn(b, nil, true) // 以服务端身份写出，帧不带掩码
+
+	go func() {
+		fake.WriteText("x")
+		fake.ReadMessage() // 读取服务端回复的 close 帧
+	}()
+
+	_, _, err := server.ReadMessage()
+	var ce *CloseError
+	if !errors.As(err, &ce) || ce.Code != CloseProtocolError {
+		t.Errorf("ReadMessage() error = %v, want close 1002", err)
+	}
+}
+
+func TestUpgradeOrigin(t *testing.T) {
+	allow := func(origin string) bool { return origin == "https://app.example.com" }
+	tests := []struct {
+		origin    string
+		forbidden bool
+	}{
+		{"", false},                        // 非浏览器客户端
+		{"http://example.com", false},      // 同源
+		{"https://app.example.com", false}, // allowOrigin 允许
+		{"https://evil.example.com", true},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "http://example.com/ws", nil)
+		req.Header.Set("Connection", "Upgrade")
+		req.Header.Set("Upgrade", "websocket")
+		req.Header.Set("Sec-WebSocket-Version", "13")
+		req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		// ResponseRecorder 不支持 Hijack，通过 Origin 检查的请求在接管连接时失败
+		rec := httptest.NewRecorder()
+		_, err := Upgrade(rec, req, allow)
+		if errors.Is(err, ErrBadOrigin) != tt.forbidden || (rec.Code == http.StatusForbidden) != tt.forbidden {
+			t.Errorf("origin %q: status %d, err %v", tt.origin, rec.Code, err)
+		}
+	}
+}
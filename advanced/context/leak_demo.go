@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"fmt"
+	"log/slog"
 	"net/http"
 	_ "net/http/pprof" // 导入pprof用于性能分析
 	"os"
@@ -12,8 +13,24 @@ import (
 	"time"
 )
 
+// setupLogger 使用 log/slog 输出结构化日志
+// LOG_FORMAT=json 输出JSON，LOG_LEVEL=debug 可以看到每个任务的清理日志
+func setupLogger() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL"))); err != nil {
+		level = slog.LevelInfo
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler = slog.NewTextHandler(os.Stdout, opts)
+	if os.Getenv("LOG_FORMAT") == "json" {
+		h = slog.NewJSONHandler(os.Stdout, opts)
+	}
+	slog.SetDefault(slog.New(h))
+}
+
 // 模拟一个消耗资源的任务
 func leakyTask(ctx context.Context, id int) {
+	logger := slog.With("task", id)
 	// 分配一些内存（模拟资源占用）
 	buffer := make([]byte, 1024*1024) // 1MB
 	ticker := time.NewTicker(1 * time.Second)
@@ -21,13 +38,13 @@ func leakyTask(ctx context.Context, id int) {
 		ticker.Stop()
 		// 清理资源
 		buffer = nil
-		fmt.Printf("[Task %d] 资源已清理\n", id)
+		logger.Debug("资源已清理")
 	}()
 
 	for {
 		select {
 		case <-ctx.Done():
-			fmt.Printf("[Task %d] 收到退出信号，正在清理...\n", id)
+			logger.Info("收到退出信号，正在清理", "reason", ctx.Err())
 			return
 		case <-ticker.C:
 			// 模拟工作（访问buffer防止被优化掉）
@@ -52,9 +69,8 @@ func demoWithLeak() {
 
 	for {
 		select {
-		case <-sigChan:
-			fmt.Println("\n收到退出信号，程序即将退出...")
-			fmt.Println("注意：所有泄漏的goroutine将被强制终止")
+		case sig := <-sigChan:
+			slog.Warn("收到退出信号，所有泄漏的goroutine将被强制终止", "signal", sig.String(), "goroutines", runtime.NumGoroutine())
 			return
 
 		case <-ticker.C:
@@ -68,10 +84,10 @@ func demoWithLeak() {
 			// 打印统计信息
 			var m runtime.MemStats
 			runtime.ReadMemStats(&m)
-			fmt.Printf("已启动 %d 个goroutine | 当前goroutine数: %d | 内存使用: %.2f MB\n",
-				counter,
-				runtime.NumGoroutine(),
-				float64(m.Alloc)/1024/1024)
+			slog.Info("启动任务",
+				"started", counter,
+				"goroutines", runtime.NumGoroutine(),
+				"alloc_mb", float64(m.Alloc)/1024/1024)
 		}
 	}
 }
@@ -99,27 +115,26 @@ func demoWithoutLeak() {
 
 	for {
 		select {
-		case <-sigChan:
-			fmt.Println("\n收到退出信号，正在优雅退出...")
+		case sig := <-sigChan:
+			slog.Info("收到退出信号，正在优雅退出", "signal", sig.String(), "active", len(cancels))
 			// 取消所有goroutine
-			for i, cancel := range cancels {
-				fmt.Printf("正在停止 Task %d...\n", i+1)
+			for _, cancel := range cancels {
 				cancel()
 			}
 			time.Sleep(500 * time.Millisecond) // 等待清理完成
-			fmt.Println("所有资源已清理完毕")
+			slog.Info("所有资源已清理完毕", "goroutines", runtime.NumGoroutine())
 			return
 
 		case <-cleanupTicker.C:
 			// 每10秒清理前面的goroutine
 			if len(cancels) > 3 {
-				fmt.Println("\n--- 开始清理旧的goroutine ---")
+				slog.Info("开始清理旧的goroutine", "count", 2)
 				for i := 0; i < 2 && i < len(cancels); i++ {
 					cancels[i]()
 				}
 				cancels = cancels[2:]
 				time.Sleep(200 * time.Millisecond)
-				fmt.Println("--- 清理完成 ---\n")
+				slog.Info("清理完成", "goroutines", runtime.NumGoroutine())
 			}
 
 		case <-ticker.C:
@@ -132,22 +147,23 @@ func demoWithoutLeak() {
 			// 打印统计信息
 			var m runtime.MemStats
 			runtime.ReadMemStats(&m)
-			fmt.Printf("已启动 %d 个goroutine | 当前goroutine数: %d | 内存使用: %.2f MB | 活跃任务: %d\n",
-				counter,
-				runtime.NumGoroutine(),
-				float64(m.Alloc)/1024/1024,
-				len(cancels))
+			slog.Info("启动任务",
+				"started", counter,
+				"goroutines", runtime.NumGoroutine(),
+				"alloc_mb", float64(m.Alloc)/1024/1024,
+				"active", len(cancels))
 		}
 	}
 }
 
 func main() {
+	setupLogger()
+
 	// 启动pprof服务器，用于性能分析
 	go func() {
-		fmt.Println("pprof服务已启动: http://localhost:6060/debug/pprof/")
-		fmt.Println()
+		slog.Info("pprof服务已启动", "url", "http://localhost:6060/debug/pprof/")
 		if err := http.ListenAndServe("localhost:6060", nil); err != nil {
-			fmt.Printf("pprof服务启动失败: %v\n", err)
+			slog.Error("pprof服务启动失败", "err", err)
 		}
 	}()
 
@@ -164,6 +180,8 @@ func main() {
 		fmt.Println("  leak   - 演示资源泄漏（不调用cancel）")
 		fmt.Println("  normal - 演示正常情况（调用cancel）")
 		fmt.Println()
+		fmt.Println("环境变量：LOG_FORMAT=json 输出JSON日志，LOG_LEVEL=debug 显示任务清理日志")
+		fmt.Println()
 		fmt.Println("监控命令：")
 		fmt.Println("  1. 实时查看goroutine数量:")
 		fmt.Println("     watch -n 1 'curl -s http://localhost:6060/debug/pprof/goroutine?debug=1 | grep \"goroutine profile\"'")
@@ -176,4 +194,3 @@ func main() {
 		fmt.Println("     go tool pprof http://localhost:6060/debug/pprof/heap")
 	}
 }
-
@@ -1,4 +1,5 @@
-package main
+// Package httpconfig 提供生产环境调优过的 http.Client：连接池与各阶段超时
+package httpconfig
 
 import (
 	"net"
@@ -6,8 +7,10 @@ import (
 	"time"
 )
 
-func createProductionClient() *http.Client {
-	transport := &http.Transport{
+// NewProductionTransport 返回调优过连接池和超时的 Transport
+// 流式客户端应直接使用它：http.Client.Timeout 包含读取响应体的时间，会截断长时间的流
+func NewProductionTransport() *http.Transport {
+	return &http.Transport{
 		// 连接池配置
 		MaxIdleConns:        100,
 		MaxIdleConnsPerHost: 25, // 并发请求数的1/3到1/2
@@ -23,9 +26,12 @@ func createProductionClient() *http.Client {
 		TLSHandshakeTimeout:   5 * time.Second,  // TLS握手超时
 		ResponseHeaderTimeout: 10 * time.Second, // 读取响应头超时
 	}
+}
 
+// CreateProductionClient 返回用于普通请求的 http.Client
+func CreateProductionClient() *http.Client {
 	return &http.Client{
-		Transport: transport,
+		Transport: NewProductionTransport(),
 		Timeout:   30 * time.Second, // 整体请求超时
 	}
 }